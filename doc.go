@@ -9,6 +9,17 @@
 //   - Clone and buffer HTTP response bodies
 //   - Create RoundTripper implementations from functions
 //   - Preserve error semantics when manipulating response bodies
+//   - Client-side middleware transports (metrics, circuit breaking, rate limiting, request coalescing, hedging, fault injection, HAR capture and replay, authentication, request signing, request compression, response decompression, digest verification, Content-Length enforcement, per-attempt timeouts, request defaults, ...)
+//   - Resumable and parallel downloads with Range requests
+//   - Streaming bodies (Server-Sent Events, NDJSON, JSON text sequences)
+//   - Typed JSON request helpers
+//   - Link header and cursor pagination iterators
+//   - Conditional GET revalidation with ETag and Last-Modified
+//   - W3C Trace Context propagation for clients and servers
+//   - Idempotency keys for clients and request deduplication for servers
+//
+// httpaux/httpauxtest - Testing utilities for HTTP round trippers:
+//   - Assert that a RoundTripper never mutates the request it is given
 //
 // ioaux - I/O auxiliary utilities and adapters:
 //   - Function adapters (ReaderFunc, CloserFunc) for implementing io interfaces
@@ -0,0 +1,73 @@
+package httpaux
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// APIKeyConfig configures TransportWithAPIKey. Exactly one of Header and QueryParam should be set.
+type APIKeyConfig struct {
+	// Header is the name of the request header that carries the key.
+	Header string
+	// QueryParam is the name of the URL query parameter that carries the key.
+	QueryParam string
+	// Value is the API key.
+	Value string
+}
+
+// TransportWithBasicAuth wraps next with an http.RoundTripper that sets HTTP Basic authentication credentials on a
+// clone of every request. A nil next uses http.DefaultTransport.
+func TransportWithBasicAuth(next http.RoundTripper, username, password string) http.RoundTripper {
+	next = transportOrDefault(next)
+
+	return RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		authenticated := req.Clone(req.Context())
+		authenticated.SetBasicAuth(username, password)
+
+		return next.RoundTrip(authenticated)
+	})
+}
+
+// TransportWithAPIKey wraps next with an http.RoundTripper that adds an API key, as a header or as a URL query
+// parameter, to a clone of every request. The query parameter replaces any the request has and is appended to the
+// raw query, so the other parameters keep their order and encoding. A nil next uses http.DefaultTransport.
+func TransportWithAPIKey(next http.RoundTripper, config APIKeyConfig) http.RoundTripper {
+	if (config.Header == "") == (config.QueryParam == "") {
+		panic("exactly one of config.Header and config.QueryParam must be set")
+	}
+
+	next = transportOrDefault(next)
+
+	return RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		authenticated := req.Clone(req.Context())
+
+		if config.Header != "" {
+			authenticated.Header.Set(config.Header, config.Value)
+		} else {
+			authenticated.URL.RawQuery = setRawQueryParam(authenticated.URL.RawQuery, config.QueryParam, config.Value)
+		}
+
+		return next.RoundTrip(authenticated)
+	})
+}
+
+// setRawQueryParam drops the name parameters of rawQuery and appends name=value, leaving the others untouched.
+func setRawQueryParam(rawQuery, name, value string) string {
+	params := make([]string, 0, strings.Count(rawQuery, "&")+1)
+
+	for param := range strings.SplitSeq(rawQuery, "&") {
+		if param == "" {
+			continue
+		}
+
+		key, _, _ := strings.Cut(param, "=")
+		if unescaped, err := url.QueryUnescape(key); err == nil && unescaped == name {
+			continue
+		}
+
+		params = append(params, param)
+	}
+
+	return strings.Join(append(params, url.QueryEscape(name)+"="+url.QueryEscape(value)), "&")
+}
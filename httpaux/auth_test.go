@@ -0,0 +1,102 @@
+package httpaux
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/angrifel/unapologetic/internal/assert"
+)
+
+func TestTransportWithBasicAuth(t *testing.T) {
+	// arrange
+	var sent *http.Request
+
+	rt := TransportWithBasicAuth(RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		sent = req
+
+		return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader(""))}, nil
+	}), "gopher", "s3cr3t")
+	req := httptest.NewRequest(http.MethodGet, "http://a.example/", nil)
+
+	// act
+	resp, err := rt.RoundTrip(req)
+
+	// assert
+	username, password, ok := sent.BasicAuth()
+
+	assert.Equal(t, nil, err)
+	assert.Equal(t, true, ok)
+	assert.Equal(t, "gopher", username)
+	assert.Equal(t, "s3cr3t", password)
+	assert.Equal(t, "", req.Header.Get("Authorization"))
+
+	_ = resp.Body.Close()
+}
+
+func TestTransportWithAPIKey(t *testing.T) {
+	var sent *http.Request
+
+	next := RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		sent = req
+
+		return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader(""))}, nil
+	})
+
+	t.Run("in a header", func(t *testing.T) {
+		// arrange
+		rt := TransportWithAPIKey(next, APIKeyConfig{Header: "X-Api-Key", Value: "k1"})
+		req := httptest.NewRequest(http.MethodGet, "http://a.example/", nil)
+
+		// act
+		resp, err := rt.RoundTrip(req)
+
+		// assert
+		assert.Equal(t, nil, err)
+		assert.Equal(t, "k1", sent.Header.Get("X-Api-Key"))
+		assert.Equal(t, "", req.Header.Get("X-Api-Key"))
+
+		_ = resp.Body.Close()
+	})
+
+	t.Run("in the query string", func(t *testing.T) {
+		// arrange
+		rt := TransportWithAPIKey(next, APIKeyConfig{QueryParam: "api_key", Value: "k 2"})
+		req := httptest.NewRequest(http.MethodGet, "http://a.example/items?page=2", nil)
+
+		// act
+		resp, err := rt.RoundTrip(req)
+
+		// assert
+		assert.Equal(t, nil, err)
+		assert.Equal(t, "page=2&api_key=k+2", sent.URL.RawQuery)
+		assert.Equal(t, "page=2", req.URL.RawQuery)
+
+		_ = resp.Body.Close()
+	})
+
+	t.Run("keeps the order and encoding of the other parameters", func(t *testing.T) {
+		// arrange
+		rt := TransportWithAPIKey(next, APIKeyConfig{QueryParam: "api_key", Value: "k3"})
+		req := httptest.NewRequest(http.MethodGet, "http://a.example/items?z=1&api_key=old&a=%2f&flag", nil)
+
+		// act
+		resp, err := rt.RoundTrip(req)
+
+		// assert
+		assert.Equal(t, nil, err)
+		assert.Equal(t, "z=1&a=%2f&flag&api_key=k3", sent.URL.RawQuery)
+
+		_ = resp.Body.Close()
+	})
+
+	t.Run("panics unless exactly one location is set", func(t *testing.T) {
+		defer func() {
+			assert.NotEqual(t, nil, recover())
+		}()
+
+		TransportWithAPIKey(next, APIKeyConfig{Header: "X-Api-Key", QueryParam: "api_key"})
+	})
+}
@@ -0,0 +1,185 @@
+package httpaux
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const defaultTokenExpiryLeeway = 10 * time.Second
+
+// Token is a bearer token along with its expiry.
+type Token struct {
+	// Value is the token sent in the Authorization header.
+	Value string
+	// Expiry is when the token stops being valid. The zero value means the token never expires.
+	Expiry time.Time
+}
+
+// expiresWithin reports whether the token expires within leeway of now.
+func (t Token) expiresWithin(now time.Time, leeway time.Duration) bool {
+	return !t.Expiry.IsZero() && !now.Add(leeway).Before(t.Expiry)
+}
+
+// TokenSource supplies bearer tokens. Implementations must be safe for concurrent use.
+type TokenSource interface {
+	// Token returns a fresh token.
+	Token(ctx context.Context) (Token, error)
+}
+
+// TokenSourceFunc is an adapter type to allow the use of a function as a TokenSource.
+type TokenSourceFunc func(ctx context.Context) (Token, error)
+
+var (
+	_ TokenSource       = TokenSourceFunc(nil)
+	_ http.RoundTripper = (*bearerTransport)(nil)
+)
+
+// Token calls the underlying function.
+func (f TokenSourceFunc) Token(ctx context.Context) (Token, error) { return f(ctx) }
+
+// StaticToken returns a TokenSource that always supplies the same, never expiring, token.
+func StaticToken(value string) TokenSource {
+	return TokenSourceFunc(func(context.Context) (Token, error) {
+		return Token{Value: value, Expiry: time.Time{}}, nil
+	})
+}
+
+// BearerConfig configures TransportWithBearerToken.
+type BearerConfig struct {
+	// Source supplies the tokens. It is required.
+	Source TokenSource
+	// ExpiryLeeway is how long before its expiry a cached token is refreshed. Defaults to 10s.
+	ExpiryLeeway time.Duration
+	// Now returns the current time. Defaults to time.Now.
+	Now func() time.Time
+}
+
+type bearerTransport struct {
+	next  http.RoundTripper
+	cache *tokenCache
+}
+
+// TransportWithBearerToken wraps next with an http.RoundTripper that sets the Authorization header of every request
+// to a bearer token obtained from config.Source. Tokens are cached until they are about to expire and concurrent
+// refreshes are coalesced into a single call to the source.
+//
+// When a response is 401 Unauthorized, the token is refreshed and, if the source supplies a different token and the
+// request body can be replayed, the request is retried once. Requests are cloned, never mutated.
+// A nil next uses http.DefaultTransport.
+func TransportWithBearerToken(next http.RoundTripper, config BearerConfig) http.RoundTripper {
+	if config.Source == nil {
+		panic("config.Source must not be nil")
+	}
+
+	if config.ExpiryLeeway == 0 {
+		config.ExpiryLeeway = defaultTokenExpiryLeeway
+	}
+
+	if config.Now == nil {
+		config.Now = time.Now
+	}
+
+	return &bearerTransport{
+		next:  transportOrDefault(next),
+		cache: &tokenCache{config: config, mu: sync.Mutex{}, token: nil, refresh: nil},
+	}
+}
+
+// RoundTrip sends an authenticated clone of the request, retrying once with a fresh token on 401.
+func (t *bearerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	token, err := t.cache.get(req.Context(), "")
+	if err != nil {
+		closeRequestBody(req)
+
+		return nil, err
+	}
+
+	resp, err := t.next.RoundTrip(withBearer(req, token))
+	if err != nil || resp.StatusCode != http.StatusUnauthorized {
+		return resp, err
+	}
+
+	fresh, refreshErr := t.cache.get(req.Context(), token.Value)
+	if refreshErr != nil || fresh.Value == token.Value {
+		return resp, nil //nolint:nilerr // the 401 response is more useful to the caller than the refresh error
+	}
+
+	retry, ok := rewindRequest(req)
+	if !ok {
+		return resp, nil
+	}
+
+	discardBody(resp.Body)
+
+	return t.next.RoundTrip(withBearer(retry, fresh))
+}
+
+func withBearer(req *http.Request, token Token) *http.Request {
+	authenticated := req.Clone(req.Context())
+	authenticated.Header.Set("Authorization", "Bearer "+token.Value)
+
+	return authenticated
+}
+
+// tokenCache caches the token of a TokenSource and coalesces concurrent refreshes.
+type tokenCache struct {
+	config BearerConfig
+
+	mu      sync.Mutex
+	token   *Token
+	refresh *tokenRefresh
+}
+
+type tokenRefresh struct {
+	done  chan struct{}
+	token Token
+	err   error
+}
+
+// get returns the cached token unless it is missing, about to expire or has the stale value, in which case it
+// waits for a refresh, starting one if none is in progress.
+func (c *tokenCache) get(ctx context.Context, stale string) (Token, error) {
+	c.mu.Lock()
+
+	if c.token != nil && c.token.Value != stale && !c.token.expiresWithin(c.config.Now(), c.config.ExpiryLeeway) {
+		token := *c.token
+		c.mu.Unlock()
+
+		return token, nil
+	}
+
+	refresh := c.refresh
+	if refresh == nil {
+		refresh = &tokenRefresh{done: make(chan struct{}), token: Token{Value: "", Expiry: time.Time{}}, err: nil}
+		c.refresh = refresh
+
+		go c.run(context.WithoutCancel(ctx), refresh)
+	}
+
+	c.mu.Unlock()
+
+	select {
+	case <-refresh.done:
+		return refresh.token, refresh.err
+	case <-ctx.Done():
+		return Token{Value: "", Expiry: time.Time{}}, ctx.Err()
+	}
+}
+
+func (c *tokenCache) run(ctx context.Context, refresh *tokenRefresh) {
+	defer close(refresh.done)
+
+	refresh.token, refresh.err = c.config.Source.Token(ctx)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.refresh = nil
+
+	if refresh.err == nil {
+		token := refresh.token
+		c.token = &token
+	}
+}
@@ -0,0 +1,194 @@
+package httpaux
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/angrifel/unapologetic/internal/assert"
+)
+
+func TestTransportWithBearerToken(t *testing.T) {
+	type fixture struct {
+		fetches atomic.Int32
+		now     time.Time
+		valid   atomic.Value
+		seen    chan string
+	}
+
+	newFixture := func() (*fixture, http.RoundTripper) {
+		f := &fixture{now: time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC), seen: make(chan string, 16)}
+		f.valid.Store("")
+
+		source := TokenSourceFunc(func(context.Context) (Token, error) {
+			n := f.fetches.Add(1)
+
+			return Token{Value: "token-" + strconv.Itoa(int(n)), Expiry: f.now.Add(time.Hour)}, nil
+		})
+
+		rt := TransportWithBearerToken(RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			auth := req.Header.Get("Authorization")
+			body := ""
+
+			if req.Body != nil {
+				data, _ := io.ReadAll(req.Body)
+				body = string(data)
+			}
+
+			f.seen <- auth + "|" + body
+
+			status := http.StatusOK
+			if valid := f.valid.Load().(string); valid != "" && auth != "Bearer "+valid {
+				status = http.StatusUnauthorized
+			}
+
+			return &http.Response{StatusCode: status, Body: io.NopCloser(strings.NewReader(""))}, nil
+		}), BearerConfig{Source: source, Now: func() time.Time { return f.now }})
+
+		return f, rt
+	}
+
+	t.Run("caches the token until it is about to expire", func(t *testing.T) {
+		// arrange
+		f, rt := newFixture()
+		req := httptest.NewRequest(http.MethodGet, "http://a.example/", nil)
+
+		// act
+		for range 3 {
+			resp, _ := rt.RoundTrip(req)
+			_ = resp.Body.Close()
+		}
+
+		f.now = f.now.Add(time.Hour - 5*time.Second)
+		resp, _ := rt.RoundTrip(req)
+		_ = resp.Body.Close()
+
+		// assert
+		assert.Equal(t, int32(2), f.fetches.Load())
+		assert.Equal(t, "Bearer token-1|", <-f.seen)
+		assert.Equal(t, "Bearer token-1|", <-f.seen)
+		assert.Equal(t, "Bearer token-1|", <-f.seen)
+		assert.Equal(t, "Bearer token-2|", <-f.seen)
+		assert.Equal(t, "", req.Header.Get("Authorization"))
+	})
+
+	t.Run("coalesces concurrent refreshes", func(t *testing.T) {
+		// arrange
+		var fetches atomic.Int32
+
+		release := make(chan struct{})
+		rt := TransportWithBearerToken(RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader(""))}, nil
+		}), BearerConfig{Source: TokenSourceFunc(func(context.Context) (Token, error) {
+			fetches.Add(1)
+			<-release
+
+			return Token{Value: "shared"}, nil
+		})})
+
+		var wg sync.WaitGroup
+
+		// act
+		for range 10 {
+			wg.Add(1)
+
+			go func() {
+				defer wg.Done()
+
+				resp, err := rt.RoundTrip(httptest.NewRequest(http.MethodGet, "http://a.example/", nil))
+				if err == nil {
+					_ = resp.Body.Close()
+				}
+			}()
+		}
+
+		time.Sleep(20 * time.Millisecond)
+		close(release)
+		wg.Wait()
+
+		// assert
+		assert.Equal(t, int32(1), fetches.Load())
+	})
+
+	t.Run("retries once with a fresh token on 401, replaying the body", func(t *testing.T) {
+		// arrange
+		f, rt := newFixture()
+		warmup, _ := rt.RoundTrip(httptest.NewRequest(http.MethodGet, "http://a.example/", nil))
+		_ = warmup.Body.Close()
+		<-f.seen
+		f.valid.Store("token-2")
+		req := httptest.NewRequest(http.MethodPost, "http://a.example/", strings.NewReader("payload"))
+		req.GetBody = func() (io.ReadCloser, error) { return io.NopCloser(strings.NewReader("payload")), nil }
+
+		// act
+		resp, err := rt.RoundTrip(req)
+
+		// assert
+		assert.Equal(t, nil, err)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, "Bearer token-1|payload", <-f.seen)
+		assert.Equal(t, "Bearer token-2|payload", <-f.seen)
+		assert.Equal(t, int32(2), f.fetches.Load())
+
+		_ = resp.Body.Close()
+	})
+
+	t.Run("does not retry bodies that cannot be replayed", func(t *testing.T) {
+		// arrange
+		f, rt := newFixture()
+		f.valid.Store("never")
+
+		// act
+		resp, err := rt.RoundTrip(httptest.NewRequest(http.MethodPost, "http://a.example/", io.NopCloser(strings.NewReader("payload"))))
+
+		// assert
+		assert.Equal(t, nil, err)
+		assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+		assert.Equal(t, 1, len(f.seen))
+
+		_ = resp.Body.Close()
+	})
+
+	t.Run("static tokens are not retried", func(t *testing.T) {
+		// arrange
+		calls := 0
+		rt := TransportWithBearerToken(RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			calls++
+
+			return &http.Response{StatusCode: http.StatusUnauthorized, Body: io.NopCloser(strings.NewReader(""))}, nil
+		}), BearerConfig{Source: StaticToken("static")})
+
+		// act
+		resp, err := rt.RoundTrip(httptest.NewRequest(http.MethodGet, "http://a.example/", nil))
+
+		// assert
+		assert.Equal(t, nil, err)
+		assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+		assert.Equal(t, 1, calls)
+
+		_ = resp.Body.Close()
+	})
+
+	t.Run("returns the token source error", func(t *testing.T) {
+		// arrange
+		sourceErr := errors.New("token endpoint unavailable")
+		rt := TransportWithBearerToken(nil, BearerConfig{Source: TokenSourceFunc(func(context.Context) (Token, error) {
+			return Token{}, sourceErr
+		})})
+
+		// act
+		resp, err := rt.RoundTrip(httptest.NewRequest(http.MethodGet, "http://a.example/", nil))
+
+		// assert
+		assert.Equal(t, (*http.Response)(nil), resp)
+		assert.Equal(t, sourceErr, err)
+	})
+}
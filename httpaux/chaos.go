@@ -0,0 +1,189 @@
+package httpaux
+
+import (
+	"context"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/angrifel/unapologetic/ioaux"
+	"github.com/angrifel/unapologetic/iospy"
+)
+
+// Fault describes what TransportWithFaults injects into a matching round trip.
+// Every non-zero field applies, in the order they are declared.
+type Fault struct {
+	// Latency delays the round trip, honoring the request context.
+	Latency time.Duration
+	// Err fails the round trip with this error without sending the request, simulating a connection error.
+	Err error
+	// StatusCode answers the round trip with an empty response of this status without sending the request.
+	StatusCode int
+	// TruncateBodyAfter, when positive, ends the response body with a clean io.EOF after this many bytes.
+	TruncateBodyAfter int64
+	// BodyErr, when set, fails the response body with this error after BodyErrAfter bytes.
+	BodyErr error
+	// BodyErrAfter is the number of bytes read from the response body before BodyErr is returned.
+	BodyErrAfter int64
+	// SlowBody delays every Read of the response body, honoring the request context.
+	SlowBody time.Duration
+}
+
+// FaultRule selects the round trips a Fault is injected into.
+type FaultRule struct {
+	// Host matches the request URL host. Empty matches any host.
+	Host string
+	// PathPrefix matches the beginning of the request URL path. Empty matches any path.
+	PathPrefix string
+	// Method matches the request method. Empty matches any method.
+	Method string
+	// Probability is the chance, in (0, 1], of injecting the fault into a matching round trip; 1 injects it into
+	// every matching round trip. TransportWithFaults panics on values outside that range, zero included, so that a
+	// rule is never injected more often than it says.
+	Probability float64
+	// Fault is the fault to inject.
+	Fault Fault
+}
+
+func (r FaultRule) matches(req *http.Request) bool {
+	return (r.Host == "" || r.Host == req.URL.Host) &&
+		(r.PathPrefix == "" || strings.HasPrefix(req.URL.Path, r.PathPrefix)) &&
+		(r.Method == "" || r.Method == req.Method)
+}
+
+// ChaosConfig configures TransportWithFaults.
+type ChaosConfig struct {
+	// Rules are evaluated in order; the first rule that matches and wins its probability roll is applied.
+	Rules []FaultRule
+	// Seed seeds the random number generator used for probability rolls, making runs reproducible.
+	Seed uint64
+}
+
+var _ http.RoundTripper = (*chaosTransport)(nil)
+
+type chaosTransport struct {
+	next  http.RoundTripper
+	rules []FaultRule
+
+	mu  sync.Mutex
+	rng *rand.Rand
+}
+
+// TransportWithFaults wraps next with an http.RoundTripper that injects faults — latency, connection errors,
+// synthetic status codes, truncated, slow or failing response bodies — into the round trips matched by the
+// configured rules. It is meant for exercising resilience code in tests. A nil next uses http.DefaultTransport.
+func TransportWithFaults(next http.RoundTripper, config ChaosConfig) http.RoundTripper {
+	for _, rule := range config.Rules {
+		if !(rule.Probability > 0 && rule.Probability <= 1) {
+			panic("fault rule probability must be in (0, 1]: " + strconv.FormatFloat(rule.Probability, 'g', -1, 64))
+		}
+	}
+
+	return &chaosTransport{
+		next:  transportOrDefault(next),
+		rules: config.Rules,
+		mu:    sync.Mutex{},
+		rng:   rand.New(rand.NewPCG(config.Seed, config.Seed)), //nolint:gosec // fault injection does not need a CSPRNG
+	}
+}
+
+// RoundTrip sends the request, injecting the fault of the first applicable rule, if any.
+func (t *chaosTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	fault, ok := t.pick(req)
+	if !ok {
+		return t.next.RoundTrip(req)
+	}
+
+	if err := sleepContext(req.Context(), fault.Latency); err != nil {
+		closeRequestBody(req)
+
+		return nil, err
+	}
+
+	if fault.Err != nil {
+		closeRequestBody(req)
+
+		return nil, fault.Err
+	}
+
+	if fault.StatusCode != 0 {
+		closeRequestBody(req)
+
+		return syntheticResponse(req, fault.StatusCode), nil
+	}
+
+	resp, err := t.next.RoundTrip(req)
+	if err != nil {
+		return resp, err
+	}
+
+	return CloneHTTPResponseWithBody(resp, faultyBody(req.Context(), resp.Body, fault)), nil
+}
+
+func (t *chaosTransport) pick(req *http.Request) (Fault, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for _, rule := range t.rules {
+		if !rule.matches(req) {
+			continue
+		}
+
+		if t.rng.Float64() < rule.Probability {
+			return rule.Fault, true
+		}
+	}
+
+	return Fault{}, false
+}
+
+func faultyBody(ctx context.Context, body io.ReadCloser, fault Fault) io.ReadCloser {
+	var reader io.Reader = body
+
+	if fault.TruncateBodyAfter > 0 {
+		reader = iospy.LimitReaderWithError(reader, fault.TruncateBodyAfter, io.EOF)
+	}
+
+	if fault.BodyErr != nil {
+		reader = iospy.ReaderWithEOFError(io.LimitReader(reader, fault.BodyErrAfter), fault.BodyErr)
+	}
+
+	if fault.SlowBody > 0 {
+		inner := reader
+		reader = ioaux.ReaderFunc(func(p []byte) (int, error) {
+			if err := sleepContext(ctx, fault.SlowBody); err != nil {
+				return 0, err
+			}
+
+			return inner.Read(p)
+		})
+	}
+
+	return struct {
+		io.Reader
+		io.Closer
+	}{Reader: reader, Closer: body}
+}
+
+func syntheticResponse(req *http.Request, statusCode int) *http.Response {
+	return &http.Response{
+		Status:           strconv.Itoa(statusCode) + " " + http.StatusText(statusCode),
+		StatusCode:       statusCode,
+		Proto:            "HTTP/1.1",
+		ProtoMajor:       1,
+		ProtoMinor:       1,
+		Header:           http.Header{},
+		Body:             http.NoBody,
+		ContentLength:    0,
+		TransferEncoding: nil,
+		Close:            false,
+		Uncompressed:     false,
+		Trailer:          nil,
+		Request:          req,
+		TLS:              nil,
+	}
+}
@@ -0,0 +1,212 @@
+package httpaux
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/angrifel/unapologetic/internal/assert"
+)
+
+func TestTransportWithFaults(t *testing.T) {
+	newUpstream := func(calls *int) http.RoundTripper {
+		return RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			*calls++
+
+			return &http.Response{StatusCode: http.StatusOK, Header: http.Header{}, Body: io.NopCloser(strings.NewReader("0123456789"))}, nil
+		})
+	}
+
+	roundTrip := func(t *testing.T, rt http.RoundTripper, req *http.Request) (*http.Response, string, error, error) {
+		t.Helper()
+
+		resp, err := rt.RoundTrip(req)
+		if err != nil {
+			return nil, "", nil, err
+		}
+
+		data, readErr := io.ReadAll(resp.Body)
+		_ = resp.Body.Close()
+
+		return resp, string(data), readErr, nil
+	}
+
+	t.Run("injects a connection error without calling upstream", func(t *testing.T) {
+		// arrange
+		calls := 0
+		connErr := errors.New("connection refused")
+		rt := TransportWithFaults(newUpstream(&calls), ChaosConfig{Rules: []FaultRule{{Probability: 1, Fault: Fault{Err: connErr}}}})
+
+		// act
+		_, _, _, err := roundTrip(t, rt, httptest.NewRequest(http.MethodGet, "http://a.example/", nil))
+
+		// assert
+		assert.Equal(t, connErr, err)
+		assert.Equal(t, 0, calls)
+	})
+
+	t.Run("injects a status code", func(t *testing.T) {
+		// arrange
+		calls := 0
+		rt := TransportWithFaults(newUpstream(&calls), ChaosConfig{Rules: []FaultRule{{Probability: 1, Fault: Fault{StatusCode: http.StatusServiceUnavailable}}}})
+
+		// act
+		resp, body, _, err := roundTrip(t, rt, httptest.NewRequest(http.MethodGet, "http://a.example/", nil))
+
+		// assert
+		assert.Equal(t, nil, err)
+		assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+		assert.Equal(t, "503 Service Unavailable", resp.Status)
+		assert.Equal(t, "", body)
+		assert.Equal(t, 0, calls)
+	})
+
+	t.Run("truncates the body with a clean EOF", func(t *testing.T) {
+		// arrange
+		calls := 0
+		rt := TransportWithFaults(newUpstream(&calls), ChaosConfig{Rules: []FaultRule{{Probability: 1, Fault: Fault{TruncateBodyAfter: 4}}}})
+
+		// act
+		_, body, readErr, err := roundTrip(t, rt, httptest.NewRequest(http.MethodGet, "http://a.example/", nil))
+
+		// assert
+		assert.Equal(t, nil, err)
+		assert.Equal(t, nil, readErr)
+		assert.Equal(t, "0123", body)
+	})
+
+	t.Run("fails the body mid-way", func(t *testing.T) {
+		// arrange
+		calls := 0
+		bodyErr := errors.New("connection reset by peer")
+		rt := TransportWithFaults(newUpstream(&calls), ChaosConfig{Rules: []FaultRule{{Probability: 1, Fault: Fault{BodyErr: bodyErr, BodyErrAfter: 6}}}})
+
+		// act
+		_, body, readErr, err := roundTrip(t, rt, httptest.NewRequest(http.MethodGet, "http://a.example/", nil))
+
+		// assert
+		assert.Equal(t, nil, err)
+		assert.Equal(t, bodyErr, readErr)
+		assert.Equal(t, "012345", body)
+	})
+
+	t.Run("slows down latency and body reads", func(t *testing.T) {
+		// arrange
+		calls := 0
+		rt := TransportWithFaults(newUpstream(&calls), ChaosConfig{Rules: []FaultRule{{Probability: 1, Fault: Fault{Latency: 10 * time.Millisecond, SlowBody: 5 * time.Millisecond}}}})
+		start := time.Now()
+
+		// act
+		_, body, _, err := roundTrip(t, rt, httptest.NewRequest(http.MethodGet, "http://a.example/", nil))
+
+		// assert
+		assert.Equal(t, nil, err)
+		assert.Equal(t, "0123456789", body)
+		assert.GreaterOrEqual(t, time.Since(start), 15*time.Millisecond)
+	})
+
+	t.Run("latency honors the request context", func(t *testing.T) {
+		// arrange
+		calls := 0
+		rt := TransportWithFaults(newUpstream(&calls), ChaosConfig{Rules: []FaultRule{{Probability: 1, Fault: Fault{Latency: time.Hour}}}})
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+
+		// act
+		_, _, _, err := roundTrip(t, rt, httptest.NewRequest(http.MethodGet, "http://a.example/", nil).WithContext(ctx))
+
+		// assert
+		assert.Equal(t, context.DeadlineExceeded, err)
+		assert.Equal(t, 0, calls)
+	})
+
+	t.Run("rules match on host, path prefix and method", func(t *testing.T) {
+		// arrange
+		calls := 0
+		rt := TransportWithFaults(newUpstream(&calls), ChaosConfig{Rules: []FaultRule{
+			{Host: "a.example", PathPrefix: "/api/", Method: http.MethodPost, Probability: 1, Fault: Fault{StatusCode: http.StatusTeapot}},
+		}})
+
+		// act
+		matched, _, _, _ := roundTrip(t, rt, httptest.NewRequest(http.MethodPost, "http://a.example/api/items", nil))
+		otherHost, _, _, _ := roundTrip(t, rt, httptest.NewRequest(http.MethodPost, "http://b.example/api/items", nil))
+		otherPath, _, _, _ := roundTrip(t, rt, httptest.NewRequest(http.MethodPost, "http://a.example/health", nil))
+		otherMethod, _, _, _ := roundTrip(t, rt, httptest.NewRequest(http.MethodGet, "http://a.example/api/items", nil))
+
+		// assert
+		assert.Equal(t, http.StatusTeapot, matched.StatusCode)
+		assert.Equal(t, http.StatusOK, otherHost.StatusCode)
+		assert.Equal(t, http.StatusOK, otherPath.StatusCode)
+		assert.Equal(t, http.StatusOK, otherMethod.StatusCode)
+	})
+
+	t.Run("probability rolls are reproducible for a seed", func(t *testing.T) {
+		outcomes := func() []int {
+			calls := 0
+			rt := TransportWithFaults(newUpstream(&calls), ChaosConfig{
+				Seed:  42,
+				Rules: []FaultRule{{Probability: 0.5, Fault: Fault{StatusCode: http.StatusBadGateway}}},
+			})
+
+			result := []int{}
+			for range 50 {
+				resp, _, _, _ := roundTrip(t, rt, httptest.NewRequest(http.MethodGet, "http://a.example/", nil))
+				result = append(result, resp.StatusCode)
+			}
+
+			return result
+		}
+
+		// act
+		first, second := outcomes(), outcomes()
+
+		// assert
+		faults := 0
+		for i := range first {
+			assert.Equal(t, first[i], second[i])
+
+			if first[i] == http.StatusBadGateway {
+				faults++
+			}
+		}
+
+		assert.Greater(t, faults, 10)
+		assert.Less(t, faults, 40)
+	})
+
+	t.Run("slow bodies honor the request context", func(t *testing.T) {
+		// arrange
+		calls := 0
+		rt := TransportWithFaults(newUpstream(&calls), ChaosConfig{Rules: []FaultRule{{Probability: 1, Fault: Fault{SlowBody: time.Hour}}}})
+		ctx, cancel := context.WithCancel(context.Background())
+
+		resp, err := rt.RoundTrip(httptest.NewRequest(http.MethodGet, "http://a.example/", nil).WithContext(ctx))
+		assert.Equal(t, nil, err)
+
+		defer resp.Body.Close()
+
+		// act
+		cancel()
+		_, err = resp.Body.Read(make([]byte, 1))
+
+		// assert
+		assert.Equal(t, context.Canceled, err)
+	})
+
+	t.Run("panics on probabilities outside (0, 1]", func(t *testing.T) {
+		for _, probability := range []float64{0, -0.5, 1.5} {
+			func() {
+				defer func() {
+					assert.NotEqual(t, nil, recover())
+				}()
+
+				TransportWithFaults(nil, ChaosConfig{Rules: []FaultRule{{Probability: probability}}})
+			}()
+		}
+	})
+}
@@ -0,0 +1,388 @@
+package httpaux
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// ErrCircuitOpen is matched, via errors.Is, by the errors returned when a circuit breaker rejects a request.
+var ErrCircuitOpen = errors.New("httpaux: circuit open")
+
+const (
+	defaultCircuitWindow       = 10 * time.Second
+	defaultCircuitBuckets      = 10
+	defaultCircuitMinRequests  = 10
+	defaultCircuitFailureRatio = 0.5
+	defaultCircuitOpenTimeout  = 30 * time.Second
+)
+
+// CircuitState is the state of a single circuit.
+type CircuitState int
+
+const (
+	// CircuitClosed lets every request through while failures are being counted.
+	CircuitClosed CircuitState = iota
+	// CircuitOpen rejects every request with ErrCircuitOpen.
+	CircuitOpen
+	// CircuitHalfOpen lets a limited number of probe requests through to decide whether to close again.
+	CircuitHalfOpen
+)
+
+// String returns the lower-case name of the state.
+func (s CircuitState) String() string {
+	switch s {
+	case CircuitClosed:
+		return "closed"
+	case CircuitOpen:
+		return "open"
+	case CircuitHalfOpen:
+		return "half-open"
+	default:
+		return "unknown"
+	}
+}
+
+// CircuitOpenError is returned by the circuit breaker transport when it rejects a request.
+// It matches ErrCircuitOpen when inspected with errors.Is.
+type CircuitOpenError struct {
+	// Key is the circuit key the rejected request mapped to.
+	Key string
+	// State is the state of the circuit at the time of rejection.
+	State CircuitState
+}
+
+func (e *CircuitOpenError) Error() string {
+	return "httpaux: circuit " + e.State.String() + " for " + e.Key
+}
+
+// Is reports whether target is ErrCircuitOpen.
+func (e *CircuitOpenError) Is(target error) bool {
+	return target == ErrCircuitOpen //nolint:errorlint // sentinel identity is the intention
+}
+
+// CircuitBreakerConfig configures TransportWithCircuitBreaker. Zero fields take the documented defaults.
+type CircuitBreakerConfig struct {
+	// Key maps a request to the circuit that guards it. Defaults to the request URL host.
+	Key func(req *http.Request) string
+	// IsFailure classifies the outcome of every round trip, including those canceled by the caller. Defaults to
+	// DefaultCircuitFailure, with requests canceled by the caller counted neither as successes nor as failures.
+	IsFailure func(resp *http.Response, err error) bool
+	// Window is the length of the rolling window failures are counted over. Defaults to 10s.
+	Window time.Duration
+	// Buckets is the number of buckets the rolling window is divided into. Defaults to 10.
+	Buckets int
+	// MinRequests is the number of requests the window must hold before the circuit may open. Defaults to 10.
+	MinRequests int
+	// FailureRatio is the failure ratio within the window at which the circuit opens. Defaults to 0.5.
+	FailureRatio float64
+	// OpenTimeout is how long the circuit stays open before moving to half-open. Defaults to 30s.
+	OpenTimeout time.Duration
+	// HalfOpenProbes is both the maximum number of concurrent probes let through while half-open and the number of
+	// successful probes required to close the circuit. Defaults to 1.
+	HalfOpenProbes int
+	// OnStateChange, if set, is called after a circuit changes state. It must not block.
+	OnStateChange func(key string, from, to CircuitState)
+	// Now returns the current time. Defaults to time.Now.
+	Now func() time.Time
+}
+
+// DefaultCircuitFailure treats transport errors, other than the caller canceling the request,
+// and 5xx responses as failures. When it is used by default, the circuit breaker counts requests canceled by the
+// caller neither as successes nor as failures.
+func DefaultCircuitFailure(resp *http.Response, err error) bool {
+	if err != nil {
+		return !errors.Is(err, context.Canceled)
+	}
+
+	return resp.StatusCode >= http.StatusInternalServerError
+}
+
+var _ http.RoundTripper = (*circuitBreakerTransport)(nil)
+
+type circuitBreakerTransport struct {
+	next   http.RoundTripper
+	config CircuitBreakerConfig
+
+	mu       sync.Mutex
+	circuits map[string]*circuit
+}
+
+// TransportWithCircuitBreaker wraps next with an http.RoundTripper that tracks the outcome of round trips per circuit
+// key and, once a circuit's failure ratio over the rolling window reaches the configured threshold, fails requests
+// fast with a *CircuitOpenError instead of sending them. After OpenTimeout the circuit lets HalfOpenProbes requests
+// through; it closes once that many succeed and reopens on the first failure.
+// A nil next uses http.DefaultTransport.
+func TransportWithCircuitBreaker(next http.RoundTripper, config CircuitBreakerConfig) http.RoundTripper {
+	switch {
+	case config.Window < 0:
+		panic("config.Window must not be negative: " + config.Window.String())
+	case config.Buckets < 0:
+		panic("config.Buckets must not be negative: " + strconv.Itoa(config.Buckets))
+	case config.MinRequests < 0:
+		panic("config.MinRequests must not be negative: " + strconv.Itoa(config.MinRequests))
+	case !(config.FailureRatio >= 0 && config.FailureRatio <= 1):
+		panic("config.FailureRatio must be in [0, 1]: " + strconv.FormatFloat(config.FailureRatio, 'g', -1, 64))
+	case config.OpenTimeout < 0:
+		panic("config.OpenTimeout must not be negative: " + config.OpenTimeout.String())
+	case config.HalfOpenProbes < 0:
+		panic("config.HalfOpenProbes must not be negative: " + strconv.Itoa(config.HalfOpenProbes))
+	}
+
+	if config.Key == nil {
+		config.Key = func(req *http.Request) string { return req.URL.Host }
+	}
+
+	config.Window = cmp.Or(config.Window, defaultCircuitWindow)
+	config.Buckets = cmp.Or(config.Buckets, defaultCircuitBuckets)
+	config.MinRequests = cmp.Or(config.MinRequests, defaultCircuitMinRequests)
+	config.FailureRatio = cmp.Or(config.FailureRatio, defaultCircuitFailureRatio)
+	config.OpenTimeout = cmp.Or(config.OpenTimeout, defaultCircuitOpenTimeout)
+	config.HalfOpenProbes = cmp.Or(config.HalfOpenProbes, 1)
+
+	if config.Now == nil {
+		config.Now = time.Now
+	}
+
+	return &circuitBreakerTransport{
+		next:     transportOrDefault(next),
+		config:   config,
+		mu:       sync.Mutex{},
+		circuits: make(map[string]*circuit),
+	}
+}
+
+// RoundTrip sends the request unless its circuit is open.
+func (t *circuitBreakerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	key := t.config.Key(req)
+
+	generation, err := t.acquire(key)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := t.next.RoundTrip(req)
+
+	t.release(key, generation, t.classify(resp, err))
+
+	return resp, err
+}
+
+// classify maps the outcome of a round trip to a circuit outcome with the configured IsFailure or, if there is none,
+// with DefaultCircuitFailure, treating requests canceled by the caller as neutral.
+func (t *circuitBreakerTransport) classify(resp *http.Response, err error) circuitOutcome {
+	switch {
+	case t.config.IsFailure != nil:
+		if t.config.IsFailure(resp, err) {
+			return circuitFailure
+		}
+
+		return circuitSuccess
+	case err != nil && errors.Is(err, context.Canceled):
+		return circuitNeutral
+	case DefaultCircuitFailure(resp, err):
+		return circuitFailure
+	default:
+		return circuitSuccess
+	}
+}
+
+func (t *circuitBreakerTransport) acquire(key string) (generation uint64, err error) {
+	t.mu.Lock()
+
+	c := t.circuitLocked(key)
+	from := c.state
+	generation, err = c.acquire(t.config, key)
+	to := c.state
+
+	t.mu.Unlock()
+	t.notify(key, from, to)
+
+	return generation, err
+}
+
+func (t *circuitBreakerTransport) release(key string, generation uint64, outcome circuitOutcome) {
+	t.mu.Lock()
+
+	c := t.circuitLocked(key)
+	from := c.state
+	c.release(t.config, generation, outcome)
+	to := c.state
+
+	t.mu.Unlock()
+	t.notify(key, from, to)
+}
+
+func (t *circuitBreakerTransport) circuitLocked(key string) *circuit {
+	c, ok := t.circuits[key]
+	if !ok {
+		c = &circuit{
+			state:      CircuitClosed,
+			generation: 0,
+			openedAt:   time.Time{},
+			window:     newRollingWindow(t.config.Window, t.config.Buckets),
+			probes:     0,
+			successes:  0,
+		}
+		t.circuits[key] = c
+	}
+
+	return c
+}
+
+func (t *circuitBreakerTransport) notify(key string, from, to CircuitState) {
+	if from != to && t.config.OnStateChange != nil {
+		t.config.OnStateChange(key, from, to)
+	}
+}
+
+// circuitOutcome is the outcome of an admitted request.
+type circuitOutcome int
+
+const (
+	circuitSuccess circuitOutcome = iota
+	circuitFailure
+	// circuitNeutral is the outcome of requests canceled by the caller, which say nothing about the dependency.
+	circuitNeutral
+)
+
+// circuit is the state of a single key. It is guarded by circuitBreakerTransport.mu.
+type circuit struct {
+	state      CircuitState
+	generation uint64
+	openedAt   time.Time
+	window     *rollingWindow
+	probes     int
+	successes  int
+}
+
+// acquire admits or rejects a request, returning the generation of the circuit the request was admitted under.
+func (c *circuit) acquire(config CircuitBreakerConfig, key string) (generation uint64, err error) {
+	if c.state == CircuitOpen && config.Now().Sub(c.openedAt) >= config.OpenTimeout {
+		c.transition(CircuitHalfOpen)
+	}
+
+	switch c.state {
+	case CircuitOpen:
+		return 0, &CircuitOpenError{Key: key, State: c.state}
+	case CircuitHalfOpen:
+		if c.probes >= config.HalfOpenProbes {
+			return 0, &CircuitOpenError{Key: key, State: c.state}
+		}
+
+		c.probes++
+	case CircuitClosed:
+	}
+
+	return c.generation, nil
+}
+
+// release records the outcome of an admitted request. Outcomes of requests admitted under a previous generation,
+// e.g. a request sent while closed that completes after the circuit opened, are ignored. Neutral outcomes only free
+// their probe slot.
+func (c *circuit) release(config CircuitBreakerConfig, generation uint64, outcome circuitOutcome) {
+	now := config.Now()
+
+	if generation != c.generation {
+		return
+	}
+
+	switch c.state {
+	case CircuitHalfOpen:
+		c.probes--
+
+		switch outcome {
+		case circuitNeutral:
+			return
+		case circuitFailure:
+			c.open(now)
+
+			return
+		case circuitSuccess:
+		}
+
+		c.successes++
+		if c.successes >= config.HalfOpenProbes {
+			c.transition(CircuitClosed)
+		}
+	case CircuitClosed:
+		if outcome == circuitNeutral {
+			return
+		}
+
+		c.window.record(now, outcome == circuitFailure)
+
+		total, failures := c.window.counts(now)
+		if total >= config.MinRequests && float64(failures) >= config.FailureRatio*float64(total) {
+			c.open(now)
+		}
+	case CircuitOpen:
+	}
+}
+
+func (c *circuit) open(now time.Time) {
+	c.transition(CircuitOpen)
+	c.openedAt = now
+}
+
+func (c *circuit) transition(to CircuitState) {
+	c.state = to
+	c.generation++
+	c.probes = 0
+	c.successes = 0
+	c.window.reset()
+}
+
+// rollingWindow counts outcomes over a sliding time window divided in equally sized buckets.
+type rollingWindow struct {
+	bucketSize time.Duration
+	buckets    []windowBucket
+}
+
+type windowBucket struct {
+	epoch    int64
+	total    int
+	failures int
+}
+
+func newRollingWindow(window time.Duration, buckets int) *rollingWindow {
+	return &rollingWindow{
+		bucketSize: max(window/time.Duration(buckets), 1),
+		buckets:    make([]windowBucket, buckets),
+	}
+}
+
+func (w *rollingWindow) record(now time.Time, failed bool) {
+	epoch := now.UnixNano() / int64(w.bucketSize)
+	b := &w.buckets[epoch%int64(len(w.buckets))]
+
+	if b.epoch != epoch {
+		*b = windowBucket{epoch: epoch, total: 0, failures: 0}
+	}
+
+	b.total++
+	if failed {
+		b.failures++
+	}
+}
+
+func (w *rollingWindow) counts(now time.Time) (total, failures int) {
+	epoch := now.UnixNano() / int64(w.bucketSize)
+
+	for _, b := range w.buckets {
+		if epoch-b.epoch < int64(len(w.buckets)) {
+			total += b.total
+			failures += b.failures
+		}
+	}
+
+	return total, failures
+}
+
+func (w *rollingWindow) reset() {
+	clear(w.buckets)
+}
@@ -0,0 +1,268 @@
+package httpaux
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/angrifel/unapologetic/internal/assert"
+)
+
+func TestTransportWithCircuitBreaker(t *testing.T) {
+	type transition struct {
+		key      string
+		from, to CircuitState
+	}
+
+	newFixture := func() (http.RoundTripper, *int, *int, *time.Time, *[]transition) {
+		status := http.StatusOK
+		calls := 0
+		now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+		transitions := []transition{}
+		rt := TransportWithCircuitBreaker(RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			calls++
+
+			return &http.Response{StatusCode: status, Body: io.NopCloser(strings.NewReader(""))}, nil
+		}), CircuitBreakerConfig{
+			MinRequests:  4,
+			FailureRatio: 0.5,
+			OpenTimeout:  time.Minute,
+			OnStateChange: func(key string, from, to CircuitState) {
+				transitions = append(transitions, transition{key: key, from: from, to: to})
+			},
+			Now: func() time.Time { return now },
+		})
+
+		return rt, &status, &calls, &now, &transitions
+	}
+
+	roundTrip := func(t *testing.T, rt http.RoundTripper, url string) error {
+		t.Helper()
+
+		resp, err := rt.RoundTrip(httptest.NewRequest(http.MethodGet, url, nil))
+		if resp != nil {
+			_ = resp.Body.Close()
+		}
+
+		return err
+	}
+
+	t.Run("opens after the failure ratio is reached and rejects with ErrCircuitOpen", func(t *testing.T) {
+		// arrange
+		rt, status, calls, _, transitions := newFixture()
+
+		// act
+		_ = roundTrip(t, rt, "http://a.example/")
+		_ = roundTrip(t, rt, "http://a.example/")
+		*status = http.StatusServiceUnavailable
+		_ = roundTrip(t, rt, "http://a.example/")
+		_ = roundTrip(t, rt, "http://a.example/")
+		err := roundTrip(t, rt, "http://a.example/")
+		otherHostErr := roundTrip(t, rt, "http://b.example/")
+
+		// assert
+		var openErr *CircuitOpenError
+
+		assert.Equal(t, true, errors.Is(err, ErrCircuitOpen))
+		assert.Equal(t, true, errors.As(err, &openErr))
+		assert.Equal(t, "a.example", openErr.Key)
+		assert.Equal(t, nil, otherHostErr)
+		assert.Equal(t, 5, *calls)
+		assert.Equal(t, 1, len(*transitions))
+		assert.Equal(t, transition{key: "a.example", from: CircuitClosed, to: CircuitOpen}, (*transitions)[0])
+	})
+
+	t.Run("half-open probe success closes the circuit", func(t *testing.T) {
+		// arrange
+		rt, status, _, now, transitions := newFixture()
+		*status = http.StatusInternalServerError
+
+		for range 4 {
+			_ = roundTrip(t, rt, "http://a.example/")
+		}
+
+		// act
+		*now = now.Add(time.Minute)
+		*status = http.StatusOK
+		probeErr := roundTrip(t, rt, "http://a.example/")
+		afterErr := roundTrip(t, rt, "http://a.example/")
+
+		// assert
+		assert.Equal(t, nil, probeErr)
+		assert.Equal(t, nil, afterErr)
+		assert.Equal(t, 3, len(*transitions))
+		assert.Equal(t, CircuitHalfOpen, (*transitions)[1].to)
+		assert.Equal(t, CircuitClosed, (*transitions)[2].to)
+	})
+
+	t.Run("half-open probe failure reopens the circuit", func(t *testing.T) {
+		// arrange
+		rt, status, calls, now, transitions := newFixture()
+		*status = http.StatusInternalServerError
+
+		for range 4 {
+			_ = roundTrip(t, rt, "http://a.example/")
+		}
+
+		// act
+		*now = now.Add(time.Minute)
+		probeErr := roundTrip(t, rt, "http://a.example/")
+		afterErr := roundTrip(t, rt, "http://a.example/")
+
+		// assert
+		assert.Equal(t, nil, probeErr)
+		assert.Equal(t, true, errors.Is(afterErr, ErrCircuitOpen))
+		assert.Equal(t, 5, *calls)
+		assert.Equal(t, CircuitOpen, (*transitions)[len(*transitions)-1].to)
+	})
+
+	t.Run("half-open limits concurrent probes", func(t *testing.T) {
+		// arrange
+		now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+		release := make(chan struct{})
+		started := make(chan struct{})
+		failing := true
+		rt := TransportWithCircuitBreaker(RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			if failing {
+				return nil, errors.New("connection refused")
+			}
+
+			close(started)
+			<-release
+
+			return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader(""))}, nil
+		}), CircuitBreakerConfig{MinRequests: 1, OpenTimeout: time.Second, Now: func() time.Time { return now }})
+
+		_ = roundTrip(t, rt, "http://a.example/")
+		now = now.Add(time.Second)
+		failing = false
+
+		// act
+		probeErr := make(chan error)
+		go func() { probeErr <- roundTrip(t, rt, "http://a.example/") }()
+		<-started
+		rejectedErr := roundTrip(t, rt, "http://a.example/")
+		close(release)
+
+		// assert
+		var openErr *CircuitOpenError
+
+		assert.Equal(t, true, errors.As(rejectedErr, &openErr))
+		assert.Equal(t, CircuitHalfOpen, openErr.State)
+		assert.Equal(t, nil, <-probeErr)
+	})
+
+	t.Run("half-open probes canceled by the caller are neutral", func(t *testing.T) {
+		// arrange
+		var transitions []CircuitState
+
+		now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+		outcome := func(*http.Request) (*http.Response, error) { return nil, errors.New("connection refused") }
+		rt := TransportWithCircuitBreaker(RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			return outcome(req)
+		}), CircuitBreakerConfig{
+			MinRequests:   1,
+			OpenTimeout:   time.Second,
+			OnStateChange: func(_ string, _, to CircuitState) { transitions = append(transitions, to) },
+			Now:           func() time.Time { return now },
+		})
+
+		_ = roundTrip(t, rt, "http://a.example/")
+		now = now.Add(time.Second)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		outcome = func(req *http.Request) (*http.Response, error) { return nil, req.Context().Err() }
+
+		// act
+		req := httptest.NewRequest(http.MethodGet, "http://a.example/", nil).WithContext(ctx)
+		_, canceledErr := rt.RoundTrip(req)
+
+		outcome = func(*http.Request) (*http.Response, error) { return nil, errors.New("connection refused") }
+		probeErr := roundTrip(t, rt, "http://a.example/")
+
+		// assert
+		assert.Equal(t, true, errors.Is(canceledErr, context.Canceled))
+		assert.Equal(t, false, errors.Is(probeErr, ErrCircuitOpen))
+		assert.Equal(t, 3, len(transitions))
+		assert.Equal(t, CircuitHalfOpen, transitions[1])
+		assert.Equal(t, CircuitOpen, transitions[2])
+	})
+
+	t.Run("lets custom classifiers decide on canceled requests", func(t *testing.T) {
+		// arrange
+		var transitions []CircuitState
+
+		rt := TransportWithCircuitBreaker(RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			return nil, req.Context().Err()
+		}), CircuitBreakerConfig{
+			IsFailure:     func(_ *http.Response, err error) bool { return err != nil },
+			MinRequests:   1,
+			OnStateChange: func(_ string, _, to CircuitState) { transitions = append(transitions, to) },
+		})
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		// act
+		_, canceledErr := rt.RoundTrip(httptest.NewRequest(http.MethodGet, "http://a.example/", nil).WithContext(ctx))
+		rejectedErr := roundTrip(t, rt, "http://a.example/")
+
+		// assert
+		assert.Equal(t, true, errors.Is(canceledErr, context.Canceled))
+		assert.Equal(t, true, errors.Is(rejectedErr, ErrCircuitOpen))
+		assert.Equal(t, 1, len(transitions))
+		assert.Equal(t, CircuitOpen, transitions[0])
+	})
+
+	t.Run("panics on invalid config", func(t *testing.T) {
+		for name, config := range map[string]CircuitBreakerConfig{
+			"negative window":           {Window: -time.Second},
+			"negative buckets":          {Buckets: -1},
+			"negative min requests":     {MinRequests: -1},
+			"negative failure ratio":    {FailureRatio: -0.5},
+			"failure ratio above one":   {FailureRatio: 1.5},
+			"negative open timeout":     {OpenTimeout: -time.Second},
+			"negative half-open probes": {HalfOpenProbes: -1},
+		} {
+			t.Run(name, func(t *testing.T) {
+				defer func() { assert.NotEqual(t, nil, recover()) }()
+
+				TransportWithCircuitBreaker(nil, config)
+			})
+		}
+	})
+
+	t.Run("failures outside the rolling window are forgotten", func(t *testing.T) {
+		// arrange
+		rt, status, calls, now, _ := newFixture()
+		*status = http.StatusBadGateway
+
+		for range 3 {
+			_ = roundTrip(t, rt, "http://a.example/")
+		}
+
+		// act
+		*now = now.Add(11 * time.Second)
+		err := roundTrip(t, rt, "http://a.example/")
+		next := roundTrip(t, rt, "http://a.example/")
+
+		// assert
+		assert.Equal(t, nil, err)
+		assert.Equal(t, nil, next)
+		assert.Equal(t, 5, *calls)
+	})
+}
+
+func TestCircuitState(t *testing.T) {
+	assert.Equal(t, "closed", CircuitClosed.String())
+	assert.Equal(t, "open", CircuitOpen.String())
+	assert.Equal(t, "half-open", CircuitHalfOpen.String())
+	assert.Equal(t, "unknown", CircuitState(42).String())
+}
@@ -0,0 +1,191 @@
+package httpaux
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// CoalesceConfig configures TransportWithCoalescing.
+type CoalesceConfig struct {
+	// Methods lists the request methods eligible for coalescing. Defaults to GET and HEAD.
+	Methods []string
+	// Headers lists the request headers that, in addition to the method and URL, make up the default key.
+	Headers []string
+	// Key, if set, replaces the default key. Requests for which it returns false are not coalesced.
+	Key func(req *http.Request) (key string, ok bool)
+}
+
+var _ http.RoundTripper = (*coalescingTransport)(nil)
+
+type coalescingTransport struct {
+	next   http.RoundTripper
+	config CoalesceConfig
+
+	mu    sync.Mutex
+	calls map[string]*coalescedCall
+}
+
+// TransportWithCoalescing wraps next with an http.RoundTripper that coalesces concurrent identical requests into a
+// single upstream round trip. The shared response is buffered with BufferResponseBody and every caller receives its
+// own clone, built with CloneHTTPResponseWithBody, with an independently readable body.
+//
+// A caller whose context is done stops waiting and gets the context error; the shared round trip is only canceled
+// once every caller waiting on it has left. Requests with a body are never coalesced by the default key.
+// A nil next uses http.DefaultTransport.
+func TransportWithCoalescing(next http.RoundTripper, config CoalesceConfig) http.RoundTripper {
+	if config.Methods == nil {
+		config.Methods = []string{http.MethodGet, http.MethodHead}
+	}
+
+	if config.Key == nil {
+		config.Key = func(req *http.Request) (string, bool) { return defaultCoalesceKey(config, req) }
+	}
+
+	return &coalescingTransport{
+		next:   transportOrDefault(next),
+		config: config,
+		mu:     sync.Mutex{},
+		calls:  make(map[string]*coalescedCall),
+	}
+}
+
+func defaultCoalesceKey(config CoalesceConfig, req *http.Request) (string, bool) {
+	if !slices.Contains(config.Methods, req.Method) || (req.Body != nil && req.Body != http.NoBody) {
+		return "", false
+	}
+
+	var key strings.Builder
+
+	key.WriteString(req.Method)
+	key.WriteString(" ")
+	key.WriteString(req.URL.String())
+
+	for _, name := range config.Headers {
+		key.WriteString("\n")
+		key.WriteString(http.CanonicalHeaderKey(name))
+		key.WriteString(": ")
+		key.WriteString(strings.Join(req.Header.Values(name), ", "))
+	}
+
+	return key.String(), true
+}
+
+// RoundTrip joins an in-flight identical round trip, or starts one, and waits for its outcome.
+func (t *coalescingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	key, ok := t.config.Key(req)
+	if !ok {
+		return t.next.RoundTrip(req)
+	}
+
+	call := t.join(key, req)
+
+	select {
+	case <-call.done:
+		return call.result(req)
+	case <-req.Context().Done():
+		t.leave(key, call)
+
+		return nil, req.Context().Err()
+	}
+}
+
+func (t *coalescingTransport) join(key string, req *http.Request) *coalescedCall {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if call, ok := t.calls[key]; ok {
+		call.waiters++
+
+		return call
+	}
+
+	ctx, cancel := context.WithCancel(context.WithoutCancel(req.Context()))
+	call := &coalescedCall{
+		done:     make(chan struct{}),
+		cancel:   cancel,
+		waiters:  1,
+		resp:     nil,
+		body:     nil,
+		readErr:  nil,
+		closeErr: nil,
+		err:      nil,
+	}
+	t.calls[key] = call
+
+	go func() {
+		defer cancel()
+
+		call.run(t.next, req.WithContext(ctx))
+
+		t.mu.Lock()
+		defer t.mu.Unlock()
+
+		if t.calls[key] == call {
+			delete(t.calls, key)
+		}
+	}()
+
+	return call
+}
+
+func (t *coalescingTransport) leave(key string, call *coalescedCall) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	call.waiters--
+	if call.waiters > 0 {
+		return
+	}
+
+	call.cancel()
+
+	if t.calls[key] == call {
+		delete(t.calls, key)
+	}
+}
+
+// coalescedCall is a single upstream round trip shared by every caller with the same key.
+// The fields below done are written before done is closed and only read afterwards.
+type coalescedCall struct {
+	done    chan struct{}
+	cancel  context.CancelFunc
+	waiters int // guarded by coalescingTransport.mu
+
+	resp     *http.Response
+	body     []byte
+	readErr  error
+	closeErr error
+	err      error
+}
+
+func (c *coalescedCall) run(next http.RoundTripper, req *http.Request) {
+	defer close(c.done)
+
+	resp, err := next.RoundTrip(req)
+	if err != nil {
+		c.err = err
+
+		return
+	}
+
+	c.resp = BufferResponseBody(resp)
+	c.body, c.readErr = io.ReadAll(c.resp.Body)
+	c.closeErr = c.resp.Body.Close()
+}
+
+// result returns a clone of the shared response whose body replays the shared body, including its read and close
+// errors, independently of every other caller.
+func (c *coalescedCall) result(req *http.Request) (*http.Response, error) {
+	if c.err != nil {
+		return nil, c.err
+	}
+
+	resp := CloneHTTPResponseWithBody(c.resp, memoryBody(c.body, c.readErr, c.closeErr))
+	resp.Request = req
+
+	return resp, nil
+}
@@ -0,0 +1,238 @@
+package httpaux
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/angrifel/unapologetic/internal/assert"
+	"github.com/angrifel/unapologetic/iospy"
+)
+
+func TestTransportWithCoalescing(t *testing.T) {
+	type upstream struct {
+		calls   atomic.Int32
+		release chan struct{}
+		ctxErr  chan error
+	}
+
+	newUpstream := func(body func() io.Reader) (*upstream, http.RoundTripper) {
+		u := &upstream{release: make(chan struct{}), ctxErr: make(chan error, 1)}
+
+		return u, RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			u.calls.Add(1)
+
+			select {
+			case <-u.release:
+			case <-req.Context().Done():
+				u.ctxErr <- req.Context().Err()
+
+				return nil, req.Context().Err()
+			}
+
+			return &http.Response{StatusCode: http.StatusOK, Header: http.Header{"X-Test": {"1"}}, Body: io.NopCloser(body())}, nil
+		})
+	}
+
+	waitForWaiters := func(t *testing.T, rt http.RoundTripper, n int) {
+		t.Helper()
+
+		ct := rt.(*coalescingTransport)
+		for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); time.Sleep(time.Millisecond) {
+			ct.mu.Lock()
+			waiters := 0
+			for _, call := range ct.calls {
+				waiters += call.waiters
+			}
+			ct.mu.Unlock()
+
+			if waiters == n {
+				return
+			}
+		}
+
+		t.Fatalf("timed out waiting for %d waiters", n)
+	}
+
+	t.Run("concurrent identical requests share one upstream call", func(t *testing.T) {
+		// arrange
+		u, next := newUpstream(func() io.Reader { return strings.NewReader("shared") })
+		rt := TransportWithCoalescing(next, CoalesceConfig{})
+		const callers = 5
+
+		var wg sync.WaitGroup
+		bodies := make([]string, callers)
+		requests := make([]*http.Request, callers)
+		responses := make([]*http.Response, callers)
+
+		// act
+		for i := range callers {
+			wg.Add(1)
+			requests[i] = httptest.NewRequest(http.MethodGet, "http://a.example/resource", nil)
+
+			go func() {
+				defer wg.Done()
+
+				resp, err := rt.RoundTrip(requests[i])
+				if err != nil {
+					t.Errorf("unexpected error: %v", err)
+
+					return
+				}
+
+				data, _ := io.ReadAll(resp.Body)
+				_ = resp.Body.Close()
+				bodies[i] = string(data)
+				responses[i] = resp
+			}()
+		}
+
+		waitForWaiters(t, rt, callers)
+		close(u.release)
+		wg.Wait()
+
+		// assert
+		assert.Equal(t, int32(1), u.calls.Load())
+
+		for i := range callers {
+			assert.Equal(t, "shared", bodies[i])
+			assert.Equal(t, requests[i], responses[i].Request)
+			assert.Equal(t, "1", responses[i].Header.Get("X-Test"))
+		}
+
+		responses[0].Header.Set("X-Test", "mutated")
+		assert.Equal(t, "1", responses[1].Header.Get("X-Test"))
+	})
+
+	t.Run("every caller observes the upstream read error", func(t *testing.T) {
+		// arrange
+		readErr := errors.New("read error")
+		u, next := newUpstream(func() io.Reader { return iospy.ReaderWithEOFError(strings.NewReader("partial"), readErr) })
+		rt := TransportWithCoalescing(next, CoalesceConfig{})
+		close(u.release)
+
+		// act
+		resp, err := rt.RoundTrip(httptest.NewRequest(http.MethodGet, "http://a.example/", nil))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		data, bodyErr := io.ReadAll(resp.Body)
+
+		// assert
+		assert.Equal(t, "partial", string(data))
+		assert.Equal(t, readErr, bodyErr)
+	})
+
+	t.Run("a caller leaving does not cancel the shared call", func(t *testing.T) {
+		// arrange
+		u, next := newUpstream(func() io.Reader { return strings.NewReader("shared") })
+		rt := TransportWithCoalescing(next, CoalesceConfig{})
+		ctx, cancel := context.WithCancel(context.Background())
+		leaverErr := make(chan error)
+		stayerBody := make(chan string)
+
+		go func() {
+			_, err := rt.RoundTrip(httptest.NewRequest(http.MethodGet, "http://a.example/", nil).WithContext(ctx))
+			leaverErr <- err
+		}()
+		go func() {
+			resp, err := rt.RoundTrip(httptest.NewRequest(http.MethodGet, "http://a.example/", nil))
+			if err != nil {
+				stayerBody <- err.Error()
+
+				return
+			}
+
+			data, _ := io.ReadAll(resp.Body)
+			stayerBody <- string(data)
+		}()
+		waitForWaiters(t, rt, 2)
+
+		// act
+		cancel()
+		err := <-leaverErr
+		waitForWaiters(t, rt, 1)
+		close(u.release)
+
+		// assert
+		assert.Equal(t, context.Canceled, err)
+		assert.Equal(t, "shared", <-stayerBody)
+		assert.Equal(t, int32(1), u.calls.Load())
+	})
+
+	t.Run("the shared call is canceled once every caller has left", func(t *testing.T) {
+		// arrange
+		u, next := newUpstream(func() io.Reader { return strings.NewReader("") })
+		rt := TransportWithCoalescing(next, CoalesceConfig{})
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan error)
+
+		go func() {
+			_, err := rt.RoundTrip(httptest.NewRequest(http.MethodGet, "http://a.example/", nil).WithContext(ctx))
+			done <- err
+		}()
+		waitForWaiters(t, rt, 1)
+
+		// act
+		cancel()
+
+		// assert
+		assert.Equal(t, context.Canceled, <-done)
+		assert.Equal(t, context.Canceled, <-u.ctxErr)
+	})
+
+	t.Run("requests differing in a keyed header are not coalesced", func(t *testing.T) {
+		// arrange
+		u, next := newUpstream(func() io.Reader { return strings.NewReader("") })
+		rt := TransportWithCoalescing(next, CoalesceConfig{Headers: []string{"Accept"}})
+		close(u.release)
+
+		var wg sync.WaitGroup
+
+		// act
+		for _, accept := range []string{"text/plain", "application/json"} {
+			wg.Add(1)
+
+			go func() {
+				defer wg.Done()
+
+				req := httptest.NewRequest(http.MethodGet, "http://a.example/", nil)
+				req.Header.Set("Accept", accept)
+
+				if resp, err := rt.RoundTrip(req); err == nil {
+					_ = resp.Body.Close()
+				}
+			}()
+		}
+
+		wg.Wait()
+
+		// assert
+		assert.Equal(t, int32(2), u.calls.Load())
+	})
+
+	t.Run("requests with a body are passed through", func(t *testing.T) {
+		// arrange
+		u, next := newUpstream(func() io.Reader { return strings.NewReader("") })
+		rt := TransportWithCoalescing(next, CoalesceConfig{})
+		close(u.release)
+
+		// act
+		resp, err := rt.RoundTrip(httptest.NewRequest(http.MethodPost, "http://a.example/", strings.NewReader("payload")))
+
+		// assert
+		assert.Equal(t, nil, err)
+		assert.Equal(t, int32(1), u.calls.Load())
+		assert.Equal(t, 0, len(rt.(*coalescingTransport).calls))
+
+		_ = resp.Body.Close()
+	})
+}
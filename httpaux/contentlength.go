@@ -0,0 +1,110 @@
+package httpaux
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// ErrBodyTooLong is reported when a body is longer than its Content-Length.
+var ErrBodyTooLong = errors.New("httpaux: body longer than Content-Length")
+
+// ContentLengthError is returned by a body whose length does not match its Content-Length. It wraps
+// io.ErrUnexpectedEOF when the body is short and ErrBodyTooLong when it is long.
+type ContentLengthError struct {
+	// Expected is the announced Content-Length.
+	Expected int64
+	// Received is the number of bytes received, which exceeds Expected by at most one for long bodies.
+	Received int64
+}
+
+func (e *ContentLengthError) Error() string {
+	if e.Received > e.Expected {
+		return fmt.Sprintf("httpaux: body longer than Content-Length %d", e.Expected)
+	}
+
+	return fmt.Sprintf("httpaux: body of %d bytes shorter than Content-Length %d", e.Received, e.Expected)
+}
+
+// Unwrap returns io.ErrUnexpectedEOF for short bodies and ErrBodyTooLong for long bodies.
+func (e *ContentLengthError) Unwrap() error {
+	if e.Received > e.Expected {
+		return ErrBodyTooLong
+	}
+
+	return io.ErrUnexpectedEOF
+}
+
+// BodyWithContentLength wraps body so that it fails with a *ContentLengthError, instead of io.EOF, when it ends
+// before length bytes, and instead of returning more, when it holds more than length bytes. A negative length, which
+// is unknown, returns body as it is.
+func BodyWithContentLength(body io.ReadCloser, length int64) io.ReadCloser {
+	if length < 0 {
+		return body
+	}
+
+	return &contentLengthBody{ReadCloser: body, expected: length, received: 0, err: nil}
+}
+
+type contentLengthBody struct {
+	io.ReadCloser
+
+	expected int64
+	received int64
+	err      error
+}
+
+func (b *contentLengthBody) Read(p []byte) (int, error) {
+	if b.err != nil {
+		return 0, b.err
+	}
+
+	// read at most one byte past the expected length, which is enough to tell that the body is too long.
+	if remaining := b.expected - b.received + 1; int64(len(p)) > remaining {
+		p = p[:remaining]
+	}
+
+	n, err := b.ReadCloser.Read(p)
+	b.received += int64(n)
+
+	switch {
+	case b.received > b.expected:
+		b.err = &ContentLengthError{Expected: b.expected, Received: b.received}
+
+		return n - int(b.received-b.expected), b.err
+	case errors.Is(err, io.EOF) && b.received < b.expected:
+		b.err = &ContentLengthError{Expected: b.expected, Received: b.received}
+
+		return n, b.err
+	case err != nil:
+		b.err = err
+	}
+
+	return n, err
+}
+
+type contentLengthTransport struct {
+	next http.RoundTripper
+}
+
+var _ http.RoundTripper = (*contentLengthTransport)(nil)
+
+// TransportWithContentLengthCheck wraps next with an http.RoundTripper that enforces the ContentLength of responses
+// with BodyWithContentLength, turning bodies cut short with a clean EOF, e.g. by a proxy, into errors. Responses
+// without a body or of unknown length, and bodies decoded by net/http, are passed through.
+// A nil next uses http.DefaultTransport.
+func TransportWithContentLengthCheck(next http.RoundTripper) http.RoundTripper {
+	return &contentLengthTransport{next: transportOrDefault(next)}
+}
+
+// RoundTrip sends req and enforces the length of the response body.
+func (t *contentLengthTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.next.RoundTrip(req)
+	if err != nil || resp.ContentLength < 0 || resp.Body == nil || resp.Body == http.NoBody || resp.Uncompressed ||
+		req.Method == http.MethodHead {
+		return resp, err
+	}
+
+	return CloneHTTPResponseWithBody(resp, BodyWithContentLength(resp.Body, resp.ContentLength)), nil
+}
@@ -0,0 +1,151 @@
+package httpaux
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/angrifel/unapologetic/internal/assert"
+	"github.com/angrifel/unapologetic/iospy"
+)
+
+func TestBodyWithContentLength(t *testing.T) {
+	t.Run("accepts exact bodies", func(t *testing.T) {
+		// arrange
+		body := BodyWithContentLength(io.NopCloser(strings.NewReader("exact")), 5)
+
+		// act
+		data, err := io.ReadAll(body)
+
+		// assert
+		assert.Equal(t, nil, err)
+		assert.Equal(t, "exact", string(data))
+	})
+
+	t.Run("turns a clean EOF on a short body into an unexpected EOF", func(t *testing.T) {
+		// arrange
+		body := BodyWithContentLength(io.NopCloser(strings.NewReader("short")), 10)
+
+		// act
+		data, err := io.ReadAll(body)
+
+		// assert
+		var lengthErr *ContentLengthError
+
+		assert.Equal(t, "short", string(data))
+		assert.Equal(t, true, errors.Is(err, io.ErrUnexpectedEOF))
+		assert.Equal(t, true, errors.As(err, &lengthErr))
+		assert.Equal(t, int64(10), lengthErr.Expected)
+		assert.Equal(t, int64(5), lengthErr.Received)
+	})
+
+	t.Run("stops long bodies at the expected length", func(t *testing.T) {
+		// arrange
+		reader := iospy.WitnessReader(strings.NewReader("too long by far"))
+		body := BodyWithContentLength(io.NopCloser(reader), 3)
+
+		// act
+		data, err := io.ReadAll(body)
+
+		// assert
+		calls := reader.(iospy.ReaderWitness).ObservedReadCalls()
+
+		assert.Equal(t, "too", string(data))
+		assert.Equal(t, true, errors.Is(err, ErrBodyTooLong))
+		assert.Equal(t, false, errors.Is(err, io.ErrUnexpectedEOF))
+		assert.Equal(t, 4, len(calls[0].P))
+	})
+
+	t.Run("keeps other read errors", func(t *testing.T) {
+		// arrange
+		errBroken := errors.New("broken")
+		source := iospy.LimitReaderWithError(strings.NewReader("abcdef"), 2, errBroken)
+		body := BodyWithContentLength(io.NopCloser(source), 6)
+
+		// act
+		data, err := io.ReadAll(body)
+
+		// assert
+		assert.Equal(t, "ab", string(data))
+		assert.Equal(t, true, errors.Is(err, errBroken))
+	})
+
+	t.Run("keeps EOF replacements of the source", func(t *testing.T) {
+		// arrange
+		errReplaced := errors.New("replaced")
+		body := BodyWithContentLength(io.NopCloser(iospy.ReaderWithEOFError(strings.NewReader("ab"), errReplaced)), 6)
+
+		// act
+		_, err := io.ReadAll(body)
+
+		// assert
+		assert.Equal(t, true, errors.Is(err, errReplaced))
+		assert.Equal(t, false, errors.Is(err, io.ErrUnexpectedEOF))
+	})
+
+	t.Run("leaves bodies of unknown length alone", func(t *testing.T) {
+		// arrange
+		original := io.NopCloser(strings.NewReader("whatever"))
+
+		// act
+		body := BodyWithContentLength(original, -1)
+
+		// assert
+		assert.Equal(t, original, body)
+	})
+}
+
+func TestTransportWithContentLengthCheck(t *testing.T) {
+	respond := func(length int64, body string) http.RoundTripper {
+		return RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			return &http.Response{
+				StatusCode:    http.StatusOK,
+				ContentLength: length,
+				Body:          io.NopCloser(strings.NewReader(body)),
+				Request:       req,
+			}, nil
+		})
+	}
+
+	read := func(t *testing.T, transport http.RoundTripper) ([]byte, error) {
+		t.Helper()
+
+		req, _ := http.NewRequestWithContext(context.Background(), http.MethodGet, "http://example.com", nil)
+
+		resp, err := transport.RoundTrip(req)
+		assert.Equal(t, nil, err)
+
+		defer resp.Body.Close()
+
+		return io.ReadAll(resp.Body)
+	}
+
+	t.Run("fails truncated bodies", func(t *testing.T) {
+		// act
+		_, err := read(t, TransportWithContentLengthCheck(respond(100, "truncated")))
+
+		// assert
+		assert.Equal(t, true, errors.Is(err, io.ErrUnexpectedEOF))
+	})
+
+	t.Run("fails long bodies", func(t *testing.T) {
+		// act
+		data, err := read(t, TransportWithContentLengthCheck(respond(2, "long")))
+
+		// assert
+		assert.Equal(t, "lo", string(data))
+		assert.Equal(t, true, errors.Is(err, ErrBodyTooLong))
+	})
+
+	t.Run("passes bodies of unknown length", func(t *testing.T) {
+		// act
+		data, err := read(t, TransportWithContentLengthCheck(respond(-1, "unknown")))
+
+		// assert
+		assert.Equal(t, nil, err)
+		assert.Equal(t, "unknown", string(data))
+	})
+}
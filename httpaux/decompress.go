@@ -0,0 +1,281 @@
+package httpaux
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+var (
+	// ErrTruncatedEncoding is reported when a compressed body ends before its compressed stream does.
+	ErrTruncatedEncoding = errors.New("httpaux: truncated compressed body")
+	// ErrCorruptEncoding is reported when a compressed body is not a valid compressed stream.
+	ErrCorruptEncoding = errors.New("httpaux: corrupt compressed body")
+)
+
+// Codec decodes a content coding.
+type Codec interface {
+	// Encoding returns the name of the content coding, e.g. "gzip".
+	Encoding() string
+	// NewReader returns a reader decoding r. It may read from r.
+	NewReader(r io.Reader) (io.ReadCloser, error)
+}
+
+// GzipCodec is the Codec of the gzip content coding.
+type GzipCodec struct{}
+
+// DeflateCodec is the Codec of the deflate content coding, which is the zlib format.
+type DeflateCodec struct{}
+
+var (
+	_ Codec             = GzipCodec{}
+	_ Codec             = DeflateCodec{}
+	_ http.RoundTripper = (*decompressionTransport)(nil)
+)
+
+// Encoding returns EncodingGzip.
+func (GzipCodec) Encoding() string { return EncodingGzip }
+
+// NewReader returns a gzip reader over r.
+func (GzipCodec) NewReader(r io.Reader) (io.ReadCloser, error) { return gzip.NewReader(r) }
+
+// Encoding returns EncodingDeflate.
+func (DeflateCodec) Encoding() string { return EncodingDeflate }
+
+// NewReader returns a zlib reader over r.
+func (DeflateCodec) NewReader(r io.Reader) (io.ReadCloser, error) { return zlib.NewReader(r) }
+
+// DecodingError is reported when a compressed response body cannot be decoded.
+// It matches ErrTruncatedEncoding or ErrCorruptEncoding with errors.Is.
+type DecodingError struct {
+	// Encoding is the content coding that failed.
+	Encoding string
+	// Truncated reports whether the compressed stream ended early, rather than being corrupt.
+	Truncated bool
+	// Err is the error of the decoder.
+	Err error
+}
+
+func (e *DecodingError) Error() string {
+	if e.Truncated {
+		return fmt.Sprintf("%v (%s): %v", ErrTruncatedEncoding, e.Encoding, e.Err)
+	}
+
+	return fmt.Sprintf("%v (%s): %v", ErrCorruptEncoding, e.Encoding, e.Err)
+}
+
+// Is matches ErrTruncatedEncoding or ErrCorruptEncoding, depending on Truncated.
+func (e *DecodingError) Is(target error) bool {
+	//nolint:errorlint // sentinel identity is the intention
+	return (e.Truncated && target == ErrTruncatedEncoding) || (!e.Truncated && target == ErrCorruptEncoding)
+}
+
+// Unwrap returns the error of the decoder.
+func (e *DecodingError) Unwrap() error {
+	return e.Err
+}
+
+// DecompressionConfig configures TransportWithDecompression.
+type DecompressionConfig struct {
+	// Codecs are the supported content codings, advertised in the listed order. Defaults to GzipCodec and
+	// DeflateCodec.
+	Codecs []Codec
+}
+
+type decompressionTransport struct {
+	next           http.RoundTripper
+	codecs         map[string]Codec
+	acceptEncoding string
+}
+
+// TransportWithDecompression wraps next with an http.RoundTripper that advertises the content codings of
+// config.Codecs with Accept-Encoding and decodes the response bodies encoded with them. Decoded responses have no
+// Content-Encoding and Content-Length headers, an unknown ContentLength and Uncompressed set.
+//
+// A compressed stream that ends early or is invalid fails the body reads with a *DecodingError. Requests that carry
+// their own Accept-Encoding header, and responses with a content coding not supported, are passed through untouched.
+// A nil next uses http.DefaultTransport.
+func TransportWithDecompression(next http.RoundTripper, config DecompressionConfig) http.RoundTripper {
+	if config.Codecs == nil {
+		config.Codecs = []Codec{GzipCodec{}, DeflateCodec{}}
+	}
+
+	codecs := make(map[string]Codec, len(config.Codecs))
+	encodings := make([]string, 0, len(config.Codecs))
+
+	for _, codec := range config.Codecs {
+		encoding := strings.ToLower(codec.Encoding())
+		codecs[encoding] = codec
+		encodings = append(encodings, encoding)
+	}
+
+	return &decompressionTransport{
+		next:           transportOrDefault(next),
+		codecs:         codecs,
+		acceptEncoding: strings.Join(encodings, ", "),
+	}
+}
+
+// RoundTrip advertises the supported content codings and decodes the response.
+func (t *decompressionTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Header.Get("Accept-Encoding") != "" || t.acceptEncoding == "" {
+		return t.next.RoundTrip(req)
+	}
+
+	out := req.Clone(req.Context())
+	out.Header.Set("Accept-Encoding", t.acceptEncoding)
+
+	resp, err := t.next.RoundTrip(out)
+	if err != nil {
+		return nil, err
+	}
+
+	codecs, ok := t.responseCodecs(resp)
+	if !ok || len(codecs) == 0 || req.Method == http.MethodHead || resp.Body == nil || resp.Body == http.NoBody ||
+		resp.StatusCode == http.StatusNoContent || resp.StatusCode == http.StatusNotModified {
+		return resp, nil
+	}
+
+	body := &decodingBody{source: resp.Body, codecs: codecs, decoder: nil, closers: nil, err: nil}
+	result := CloneHTTPResponseWithBody(resp, body)
+	result.Header.Del("Content-Encoding")
+	result.Header.Del("Content-Length")
+	result.ContentLength = -1
+	result.Uncompressed = true
+
+	return result, nil
+}
+
+// responseCodecs returns the codecs decoding resp, in the order they must be applied, reporting false if a content
+// coding is not supported.
+func (t *decompressionTransport) responseCodecs(resp *http.Response) ([]Codec, bool) {
+	var codecs []Codec
+
+	for _, value := range resp.Header.Values("Content-Encoding") {
+		for encoding := range strings.SplitSeq(value, ",") {
+			encoding = strings.ToLower(strings.TrimSpace(encoding))
+			if encoding == "" || encoding == "identity" {
+				continue
+			}
+
+			codec, ok := t.codecs[encoding]
+			if !ok {
+				return nil, false
+			}
+
+			codecs = append(codecs, codec)
+		}
+	}
+
+	// codings are listed in the order they were applied.
+	slices.Reverse(codecs)
+
+	return codecs, true
+}
+
+// decodingBody decodes source through codecs, creating the decoders on the first read so that RoundTrip does not
+// wait for the body.
+type decodingBody struct {
+	source  io.ReadCloser
+	codecs  []Codec
+	decoder io.Reader
+	closers []io.Closer
+	err     error
+}
+
+func (b *decodingBody) Read(p []byte) (int, error) {
+	if b.decoder == nil && b.err == nil {
+		b.err = b.open()
+	}
+
+	if b.err != nil {
+		return 0, b.err
+	}
+
+	n, err := b.decoder.Read(p)
+	if err != nil && !errors.Is(err, io.EOF) {
+		b.err = err
+	}
+
+	return n, err
+}
+
+func (b *decodingBody) open() error {
+	var r io.Reader = b.source
+
+	for _, codec := range b.codecs {
+		source := &errorRecordingReader{r: r, err: nil}
+
+		decoder, err := codec.NewReader(source)
+		if err != nil {
+			return decodingError(codec.Encoding(), source.err, err)
+		}
+
+		b.closers = append(b.closers, decoder)
+		r = &decodedReader{r: decoder, source: source, encoding: codec.Encoding()}
+	}
+
+	b.decoder = r
+
+	return nil
+}
+
+func (b *decodingBody) Close() error {
+	for _, closer := range b.closers {
+		_ = closer.Close()
+	}
+
+	return b.source.Close()
+}
+
+// decodedReader reports the errors of a decoder as *DecodingError, unless they come from its source.
+type decodedReader struct {
+	r        io.Reader
+	source   *errorRecordingReader
+	encoding string
+}
+
+func (r *decodedReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	if err != nil && !errors.Is(err, io.EOF) {
+		err = decodingError(r.encoding, r.source.err, err)
+	}
+
+	return n, err
+}
+
+// decodingError classifies err, returned by a decoder whose source last returned sourceErr.
+func decodingError(encoding string, sourceErr, err error) error {
+	var decodingErr *DecodingError
+
+	switch {
+	case errors.As(err, &decodingErr):
+		return err
+	case sourceErr != nil && !errors.Is(sourceErr, io.EOF) && errors.Is(err, sourceErr):
+		return err
+	case errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF):
+		return &DecodingError{Encoding: encoding, Truncated: true, Err: err}
+	default:
+		return &DecodingError{Encoding: encoding, Truncated: false, Err: err}
+	}
+}
+
+// errorRecordingReader remembers the last error of r.
+type errorRecordingReader struct {
+	r   io.Reader
+	err error
+}
+
+func (r *errorRecordingReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	if err != nil {
+		r.err = err
+	}
+
+	return n, err
+}
@@ -0,0 +1,217 @@
+package httpaux
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/angrifel/unapologetic/internal/assert"
+	"github.com/angrifel/unapologetic/iospy"
+)
+
+func gzipped(t *testing.T, data string) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+
+	w := gzip.NewWriter(&buf)
+	_, _ = w.Write([]byte(data))
+	assert.Equal(t, nil, w.Close())
+
+	return buf.Bytes()
+}
+
+func zlibbed(t *testing.T, data []byte) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+
+	w := zlib.NewWriter(&buf)
+	_, _ = w.Write(data)
+	assert.Equal(t, nil, w.Close())
+
+	return buf.Bytes()
+}
+
+// upperCodec decodes the fictitious "upper" content coding by lower-casing the body.
+type upperCodec struct{}
+
+func (upperCodec) Encoding() string { return "upper" }
+
+func (upperCodec) NewReader(r io.Reader) (io.ReadCloser, error) {
+	data, err := io.ReadAll(r)
+
+	return io.NopCloser(strings.NewReader(strings.ToLower(string(data)))), err
+}
+
+func encodedResponder(encoding string, body io.Reader, acceptEncoding *string) http.RoundTripper {
+	return RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		*acceptEncoding = req.Header.Get("Accept-Encoding")
+		header := http.Header{"Content-Encoding": {encoding}}
+
+		var length int64 = -1
+		if r, ok := body.(*bytes.Reader); ok {
+			length = r.Size()
+			header.Set("Content-Length", strconv.FormatInt(length, 10))
+		}
+
+		return &http.Response{
+			StatusCode:    http.StatusOK,
+			Header:        header,
+			Body:          io.NopCloser(body),
+			ContentLength: length,
+			Request:       req,
+		}, nil
+	})
+}
+
+func TestTransportWithDecompression(t *testing.T) {
+	get := func(t *testing.T, transport http.RoundTripper) (*http.Response, []byte, error) {
+		t.Helper()
+
+		req, _ := http.NewRequestWithContext(context.Background(), http.MethodGet, "http://example.com", nil)
+
+		resp, err := transport.RoundTrip(req)
+		assert.Equal(t, nil, err)
+		assert.Equal(t, "", req.Header.Get("Accept-Encoding"))
+
+		defer resp.Body.Close()
+
+		data, err := io.ReadAll(resp.Body)
+
+		return resp, data, err
+	}
+
+	t.Run("decodes gzip", func(t *testing.T) {
+		// arrange
+		var accepted string
+
+		next := encodedResponder("gzip", bytes.NewReader(gzipped(t, "hello")), &accepted)
+
+		// act
+		resp, data, err := get(t, TransportWithDecompression(next, DecompressionConfig{}))
+
+		// assert
+		assert.Equal(t, nil, err)
+		assert.Equal(t, "gzip, deflate", accepted)
+		assert.Equal(t, "hello", string(data))
+		assert.Equal(t, "", resp.Header.Get("Content-Encoding"))
+		assert.Equal(t, "", resp.Header.Get("Content-Length"))
+		assert.Equal(t, int64(-1), resp.ContentLength)
+		assert.Equal(t, true, resp.Uncompressed)
+	})
+
+	t.Run("decodes stacked codings in reverse order", func(t *testing.T) {
+		// arrange
+		var accepted string
+
+		next := encodedResponder("gzip, deflate", bytes.NewReader(zlibbed(t, gzipped(t, "layers"))), &accepted)
+
+		// act
+		_, data, err := get(t, TransportWithDecompression(next, DecompressionConfig{}))
+
+		// assert
+		assert.Equal(t, nil, err)
+		assert.Equal(t, "layers", string(data))
+	})
+
+	t.Run("uses pluggable codecs", func(t *testing.T) {
+		// arrange
+		var accepted string
+
+		next := encodedResponder("UPPER", strings.NewReader("SHOUT"), &accepted)
+
+		// act
+		_, data, err := get(t, TransportWithDecompression(next, DecompressionConfig{Codecs: []Codec{upperCodec{}}}))
+
+		// assert
+		assert.Equal(t, nil, err)
+		assert.Equal(t, "upper", accepted)
+		assert.Equal(t, "shout", string(data))
+	})
+
+	t.Run("passes unsupported codings through", func(t *testing.T) {
+		// arrange
+		var accepted string
+
+		next := encodedResponder("br", strings.NewReader("raw"), &accepted)
+
+		// act
+		resp, data, err := get(t, TransportWithDecompression(next, DecompressionConfig{}))
+
+		// assert
+		assert.Equal(t, nil, err)
+		assert.Equal(t, "br", resp.Header.Get("Content-Encoding"))
+		assert.Equal(t, "raw", string(data))
+	})
+
+	t.Run("reports truncated streams", func(t *testing.T) {
+		// arrange
+		var accepted string
+
+		compressed := gzipped(t, strings.Repeat("truncated ", 100))
+		next := encodedResponder("gzip", bytes.NewReader(compressed[:len(compressed)/2]), &accepted)
+
+		// act
+		_, _, err := get(t, TransportWithDecompression(next, DecompressionConfig{}))
+
+		// assert
+		var decodingErr *DecodingError
+
+		assert.Equal(t, true, errors.Is(err, ErrTruncatedEncoding))
+		assert.Equal(t, true, errors.As(err, &decodingErr))
+		assert.Equal(t, "gzip", decodingErr.Encoding)
+	})
+
+	t.Run("reports corrupt streams", func(t *testing.T) {
+		// arrange
+		var accepted string
+
+		compressed := gzipped(t, "checksum")
+		compressed[len(compressed)-5] ^= 0xff
+		next := encodedResponder("gzip", bytes.NewReader(compressed), &accepted)
+
+		// act
+		_, _, err := get(t, TransportWithDecompression(next, DecompressionConfig{}))
+
+		// assert
+		assert.Equal(t, true, errors.Is(err, ErrCorruptEncoding))
+		assert.Equal(t, true, errors.Is(err, gzip.ErrChecksum))
+	})
+
+	t.Run("reports invalid headers as corrupt", func(t *testing.T) {
+		// arrange
+		var accepted string
+
+		next := encodedResponder("deflate", strings.NewReader("not zlib at all"), &accepted)
+
+		// act
+		_, _, err := get(t, TransportWithDecompression(next, DecompressionConfig{}))
+
+		// assert
+		assert.Equal(t, true, errors.Is(err, ErrCorruptEncoding))
+	})
+
+	t.Run("passes source errors through", func(t *testing.T) {
+		// arrange
+		var accepted string
+
+		errBroken := errors.New("broken")
+		compressed := gzipped(t, strings.Repeat("source ", 100))
+		next := encodedResponder("gzip", iospy.LimitReaderWithError(bytes.NewReader(compressed), 20, errBroken), &accepted)
+
+		// act
+		_, _, err := get(t, TransportWithDecompression(next, DecompressionConfig{}))
+
+		// assert
+		assert.Equal(t, true, errors.Is(err, errBroken))
+		assert.Equal(t, false, errors.Is(err, ErrTruncatedEncoding))
+	})
+}
@@ -0,0 +1,233 @@
+package httpaux
+
+import (
+	"context"
+	"crypto/md5" //nolint:gosec // Content-MD5 is tested for compatibility
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/angrifel/unapologetic/internal/assert"
+	"github.com/angrifel/unapologetic/iospy"
+)
+
+func digestResponder(status int, header http.Header, body io.Reader) http.RoundTripper {
+	return RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		return &http.Response{StatusCode: status, Header: header, Body: io.NopCloser(body), Request: req}, nil
+	})
+}
+
+func sha256Digest(data string) string {
+	sum := sha256.Sum256([]byte(data))
+
+	return "sha-256=:" + base64.StdEncoding.EncodeToString(sum[:]) + ":"
+}
+
+func sha512Digest(data string) string {
+	sum := sha512.Sum512([]byte(data))
+
+	return "sha-512=:" + base64.StdEncoding.EncodeToString(sum[:]) + ":"
+}
+
+func TestTransportWithDigestVerification(t *testing.T) {
+	read := func(t *testing.T, transport http.RoundTripper) ([]byte, error) {
+		t.Helper()
+
+		req, _ := http.NewRequestWithContext(context.Background(), http.MethodGet, "http://example.com", nil)
+
+		resp, err := transport.RoundTrip(req)
+		if err != nil {
+			return nil, err
+		}
+
+		defer resp.Body.Close()
+
+		return io.ReadAll(resp.Body)
+	}
+
+	t.Run("accepts matching digests", func(t *testing.T) {
+		// arrange
+		header := http.Header{"Content-Digest": {"unknown=:AAAA:, " + sha256Digest("hello")}}
+		transport := TransportWithDigestVerification(
+			digestResponder(http.StatusOK, header, strings.NewReader("hello")), DigestVerificationConfig{})
+
+		// act
+		data, err := read(t, transport)
+
+		// assert
+		assert.Equal(t, nil, err)
+		assert.Equal(t, "hello", string(data))
+	})
+
+	t.Run("reports mismatches at EOF", func(t *testing.T) {
+		// arrange
+		header := http.Header{"Content-Digest": {sha256Digest("hello")}}
+		transport := TransportWithDigestVerification(
+			digestResponder(http.StatusOK, header, strings.NewReader("hellO")), DigestVerificationConfig{})
+
+		// act
+		data, err := read(t, transport)
+
+		// assert
+		var integrityErr *IntegrityError
+
+		assert.Equal(t, "hellO", string(data))
+		assert.Equal(t, true, errors.Is(err, ErrDigestMismatch))
+		assert.Equal(t, true, errors.As(err, &integrityErr))
+		assert.Equal(t, "Content-Digest", integrityErr.Header)
+		assert.Equal(t, DigestSHA256, integrityErr.Algorithm)
+	})
+
+	t.Run("prefers sha-512", func(t *testing.T) {
+		// arrange
+		header := http.Header{"Content-Digest": {sha256Digest("other") + ", " + sha512Digest("hello")}}
+		transport := TransportWithDigestVerification(
+			digestResponder(http.StatusOK, header, strings.NewReader("hello")), DigestVerificationConfig{})
+
+		// act
+		_, err := read(t, transport)
+
+		// assert
+		assert.Equal(t, nil, err)
+	})
+
+	t.Run("verifies Repr-Digest on complete responses only", func(t *testing.T) {
+		// arrange
+		header := http.Header{"Repr-Digest": {sha256Digest("whole representation")}}
+		complete := TransportWithDigestVerification(
+			digestResponder(http.StatusOK, header, strings.NewReader("whole")), DigestVerificationConfig{})
+		partial := TransportWithDigestVerification(
+			digestResponder(http.StatusPartialContent, header, strings.NewReader("whole")), DigestVerificationConfig{})
+
+		// act
+		_, completeErr := read(t, complete)
+		_, partialErr := read(t, partial)
+
+		// assert
+		assert.Equal(t, true, errors.Is(completeErr, ErrDigestMismatch))
+		assert.Equal(t, nil, partialErr)
+	})
+
+	t.Run("verifies Content-MD5", func(t *testing.T) {
+		// arrange
+		sum := md5.Sum([]byte("legacy")) //nolint:gosec // see import
+		header := http.Header{"Content-Md5": {base64.StdEncoding.EncodeToString(sum[:])}}
+		good := TransportWithDigestVerification(
+			digestResponder(http.StatusOK, header, strings.NewReader("legacy")), DigestVerificationConfig{})
+		bad := TransportWithDigestVerification(
+			digestResponder(http.StatusOK, header, strings.NewReader("legacY")), DigestVerificationConfig{})
+
+		// act
+		_, goodErr := read(t, good)
+		_, badErr := read(t, bad)
+
+		// assert
+		assert.Equal(t, nil, goodErr)
+		assert.Equal(t, true, errors.Is(badErr, ErrDigestMismatch))
+	})
+
+	t.Run("passes read errors through", func(t *testing.T) {
+		// arrange
+		errBroken := errors.New("broken")
+		header := http.Header{"Content-Digest": {sha256Digest("hello")}}
+		body := iospy.LimitReaderWithError(strings.NewReader("hello"), 2, errBroken)
+		transport := TransportWithDigestVerification(digestResponder(http.StatusOK, header, body),
+			DigestVerificationConfig{})
+
+		// act
+		_, err := read(t, transport)
+
+		// assert
+		assert.Equal(t, true, errors.Is(err, errBroken))
+		assert.Equal(t, false, errors.Is(err, ErrDigestMismatch))
+	})
+
+	t.Run("requires digests when configured", func(t *testing.T) {
+		// arrange
+		transport := TransportWithDigestVerification(
+			digestResponder(http.StatusOK, http.Header{}, strings.NewReader("hello")),
+			DigestVerificationConfig{Required: true})
+
+		// act
+		_, err := read(t, transport)
+
+		// assert
+		assert.Equal(t, true, errors.Is(err, ErrDigestMissing))
+	})
+}
+
+func TestTransportWithContentDigest(t *testing.T) {
+	capture := func(digest *string, body *string) http.RoundTripper {
+		return RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			*digest = req.Header.Get("Content-Digest")
+
+			data, _ := io.ReadAll(req.Body)
+			*body = string(data)
+
+			return &http.Response{StatusCode: http.StatusOK, Body: http.NoBody, Request: req}, nil
+		})
+	}
+
+	t.Run("sets the digest of replayable bodies", func(t *testing.T) {
+		// arrange
+		var digest, body string
+
+		transport := TransportWithContentDigest(capture(&digest, &body), ContentDigestConfig{})
+		req, _ := http.NewRequestWithContext(context.Background(), http.MethodPost, "http://example.com",
+			strings.NewReader("payload"))
+
+		// act
+		_, err := transport.RoundTrip(req)
+
+		// assert
+		assert.Equal(t, nil, err)
+		assert.Equal(t, sha256Digest("payload"), digest)
+		assert.Equal(t, "payload", body)
+		assert.Equal(t, "", req.Header.Get("Content-Digest"))
+	})
+
+	t.Run("buffers streaming bodies", func(t *testing.T) {
+		// arrange
+		var digest, body string
+
+		transport := TransportWithContentDigest(capture(&digest, &body), ContentDigestConfig{Algorithm: DigestSHA512})
+		req, _ := http.NewRequestWithContext(context.Background(), http.MethodPost, "http://example.com",
+			io.MultiReader(strings.NewReader("stream")))
+
+		// act
+		_, err := transport.RoundTrip(req)
+
+		// assert
+		assert.Equal(t, nil, err)
+		assert.Equal(t, sha512Digest("stream"), digest)
+		assert.Equal(t, "stream", body)
+	})
+
+	t.Run("round trips with verification", func(t *testing.T) {
+		// arrange
+		var digest, body string
+
+		transport := TransportWithContentDigest(capture(&digest, &body), ContentDigestConfig{})
+		req, _ := http.NewRequestWithContext(context.Background(), http.MethodPut, "http://example.com",
+			strings.NewReader("echo"))
+		_, _ = transport.RoundTrip(req)
+
+		verifier := TransportWithDigestVerification(digestResponder(http.StatusOK,
+			http.Header{"Content-Digest": {digest}}, strings.NewReader(body)), DigestVerificationConfig{})
+		check, _ := http.NewRequestWithContext(context.Background(), http.MethodGet, "http://example.com", nil)
+
+		// act
+		resp, err := verifier.RoundTrip(check)
+		assert.Equal(t, nil, err)
+
+		_, err = io.ReadAll(resp.Body)
+
+		// assert
+		assert.Equal(t, nil, err)
+	})
+}
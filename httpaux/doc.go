@@ -4,6 +4,14 @@
 //   - Cloning http.Response objects with custom bodies
 //   - Buffering response bodies into memory for multiple reads
 //   - Creating http.RoundTripper implementations from functions
+//   - Client-side middleware transports (metrics, circuit breaking, rate limiting, request coalescing, hedging, fault injection, HAR capture and replay, authentication, request signing, request compression, response decompression, digest verification, Content-Length enforcement, per-attempt timeouts, request defaults, ...)
+//   - Resumable and parallel downloads with Range requests
+//   - Streaming bodies (Server-Sent Events, NDJSON, JSON text sequences)
+//   - Typed JSON request helpers
+//   - Link header and cursor pagination iterators
+//   - Conditional GET revalidation with ETag and Last-Modified
+//   - W3C Trace Context propagation for clients and servers
+//   - Idempotency keys for clients and request deduplication for servers
 //
 // # Response Cloning
 //
@@ -35,4 +43,294 @@
 //	    return &http.Response{StatusCode: 200}, nil
 //	})
 //	client := &http.Client{Transport: rt}
+//
+// # Client Metrics
+//
+// TransportWithMetrics traces every request with net/http/httptrace and reports DNS, connect,
+// TLS handshake, time-to-first-byte and body-read timings to a Metrics sink, keyed by host,
+// method and status class. InMemoryMetrics aggregates samples in memory:
+//
+//	sink := &httpaux.InMemoryMetrics{}
+//	client := &http.Client{Transport: httpaux.TransportWithMetrics(http.DefaultTransport, sink)}
+//	// ...
+//	aggregates := sink.Snapshot()
+//
+// # Circuit Breaking
+//
+// TransportWithCircuitBreaker tracks failures per host (or per custom key) over a rolling window
+// and fails fast once a circuit opens. Rejections match ErrCircuitOpen:
+//
+//	rt := httpaux.TransportWithCircuitBreaker(http.DefaultTransport, httpaux.CircuitBreakerConfig{})
+//	_, err := rt.RoundTrip(req)
+//	if errors.Is(err, httpaux.ErrCircuitOpen) {
+//	    // fall back
+//	}
+//
+// # Rate and Concurrency Limiting
+//
+// TransportWithRateLimit applies a token bucket and a max-in-flight semaphore per key. Concurrency
+// slots are released when the response body is closed:
+//
+//	rt := httpaux.TransportWithRateLimit(http.DefaultTransport, httpaux.RateLimitConfig{
+//	    Rate:           10,
+//	    MaxInFlight:    4,
+//	    AdaptToHeaders: true,
+//	})
+//
+// # Request Coalescing
+//
+// TransportWithCoalescing merges concurrent identical GET and HEAD requests into one upstream
+// call. Each caller receives its own clone of the buffered response:
+//
+//	rt := httpaux.TransportWithCoalescing(http.DefaultTransport, httpaux.CoalesceConfig{
+//	    Headers: []string{"Accept", "Authorization"},
+//	})
+//
+// # Hedged Requests
+//
+// TransportWithHedging launches extra attempts of idempotent requests that have not produced
+// response headers within a delay, fixed or derived from observed latencies, and keeps the first
+// successful response:
+//
+//	rt := httpaux.TransportWithHedging(http.DefaultTransport, httpaux.HedgeConfig{
+//	    Delay:      50 * time.Millisecond,
+//	    Percentile: 0.95,
+//	})
+//
+// # Fault Injection
+//
+// TransportWithFaults injects latency, connection errors, status codes and truncated, slow or
+// failing bodies into matching round trips, using a seeded random number generator:
+//
+//	rt := httpaux.TransportWithFaults(http.DefaultTransport, httpaux.ChaosConfig{
+//	    Seed: 1,
+//	    Rules: []httpaux.FaultRule{
+//	        {Host: "api.example.com", Probability: 0.1, Fault: httpaux.Fault{StatusCode: 503}},
+//	    },
+//	})
+//
+// # HTTP Archives
+//
+// TransportWithHARCapture records traffic into a HARRecorder as HAR 1.2 entries, which WriteHAR
+// serializes for browser devtools. ReadHAR and TransportFromHAR turn a HAR file, captured here or
+// exported from a browser, into a replay transport:
+//
+//	recorder := &httpaux.HARRecorder{}
+//	client := &http.Client{Transport: httpaux.TransportWithHARCapture(http.DefaultTransport, recorder)}
+//	// ...
+//	_ = httpaux.WriteHAR(file, recorder.HAR())
+//
+//	har, _ := httpaux.ReadHAR(file)
+//	replay := &http.Client{Transport: httpaux.TransportFromHAR(har)}
+//
+// # Authentication
+//
+// TransportWithBearerToken injects bearer tokens from a TokenSource, caching them until expiry,
+// coalescing refreshes and retrying once on 401. TransportWithBasicAuth and TransportWithAPIKey
+// cover the simpler schemes. All of them clone the request instead of mutating it:
+//
+//	rt := httpaux.TransportWithBearerToken(http.DefaultTransport, httpaux.BearerConfig{
+//	    Source: httpaux.TokenSourceFunc(fetchToken),
+//	})
+//
+// # Request Signing
+//
+// TransportWithHMACSignature signs requests with an HMAC-SHA256 over their method, path, query,
+// selected headers and body hash. TransportWithSigV4 implements AWS Signature Version 4, with
+// signed, unsigned and chunk-signed streaming payloads. BufferRequestBody makes a one-shot body
+// replayable when it must be hashed:
+//
+//	rt := httpaux.TransportWithSigV4(http.DefaultTransport, httpaux.SigV4Config{
+//	    AccessKeyID:     id,
+//	    SecretAccessKey: secret,
+//	    Region:          "us-east-1",
+//	    Service:         "s3",
+//	    Payload:         httpaux.SigV4UnsignedPayload,
+//	})
+//
+// HandlerWithHMACVerification is the server-side counterpart of TransportWithHMACSignature. It
+// checks the signature, the clock skew and, through a NonceStore, replayed nonces, leaving the
+// body readable for the wrapped handler:
+//
+//	handler := httpaux.HandlerWithHMACVerification(mux, httpaux.HMACVerifyConfig{
+//	    Secret: func(keyID string) ([]byte, bool) { secret, ok := secrets[keyID]; return secret, ok },
+//	})
+//
+// # Range Downloads
+//
+// NewRangeReader exposes a remote resource as an io.ReadSeekCloser and io.ReaderAt backed by
+// Range requests. Reads resume from the last offset after transient errors, If-Range detects
+// content that changed meanwhile, and servers that ignore ranges yield a RangeIgnoredError:
+//
+//	r, err := httpaux.NewRangeReader(ctx, http.DefaultTransport, url, httpaux.RangeReaderConfig{})
+//	if err != nil {
+//	    return err
+//	}
+//	defer r.Close()
+//	_, err = io.Copy(file, r)
+//
+// Download fetches a resource in concurrent chunks into an io.WriterAt, retrying failed chunks,
+// reporting progress and verifying the size and checksum at the end:
+//
+//	n, err := httpaux.Download(ctx, http.DefaultTransport, url, file, httpaux.DownloadConfig{
+//	    Concurrency: 8,
+//	    Hash:        sha256.New,
+//	    Checksum:    expected,
+//	})
+//
+// # Server-Sent Events
+//
+// ReadSSE parses a text/event-stream body into an iterator of SSEEvent values. StreamSSE keeps a
+// stream open, reconnecting with Last-Event-ID after the retry delay advertised by the server:
+//
+//	for event, err := range httpaux.StreamSSE(ctx, http.DefaultTransport, url, httpaux.SSEConfig{}) {
+//	    if err != nil {
+//	        return err
+//	    }
+//	    handle(event.Event, event.Data)
+//	}
+//
+// # JSON Streams
+//
+// DecodeNDJSON and DecodeJSONSeq decode newline-delimited JSON and RFC 7464 JSON text sequences
+// into iterators, closing the body when iteration stops. EncodeNDJSON and EncodeJSONSeq stream
+// values into request bodies:
+//
+//	for item, err := range httpaux.DecodeNDJSON[Item](resp.Body, httpaux.JSONStreamConfig{}) {
+//	    if err != nil {
+//	        return err // a *JSONStreamError carrying the line number
+//	    }
+//	    process(item)
+//	}
+//
+// # JSON Requests
+//
+// DoJSON and GetJSON encode the request, check the status code and Content-Type of the response
+// and decode it, within a size limit. Rejected responses come back as a *ResponseError holding
+// the buffered response:
+//
+//	user, err := httpaux.DoJSON[CreateUser, User](ctx, client, http.MethodPost, url, input, httpaux.JSONConfig{})
+//	var respErr *httpaux.ResponseError
+//	if errors.As(err, &respErr) {
+//	    body, _ := io.ReadAll(respErr.Response.Body)
+//	    log.Printf("status %d: %s", respErr.Response.StatusCode, body)
+//	}
+//
+// # Pagination
+//
+// Paginate follows the rel="next" links of the Link header (RFC 8288), yielding each page and
+// closing its body before the next one is requested. PaginateJSON yields the decoded items of
+// every page, following Link headers or a cursor carried in the page:
+//
+//	items := httpaux.PaginateJSON(ctx, client, url, httpaux.PaginateJSONConfig[Page, Item]{
+//	    Items: func(page Page) []Item { return page.Items },
+//	    Next:  httpaux.QueryCursor("cursor", func(page Page) string { return page.NextCursor }),
+//	})
+//	for item, err := range items {
+//	    if err != nil {
+//	        return err
+//	    }
+//	    process(item)
+//	}
+//
+// # Revalidation
+//
+// TransportWithRevalidation remembers responses carrying an ETag or Last-Modified validator in a
+// ValidatorStore and makes later GET requests conditional, answering 304 Not Modified with the
+// stored response as a 200 OK. InMemoryValidatorStore and FileValidatorStore are provided:
+//
+//	client := &http.Client{Transport: httpaux.TransportWithRevalidation(nil, httpaux.RevalidationConfig{
+//	    Store: httpaux.FileValidatorStore{Dir: cacheDir},
+//	})}
+//
+// # Request Compression
+//
+// TransportWithRequestCompression gzip or deflate encodes request bodies of known length above
+// a threshold, setting Content-Encoding, ContentLength and GetBody so retries still replay the
+// compressed body:
+//
+//	transport := httpaux.TransportWithRequestCompression(nil, httpaux.RequestCompressionConfig{
+//	    Encoding: httpaux.EncodingGzip,
+//	    MinSize:  4 << 10,
+//	})
+//
+// # Response Decompression
+//
+// TransportWithDecompression advertises and decodes gzip and deflate, or any Codec, even when the
+// request would not get net/http's transparent gzip support. Broken compressed streams fail the
+// body reads with a *DecodingError rather than ending silently:
+//
+//	_, err := io.ReadAll(resp.Body)
+//	if errors.Is(err, httpaux.ErrTruncatedEncoding) {
+//	    // the upstream cut the compressed stream short
+//	}
+//
+// # Body Integrity
+//
+// TransportWithDigestVerification checks response bodies against their Content-Digest,
+// Repr-Digest (RFC 9530) or Content-MD5 header as they stream, failing the final read with an
+// *IntegrityError instead of io.EOF. TransportWithContentDigest sets Content-Digest on requests:
+//
+//	transport := httpaux.TransportWithContentDigest(
+//	    httpaux.TransportWithDigestVerification(nil, httpaux.DigestVerificationConfig{}),
+//	    httpaux.ContentDigestConfig{Algorithm: httpaux.DigestSHA256},
+//	)
+//
+// # Content-Length Enforcement
+//
+// BodyWithContentLength, or TransportWithContentLengthCheck for every response, fails bodies that
+// end before their Content-Length with a *ContentLengthError wrapping io.ErrUnexpectedEOF, and
+// bodies that go beyond it with one wrapping ErrBodyTooLong:
+//
+//	body := httpaux.BodyWithContentLength(resp.Body, resp.ContentLength)
+//	if _, err := io.Copy(dst, body); errors.Is(err, io.ErrUnexpectedEOF) {
+//	    // truncated
+//	}
+//
+// # Trace Context
+//
+// HandlerWithTraceContext and TransportWithTraceContext propagate W3C traceparent and tracestate
+// headers through the request context without a tracing SDK. A SpanHook receives the spans so
+// they can be exported:
+//
+//	handler := httpaux.HandlerWithTraceContext(mux, httpaux.TraceContextConfig{Hook: exporter})
+//	client := &http.Client{Transport: httpaux.TransportWithTraceContext(nil, httpaux.TraceContextConfig{Hook: exporter})}
+//	// requests sent with r.Context() continue the trace of the incoming request r
+//
+// # Idempotency
+//
+// TransportWithIdempotencyKey attaches an Idempotency-Key header to POST and PATCH requests.
+// Retries made below it keep the key; retries made around it need ContextWithIdempotencyKey,
+// which pins the key for a logical request. HandlerWithIdempotency serves each key once and
+// replays the recorded response to duplicates:
+//
+//	ctx = httpaux.ContextWithIdempotencyKey(ctx, paymentID)
+//	handler := httpaux.HandlerWithIdempotency(payments, httpaux.IdempotencyConfig{TTL: time.Hour})
+//
+// # Attempt Timeouts
+//
+// TransportWithAttemptTimeout bounds each round trip rather than the whole call: the response
+// headers must arrive within HeaderTimeout and each body read must get data within IdleTimeout,
+// failing with an *AttemptTimeoutError otherwise:
+//
+//	transport := httpaux.TransportWithAttemptTimeout(nil, httpaux.AttemptTimeoutConfig{
+//	    HeaderTimeout: 5 * time.Second,
+//	    IdleTimeout:   30 * time.Second,
+//	})
+//
+// # Request Defaults
+//
+// TransportWithRequestDefaults sets headers and query parameters on a clone of the requests
+// matching each rule, e.g. a User-Agent composed by UserAgent for every host and an API version
+// for a single one:
+//
+//	transport := httpaux.TransportWithRequestDefaults(nil, httpaux.RequestDefaultsConfig{Rules: []httpaux.RequestDefaultsRule{
+//	    {Header: http.Header{"User-Agent": {httpaux.UserAgent("myapp", version)}}},
+//	    {Host: "api.example.com", Query: url.Values{"api-version": {"2024-01-01"}}},
+//	}})
+//
+// # Testing
+//
+// None of the transports mutate the requests they are given. The httpauxtest package exports
+// AssertRequestNotMutated so that other http.RoundTripper implementations can be held to the same contract.
 package httpaux
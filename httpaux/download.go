@@ -0,0 +1,237 @@
+package httpaux
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	defaultDownloadChunkSize   = 4 << 20
+	defaultDownloadConcurrency = 4
+)
+
+var (
+	// ErrSizeMismatch is returned by Download when the downloaded size differs from the expected one.
+	ErrSizeMismatch = errors.New("httpaux: downloaded size mismatch")
+	// ErrChecksumMismatch is returned by Download when the checksum of the downloaded content differs from the
+	// expected one.
+	ErrChecksumMismatch = errors.New("httpaux: downloaded checksum mismatch")
+)
+
+// DownloadConfig configures Download.
+type DownloadConfig struct {
+	// Header is sent with every request.
+	Header http.Header
+	// ChunkSize is the size of the ranges requested concurrently. Defaults to 4MiB.
+	ChunkSize int64
+	// Concurrency is the maximum number of ranges requested at once. Defaults to 4.
+	Concurrency int
+	// MaxRetries is how many consecutive transient failures of a chunk are retried. Defaults to 3; a negative value
+	// disables retries.
+	MaxRetries int
+	// RetryDelay is the delay before the first retry of a chunk, doubled on every consecutive failure.
+	// Defaults to 500ms.
+	RetryDelay time.Duration
+	// ExpectedSize, if positive, is the size the downloaded content must have.
+	ExpectedSize int64
+	// Hash, along with Checksum, verifies the downloaded content. It requires dst to implement io.ReaderAt, such as
+	// *os.File, for the content to be read back once downloaded.
+	Hash func() hash.Hash
+	// Checksum is the expected sum of the downloaded content, as computed by Hash.
+	Checksum []byte
+	// Progress, if set, is called with the number of bytes written so far and the total size, or -1 if unknown,
+	// every time a chunk is written. Calls are serialized.
+	Progress func(written, total int64)
+}
+
+// Download fetches the resource at url through rt, a nil rt using http.DefaultTransport, into dst and returns the
+// number of bytes written.
+//
+// The resource is split into chunks of config.ChunkSize, fetched concurrently with Range requests by at most
+// config.Concurrency workers and written at their offsets. Chunks are read with a RangeReader, so transient
+// failures are retried, content that changes during the download is reported as ErrResourceChanged and servers
+// that do not honor ranges are reported with a RangeIgnoredError. Resources of unknown size are streamed
+// sequentially. The first failure cancels the remaining chunks.
+//
+// Once downloaded, the size and checksum are verified, as configured.
+func Download(ctx context.Context, rt http.RoundTripper, url string, dst io.WriterAt, config DownloadConfig) (int64, error) {
+	readBack, canReadBack := dst.(io.ReaderAt)
+	if config.Checksum != nil && (config.Hash == nil || !canReadBack) {
+		panic("config.Checksum requires config.Hash and a dst that implements io.ReaderAt")
+	}
+
+	if config.ChunkSize <= 0 {
+		config.ChunkSize = defaultDownloadChunkSize
+	}
+
+	if config.Concurrency <= 0 {
+		config.Concurrency = defaultDownloadConcurrency
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	r, err := NewRangeReader(ctx, rt, url, RangeReaderConfig{
+		Header:     config.Header,
+		MaxRetries: config.MaxRetries,
+		RetryDelay: config.RetryDelay,
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	defer r.Close()
+
+	progress := &downloadProgress{mu: sync.Mutex{}, written: 0, total: r.Size(), report: config.Progress}
+
+	if r.Size() < 0 {
+		_, err = io.Copy(&progressWriter{w: io.NewOffsetWriter(dst, 0), progress: progress}, r)
+	} else {
+		// every chunk is fetched with its own request: drop the stream opened by NewRangeReader.
+		r.closeBody()
+
+		err = downloadChunks(ctx, cancel, r, dst, config, progress)
+	}
+
+	if err != nil {
+		return progress.written, err
+	}
+
+	return progress.written, verifyDownload(readBack, progress.written, config)
+}
+
+// downloadChunks fetches every chunk of r into dst with a bounded pool of workers.
+func downloadChunks(
+	ctx context.Context, cancel context.CancelFunc, r *RangeReader, dst io.WriterAt, config DownloadConfig,
+	progress *downloadProgress,
+) error {
+	size := r.Size()
+	offsets := make(chan int64)
+
+	go func() {
+		defer close(offsets)
+
+		for offset := int64(0); offset < size; offset += config.ChunkSize {
+			select {
+			case offsets <- offset:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	var (
+		wg       sync.WaitGroup
+		failOnce sync.Once
+		failure  error
+	)
+
+	fail := func(err error) {
+		failOnce.Do(func() {
+			failure = err
+			cancel()
+		})
+	}
+
+	for range min(int64(config.Concurrency), (size+config.ChunkSize-1)/config.ChunkSize) {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			buf := make([]byte, min(config.ChunkSize, size))
+
+			for offset := range offsets {
+				chunk := buf[:min(config.ChunkSize, size-offset)]
+
+				n, err := r.ReadAt(chunk, offset)
+				if n < len(chunk) {
+					fail(fmt.Errorf("httpaux: downloading chunk at offset %d: %w", offset, unexpectedEOF(err)))
+
+					return
+				}
+
+				if _, err := dst.WriteAt(chunk, offset); err != nil {
+					fail(fmt.Errorf("httpaux: writing chunk at offset %d: %w", offset, err))
+
+					return
+				}
+
+				progress.add(int64(n))
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	return failure
+}
+
+// unexpectedEOF returns the error of a short read: err, or io.ErrUnexpectedEOF if err is nil or io.EOF.
+func unexpectedEOF(err error) error {
+	if err == nil || errors.Is(err, io.EOF) {
+		return io.ErrUnexpectedEOF
+	}
+
+	return err
+}
+
+func verifyDownload(readBack io.ReaderAt, written int64, config DownloadConfig) error {
+	if config.ExpectedSize > 0 && written != config.ExpectedSize {
+		return fmt.Errorf("%w: expected %d bytes, got %d", ErrSizeMismatch, config.ExpectedSize, written)
+	}
+
+	if config.Checksum == nil {
+		return nil
+	}
+
+	h := config.Hash()
+	if _, err := io.Copy(h, io.NewSectionReader(readBack, 0, written)); err != nil {
+		return fmt.Errorf("httpaux: reading back downloaded content: %w", err)
+	}
+
+	if sum := h.Sum(nil); !bytes.Equal(sum, config.Checksum) {
+		return fmt.Errorf("%w: expected %x, got %x", ErrChecksumMismatch, config.Checksum, sum)
+	}
+
+	return nil
+}
+
+// downloadProgress counts the bytes written by a download and reports them.
+type downloadProgress struct {
+	mu      sync.Mutex
+	written int64
+	total   int64
+	report  func(written, total int64)
+}
+
+func (p *downloadProgress) add(n int64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.written += n
+
+	if p.report != nil {
+		p.report(p.written, p.total)
+	}
+}
+
+// progressWriter reports the bytes written to w.
+type progressWriter struct {
+	w        io.Writer
+	progress *downloadProgress
+}
+
+func (w *progressWriter) Write(p []byte) (int, error) {
+	n, err := w.w.Write(p)
+	w.progress.add(int64(n))
+
+	return n, err
+}
@@ -0,0 +1,155 @@
+package httpaux
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/angrifel/unapologetic/internal/assert"
+)
+
+func TestDownload(t *testing.T) {
+	content := bytes.Repeat([]byte("0123456789"), 100)
+	checksum := sha256.Sum256(content)
+
+	var inFlight, maxInFlight atomic.Int64
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		current := inFlight.Add(1)
+		defer inFlight.Add(-1)
+
+		for {
+			seen := maxInFlight.Load()
+			if current <= seen || maxInFlight.CompareAndSwap(seen, current) {
+				break
+			}
+		}
+
+		time.Sleep(time.Millisecond)
+		w.Header().Set("ETag", `"v1"`)
+		http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(content))
+	}))
+	defer server.Close()
+
+	open := func(t *testing.T) *os.File {
+		t.Helper()
+
+		f, err := os.Create(filepath.Join(t.TempDir(), "download"))
+		assert.Equal(t, nil, err)
+		t.Cleanup(func() { _ = f.Close() })
+
+		return f
+	}
+
+	t.Run("downloads chunks concurrently", func(t *testing.T) {
+		// arrange
+		dst := open(t)
+		maxInFlight.Store(0)
+
+		var (
+			mu      sync.Mutex
+			reports [][2]int64
+		)
+
+		// act
+		n, err := Download(context.Background(), nil, server.URL, dst, DownloadConfig{
+			ChunkSize:    64,
+			Concurrency:  3,
+			ExpectedSize: int64(len(content)),
+			Hash:         sha256.New,
+			Checksum:     checksum[:],
+			Progress: func(written, total int64) {
+				mu.Lock()
+				defer mu.Unlock()
+
+				reports = append(reports, [2]int64{written, total})
+			},
+		})
+
+		// assert
+		written, _ := os.ReadFile(dst.Name())
+
+		assert.Equal(t, nil, err)
+		assert.Equal(t, int64(len(content)), n)
+		assert.Equal(t, string(content), string(written))
+		assert.LessOrEqual(t, maxInFlight.Load(), int64(3))
+		assert.Equal(t, 16, len(reports))
+		assert.Equal(t, [2]int64{int64(len(content)), int64(len(content))}, reports[len(reports)-1])
+	})
+
+	t.Run("retries failed chunks", func(t *testing.T) {
+		// arrange
+		dst := open(t)
+
+		var failed sync.Map
+
+		rt := RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			if _, loaded := failed.LoadOrStore(req.Header.Get("Range"), true); !loaded && req.Header.Get("If-Range") != "" {
+				return nil, errors.New("connection reset")
+			}
+
+			return http.DefaultTransport.RoundTrip(req)
+		})
+
+		// act
+		n, err := Download(context.Background(), rt, server.URL, dst, DownloadConfig{
+			ChunkSize:  100,
+			RetryDelay: time.Millisecond,
+		})
+
+		// assert
+		written, _ := os.ReadFile(dst.Name())
+
+		assert.Equal(t, nil, err)
+		assert.Equal(t, int64(len(content)), n)
+		assert.Equal(t, string(content), string(written))
+	})
+
+	t.Run("verifies the size", func(t *testing.T) {
+		// act
+		_, err := Download(context.Background(), nil, server.URL, open(t), DownloadConfig{ExpectedSize: 10})
+
+		// assert
+		assert.Equal(t, true, errors.Is(err, ErrSizeMismatch))
+	})
+
+	t.Run("verifies the checksum", func(t *testing.T) {
+		// act
+		_, err := Download(context.Background(), nil, server.URL, open(t), DownloadConfig{
+			Hash:     sha256.New,
+			Checksum: make([]byte, sha256.Size),
+		})
+
+		// assert
+		assert.Equal(t, true, errors.Is(err, ErrChecksumMismatch))
+	})
+
+	t.Run("stops at the first failure", func(t *testing.T) {
+		// arrange
+		var requests atomic.Int64
+
+		rt := RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			if requests.Add(1) > 1 {
+				return &http.Response{StatusCode: http.StatusNotFound, Status: "404 Not Found", Body: http.NoBody}, nil
+			}
+
+			return http.DefaultTransport.RoundTrip(req)
+		})
+
+		// act
+		_, err := Download(context.Background(), rt, server.URL, open(t), DownloadConfig{ChunkSize: 10, Concurrency: 2})
+
+		// assert
+		assert.Equal(t, true, errors.Is(err, ErrUnexpectedStatus))
+		assert.LessOrEqual(t, requests.Load(), int64(4))
+	})
+}
@@ -0,0 +1,301 @@
+package httpaux
+
+import (
+	"encoding/base64"
+	"io"
+	"maps"
+	"mime"
+	"net/http"
+	"net/http/httptrace"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/angrifel/unapologetic/ioaux"
+)
+
+const harCreatorName = "github.com/angrifel/unapologetic/httpaux"
+
+// HARRecorder accumulates the entries captured by TransportWithHARCapture. The zero value is ready to use.
+type HARRecorder struct {
+	// Creator identifies the application in the produced HAR documents. Defaults to this package.
+	Creator HARCreator
+
+	mu      sync.Mutex
+	entries []HAREntry
+}
+
+// HAR returns a HAR document holding every entry captured so far.
+func (r *HARRecorder) HAR() *HAR {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	creator := r.Creator
+	if creator.Name == "" {
+		creator = HARCreator{Name: harCreatorName, Version: HARVersion}
+	}
+
+	return &HAR{Log: HARLog{Version: HARVersion, Creator: creator, Entries: slices.Clone(r.entries), Comment: ""}}
+}
+
+func (r *HARRecorder) add(entry HAREntry) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.entries = append(r.entries, entry)
+}
+
+var _ http.RoundTripper = (*harCaptureTransport)(nil)
+
+type harCaptureTransport struct {
+	next     http.RoundTripper
+	recorder *HARRecorder
+}
+
+// TransportWithHARCapture wraps next with an http.RoundTripper that records every round trip into recorder as a
+// HAR 1.2 entry, including timings, headers, cookies, query string, request body and response content.
+//
+// To capture them, request bodies without GetBody and every response body are buffered in memory with the
+// error-preserving buffering of BufferResponseBody; the caller receives the same bytes and errors it would have
+// otherwise. Round trips that fail without a response are not recorded. A nil next uses http.DefaultTransport.
+func TransportWithHARCapture(next http.RoundTripper, recorder *HARRecorder) http.RoundTripper {
+	if recorder == nil {
+		panic("recorder must not be nil")
+	}
+
+	return &harCaptureTransport{next: transportOrDefault(next), recorder: recorder}
+}
+
+// RoundTrip sends the request and records it along with its response.
+func (t *harCaptureTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	started := time.Now()
+	trace := newRoundTripTrace(started)
+	outgoing := req.Clone(httptrace.WithClientTrace(req.Context(), trace.clientTrace()))
+
+	requestBody, err := captureRequestBody(outgoing)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := t.next.RoundTrip(outgoing)
+	if err != nil {
+		return resp, err
+	}
+
+	receiveStart := time.Now()
+	resp = BufferResponseBody(resp)
+	responseBody, _ := io.ReadAll(resp.Body)
+
+	if seeker, ok := resp.Body.(io.Seeker); ok {
+		_, _ = seeker.Seek(0, io.SeekStart)
+	}
+
+	sample := trace.snapshot()
+	sample.BodyRead = time.Since(receiveStart)
+
+	t.recorder.add(HAREntry{
+		StartedDateTime: started,
+		Time:            milliseconds(time.Since(started)),
+		Request:         harRequest(req, requestBody),
+		Response:        harResponse(resp, responseBody),
+		Cache:           HARCache{},
+		Timings:         harTimings(sample),
+		ServerIPAddress: "",
+		Connection:      "",
+		Comment:         "",
+	})
+
+	return resp, nil
+}
+
+// captureRequestBody returns the content of the request body, buffering it into req when it cannot be replayed.
+func captureRequestBody(req *http.Request) ([]byte, error) {
+	if req.Body == nil || req.Body == http.NoBody {
+		return nil, nil
+	}
+
+	if req.GetBody != nil {
+		body, err := req.GetBody()
+		if err != nil {
+			closeRequestBody(req)
+
+			return nil, err
+		}
+
+		defer body.Close()
+
+		return io.ReadAll(body)
+	}
+
+	buffered := ioaux.ReadSeekCloser(req.Body)
+	data, _ := io.ReadAll(buffered)
+	_, _ = buffered.Seek(0, io.SeekStart)
+	req.Body = buffered
+
+	return data, nil
+}
+
+func harRequest(req *http.Request, body []byte) HARRequest {
+	result := HARRequest{
+		Method:      req.Method,
+		URL:         req.URL.String(),
+		HTTPVersion: harHTTPVersion(req.Proto),
+		Cookies:     []HARCookie{},
+		Headers:     harHeaders(req.Header),
+		QueryString: harNameValues(req.URL.Query()),
+		PostData:    nil,
+		HeadersSize: -1,
+		BodySize:    int64(len(body)),
+	}
+
+	for _, c := range req.Cookies() {
+		result.Cookies = append(result.Cookies, harCookie(c))
+	}
+
+	if body != nil {
+		result.PostData = harPostData(req.Header.Get("Content-Type"), body)
+	}
+
+	return result
+}
+
+func harResponse(resp *http.Response, body []byte) HARResponse {
+	result := HARResponse{
+		Status:      resp.StatusCode,
+		StatusText:  http.StatusText(resp.StatusCode),
+		HTTPVersion: harHTTPVersion(resp.Proto),
+		Cookies:     []HARCookie{},
+		Headers:     harHeaders(resp.Header),
+		Content:     harContent(resp.Header.Get("Content-Type"), body),
+		RedirectURL: resp.Header.Get("Location"),
+		HeadersSize: -1,
+		BodySize:    int64(len(body)),
+	}
+
+	for _, c := range resp.Cookies() {
+		result.Cookies = append(result.Cookies, harCookie(c))
+	}
+
+	return result
+}
+
+func harHTTPVersion(proto string) string {
+	if proto == "" {
+		return "HTTP/1.1"
+	}
+
+	return proto
+}
+
+func harHeaders(header http.Header) []HARNameValue {
+	return harNameValues(url.Values(header))
+}
+
+// harNameValues flattens values into name/value pairs sorted by name, preserving the order of repeated names.
+func harNameValues(values url.Values) []HARNameValue {
+	result := []HARNameValue{}
+
+	for _, name := range slices.Sorted(maps.Keys(values)) {
+		for _, value := range values[name] {
+			result = append(result, HARNameValue{Name: name, Value: value})
+		}
+	}
+
+	return result
+}
+
+func harCookie(c *http.Cookie) HARCookie {
+	result := HARCookie{
+		Name:     c.Name,
+		Value:    c.Value,
+		Path:     c.Path,
+		Domain:   c.Domain,
+		Expires:  nil,
+		HTTPOnly: c.HttpOnly,
+		Secure:   c.Secure,
+	}
+
+	if !c.Expires.IsZero() {
+		expires := c.Expires
+		result.Expires = &expires
+	}
+
+	return result
+}
+
+func harPostData(contentType string, body []byte) *HARPostData {
+	result := &HARPostData{MimeType: contentType, Params: nil, Text: string(body)}
+
+	if mediaType, _, _ := mime.ParseMediaType(contentType); mediaType == "application/x-www-form-urlencoded" {
+		if form, err := url.ParseQuery(string(body)); err == nil {
+			for _, nv := range harNameValues(form) {
+				result.Params = append(result.Params, HARParam{Name: nv.Name, Value: nv.Value, FileName: "", ContentType: ""})
+			}
+		}
+	}
+
+	return result
+}
+
+func harContent(contentType string, body []byte) HARContent {
+	result := HARContent{Size: int64(len(body)), Compression: 0, MimeType: contentType, Text: "", Encoding: ""}
+
+	if isTextualMediaType(contentType) && utf8.Valid(body) {
+		result.Text = string(body)
+	} else if len(body) > 0 {
+		result.Text = base64.StdEncoding.EncodeToString(body)
+		result.Encoding = "base64"
+	}
+
+	return result
+}
+
+// isTextualMediaType reports whether content of the given type is meant to be human-readable text.
+func isTextualMediaType(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return contentType == ""
+	}
+
+	if strings.HasPrefix(mediaType, "text/") {
+		return true
+	}
+
+	for _, suffix := range []string{"json", "xml", "javascript", "x-www-form-urlencoded"} {
+		if strings.HasSuffix(mediaType, suffix) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func harTimings(sample MetricsSample) HARTimings {
+	optional := func(d time.Duration) float64 {
+		if d == 0 {
+			return -1
+		}
+
+		return milliseconds(d)
+	}
+
+	connect := sample.Connect + sample.TLSHandshake
+	wait := max(0, sample.TimeToFirstByte-sample.DNS-connect)
+
+	return HARTimings{
+		Blocked: -1,
+		DNS:     optional(sample.DNS),
+		Connect: optional(connect),
+		Send:    0,
+		Wait:    milliseconds(wait),
+		Receive: milliseconds(sample.BodyRead),
+		SSL:     optional(sample.TLSHandshake),
+	}
+}
+
+func milliseconds(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
@@ -0,0 +1,153 @@
+package httpaux
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/angrifel/unapologetic/internal/assert"
+	"github.com/angrifel/unapologetic/iospy"
+)
+
+func TestTransportWithHARCapture(t *testing.T) {
+	binary := []byte{0x89, 'P', 'N', 'G', 0x00, 0xff}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/image":
+			w.Header().Set("Content-Type", "image/png")
+			_, _ = w.Write(binary)
+		case "/form":
+			_ = r.ParseForm()
+			http.SetCookie(w, &http.Cookie{Name: "session", Value: "abc", Path: "/", HttpOnly: true})
+			w.Header().Set("Content-Type", "application/json")
+			_, _ = io.WriteString(w, `{"name":"`+r.PostForm.Get("name")+`"}`)
+		default:
+			w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+			_, _ = io.WriteString(w, "hello "+r.URL.Query().Get("q"))
+		}
+	}))
+	defer server.Close()
+
+	t.Run("records requests and responses as HAR entries", func(t *testing.T) {
+		// arrange
+		recorder := &HARRecorder{}
+		client := &http.Client{Transport: TransportWithHARCapture(server.Client().Transport, recorder)}
+		get, _ := http.NewRequest(http.MethodGet, server.URL+"/greet?q=world&a=1", nil)
+		get.AddCookie(&http.Cookie{Name: "token", Value: "xyz"})
+
+		// act
+		getResp, err := client.Do(get)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		getBody, _ := io.ReadAll(getResp.Body)
+		_ = getResp.Body.Close()
+
+		formResp, err := client.PostForm(server.URL+"/form", url.Values{"name": {"gopher"}})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		_ = formResp.Body.Close()
+
+		imageResp, err := client.Get(server.URL + "/image")
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		_ = imageResp.Body.Close()
+
+		// assert
+		har := recorder.HAR()
+		entries := har.Log.Entries
+
+		assert.Equal(t, "hello world", string(getBody))
+		assert.Equal(t, HARVersion, har.Log.Version)
+		assert.Equal(t, harCreatorName, har.Log.Creator.Name)
+		assert.Equal(t, 3, len(entries))
+
+		greet := entries[0]
+		assert.Equal(t, http.MethodGet, greet.Request.Method)
+		assert.Equal(t, server.URL+"/greet?q=world&a=1", greet.Request.URL)
+		assert.Equal(t, HARNameValue{Name: "a", Value: "1"}, greet.Request.QueryString[0])
+		assert.Equal(t, HARNameValue{Name: "q", Value: "world"}, greet.Request.QueryString[1])
+		assert.Equal(t, "token", greet.Request.Cookies[0].Name)
+		assert.Equal(t, (*HARPostData)(nil), greet.Request.PostData)
+		assert.Equal(t, http.StatusOK, greet.Response.Status)
+		assert.Equal(t, "hello world", greet.Response.Content.Text)
+		assert.Equal(t, "", greet.Response.Content.Encoding)
+		assert.GreaterOrEqual(t, greet.Timings.Wait, 0.0)
+		assert.Greater(t, greet.Time, 0.0)
+
+		form := entries[1]
+		assert.Equal(t, "name=gopher", form.Request.PostData.Text)
+		assert.Equal(t, HARParam{Name: "name", Value: "gopher"}, form.Request.PostData.Params[0])
+		assert.Equal(t, `{"name":"gopher"}`, form.Response.Content.Text)
+		assert.Equal(t, "session", form.Response.Cookies[0].Name)
+		assert.Equal(t, true, form.Response.Cookies[0].HTTPOnly)
+
+		image := entries[2]
+		assert.Equal(t, "base64", image.Response.Content.Encoding)
+		assert.Equal(t, "iVBORwD/", image.Response.Content.Text)
+		assert.Equal(t, int64(len(binary)), image.Response.Content.Size)
+	})
+
+	t.Run("callers observe the body and errors of the original response", func(t *testing.T) {
+		// arrange
+		readErr := errors.New("read error")
+		recorder := &HARRecorder{}
+		rt := TransportWithHARCapture(RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			data, _ := io.ReadAll(req.Body)
+
+			return &http.Response{
+				StatusCode: http.StatusOK,
+				Header:     http.Header{"Content-Type": {"text/plain"}},
+				Body:       io.NopCloser(iospy.ReaderWithEOFError(bytes.NewReader(data), readErr)),
+			}, nil
+		}), recorder)
+		req := httptest.NewRequest(http.MethodPost, "http://a.example/echo", io.NopCloser(strings.NewReader("echo")))
+		req.Header.Set("Content-Type", "text/plain")
+
+		// act
+		resp, err := rt.RoundTrip(req)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		body, bodyErr := io.ReadAll(resp.Body)
+
+		// assert
+		assert.Equal(t, "echo", string(body))
+		assert.Equal(t, readErr, bodyErr)
+		assert.Equal(t, "echo", recorder.HAR().Log.Entries[0].Request.PostData.Text)
+		assert.Equal(t, "echo", recorder.HAR().Log.Entries[0].Response.Content.Text)
+	})
+	t.Run("closes the request body when GetBody fails", func(t *testing.T) {
+		// arrange
+		getBodyErr := errors.New("get body error")
+		rt := TransportWithHARCapture(RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			t.Fatal("unexpected round trip")
+
+			return nil, nil
+		}), &HARRecorder{})
+		body := iospy.WitnessCloser(io.NopCloser(strings.NewReader("payload")))
+		req := httptest.NewRequest(http.MethodPost, "http://a.example/", struct {
+			io.Reader
+			io.Closer
+		}{Reader: strings.NewReader("payload"), Closer: body})
+		req.GetBody = func() (io.ReadCloser, error) { return nil, getBodyErr }
+
+		// act
+		_, err := rt.RoundTrip(req)
+
+		// assert
+		assert.Equal(t, getBodyErr, err)
+		assert.Equal(t, 1, len(body.(iospy.CloserWitness).ObservedCloseCalls()))
+	})
+}
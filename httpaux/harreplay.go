@@ -0,0 +1,118 @@
+package httpaux
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+)
+
+// ErrHARNoEntry is returned, wrapped, by the transport built by TransportFromHAR for requests the HAR has no
+// entry for.
+var ErrHARNoEntry = errors.New("httpaux: no HAR entry matches the request")
+
+var _ http.RoundTripper = (*harReplayTransport)(nil)
+
+type harReplayTransport struct {
+	mu      sync.Mutex
+	entries map[string][]HAREntry
+	served  map[string]int
+}
+
+// TransportFromHAR returns an http.RoundTripper that answers requests from the entries of har instead of sending
+// them. Requests are matched to entries by method and URL; entries sharing a method and URL are served in the
+// order they appear in the HAR and the last one keeps being served once all have been. Requests without a matching
+// entry fail with an error wrapping ErrHARNoEntry. The content of the entries is served as it is recorded and their
+// Content-Length header is set to its length. Their Content-Encoding header is dropped when the content was
+// recorded decoded, which HAR entries state with a non-zero compression or a body size that differs from the
+// content size, and kept otherwise, as in the entries captured by TransportWithHARCapture.
+func TransportFromHAR(har *HAR) http.RoundTripper {
+	entries := make(map[string][]HAREntry)
+
+	for _, entry := range har.Log.Entries {
+		key := entry.Request.Method + " " + entry.Request.URL
+		entries[key] = append(entries[key], entry)
+	}
+
+	return &harReplayTransport{mu: sync.Mutex{}, entries: entries, served: make(map[string]int)}
+}
+
+// RoundTrip answers the request with the response of the next matching HAR entry.
+func (t *harReplayTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	closeRequestBody(req)
+
+	entry, ok := t.next(req.Method + " " + req.URL.String())
+	if !ok {
+		return nil, fmt.Errorf("%w: %s %s", ErrHARNoEntry, req.Method, req.URL)
+	}
+
+	body, err := harBody(entry.Response.Content)
+	if err != nil {
+		return nil, fmt.Errorf("httpaux: decoding HAR content of %s %s: %w", req.Method, req.URL, err)
+	}
+
+	resp := syntheticResponse(req, entry.Response.Status)
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+	resp.ContentLength = int64(len(body))
+
+	if major, minor, ok := http.ParseHTTPVersion(entry.Response.HTTPVersion); ok {
+		resp.Proto, resp.ProtoMajor, resp.ProtoMinor = entry.Response.HTTPVersion, major, minor
+	}
+
+	if entry.Response.StatusText != "" {
+		resp.Status = strconv.Itoa(entry.Response.Status) + " " + entry.Response.StatusText
+	}
+
+	decoded := harContentDecoded(entry.Response)
+
+	for _, header := range entry.Response.Headers {
+		switch http.CanonicalHeaderKey(header.Name) {
+		case "Content-Length":
+		case "Content-Encoding":
+			// the coding does not describe content that was recorded decoded.
+			if !decoded {
+				resp.Header.Add(header.Name, header.Value)
+			}
+		default:
+			resp.Header.Add(header.Name, header.Value)
+		}
+	}
+
+	resp.Header.Set("Content-Length", strconv.Itoa(len(body)))
+
+	return resp, nil
+}
+
+func (t *harReplayTransport) next(key string) (HAREntry, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	entries := t.entries[key]
+	if len(entries) == 0 {
+		return HAREntry{}, false
+	}
+
+	served := t.served[key]
+	t.served[key] = served + 1
+
+	return entries[min(served, len(entries)-1)], true
+}
+
+// harBody decodes the content of a HAR entry.
+// harContentDecoded reports whether the content of response was recorded decoded rather than as it was received.
+func harContentDecoded(response HARResponse) bool {
+	return response.Content.Compression != 0 ||
+		response.BodySize >= 0 && response.BodySize != response.Content.Size
+}
+
+func harBody(content HARContent) ([]byte, error) {
+	if content.Encoding == "base64" {
+		return base64.StdEncoding.DecodeString(content.Text)
+	}
+
+	return []byte(content.Text), nil
+}
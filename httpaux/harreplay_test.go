@@ -0,0 +1,189 @@
+package httpaux
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/angrifel/unapologetic/internal/assert"
+)
+
+func TestTransportFromHAR(t *testing.T) {
+	entry := func(method, url string, status int, content HARContent, headers ...HARNameValue) HAREntry {
+		return HAREntry{
+			StartedDateTime: time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC),
+			Request:         HARRequest{Method: method, URL: url, HTTPVersion: "HTTP/1.1"},
+			Response:        HARResponse{Status: status, StatusText: http.StatusText(status), HTTPVersion: "HTTP/2.0", Headers: headers, Content: content},
+		}
+	}
+
+	har := &HAR{Log: HARLog{Version: HARVersion, Entries: []HAREntry{
+		entry(http.MethodGet, "http://a.example/text", http.StatusOK, HARContent{MimeType: "text/plain", Text: "first"}, HARNameValue{Name: "Content-Type", Value: "text/plain"}),
+		entry(http.MethodGet, "http://a.example/text", http.StatusOK, HARContent{MimeType: "text/plain", Text: "second"}),
+		entry(http.MethodGet, "http://a.example/image", http.StatusOK, HARContent{MimeType: "image/png", Text: "iVBORwD/", Encoding: "base64"}),
+		entry(http.MethodPost, "http://a.example/text", http.StatusCreated, HARContent{}),
+	}}}
+
+	roundTrip := func(t *testing.T, rt http.RoundTripper, method, url string) (*http.Response, string) {
+		t.Helper()
+
+		resp, err := rt.RoundTrip(httptest.NewRequest(method, url, nil))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		data, _ := io.ReadAll(resp.Body)
+		_ = resp.Body.Close()
+
+		return resp, string(data)
+	}
+
+	t.Run("survives a write and read round trip", func(t *testing.T) {
+		// arrange
+		var buf bytes.Buffer
+
+		// act
+		writeErr := WriteHAR(&buf, har)
+		decoded, readErr := ReadHAR(&buf)
+
+		// assert
+		assert.Equal(t, nil, writeErr)
+		assert.Equal(t, nil, readErr)
+		assert.Equal(t, len(har.Log.Entries), len(decoded.Log.Entries))
+		assert.Equal(t, har.Log.Entries[2].Response.Content, decoded.Log.Entries[2].Response.Content)
+		assert.Equal(t, true, har.Log.Entries[0].StartedDateTime.Equal(decoded.Log.Entries[0].StartedDateTime))
+	})
+
+	t.Run("serves matching entries in order and repeats the last one", func(t *testing.T) {
+		// arrange
+		rt := TransportFromHAR(har)
+
+		// act
+		first, firstBody := roundTrip(t, rt, http.MethodGet, "http://a.example/text")
+		_, secondBody := roundTrip(t, rt, http.MethodGet, "http://a.example/text")
+		_, thirdBody := roundTrip(t, rt, http.MethodGet, "http://a.example/text")
+		post, _ := roundTrip(t, rt, http.MethodPost, "http://a.example/text")
+
+		// assert
+		assert.Equal(t, "first", firstBody)
+		assert.Equal(t, "second", secondBody)
+		assert.Equal(t, "second", thirdBody)
+		assert.Equal(t, "text/plain", first.Header.Get("Content-Type"))
+		assert.Equal(t, "HTTP/2.0", first.Proto)
+		assert.Equal(t, 2, first.ProtoMajor)
+		assert.Equal(t, "200 OK", first.Status)
+		assert.Equal(t, int64(5), first.ContentLength)
+		assert.Equal(t, http.StatusCreated, post.StatusCode)
+	})
+
+	t.Run("decodes base64 content", func(t *testing.T) {
+		// arrange
+		rt := TransportFromHAR(har)
+
+		// act
+		_, body := roundTrip(t, rt, http.MethodGet, "http://a.example/image")
+
+		// assert
+		assert.Equal(t, string([]byte{0x89, 'P', 'N', 'G', 0x00, 0xff}), body)
+	})
+
+	t.Run("describes the decoded content in its headers", func(t *testing.T) {
+		// arrange
+		decoded := entry(http.MethodGet, "http://a.example/gzip", http.StatusOK,
+			HARContent{Size: 5, MimeType: "text/plain", Text: "hello"},
+			HARNameValue{Name: "content-encoding", Value: "gzip"}, HARNameValue{Name: "Content-Length", Value: "25"})
+		decoded.Response.BodySize = 25
+		rt := TransportFromHAR(&HAR{Log: HARLog{Version: HARVersion, Entries: []HAREntry{decoded}}})
+
+		// act
+		resp, body := roundTrip(t, rt, http.MethodGet, "http://a.example/gzip")
+
+		// assert
+		assert.Equal(t, "hello", body)
+		assert.Equal(t, "", resp.Header.Get("Content-Encoding"))
+		assert.Equal(t, "5", resp.Header.Get("Content-Length"))
+		assert.Equal(t, int64(5), resp.ContentLength)
+	})
+
+	t.Run("keeps the coding of content recorded encoded", func(t *testing.T) {
+		// arrange
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			w.Header().Set("Content-Encoding", "gzip")
+			gz := gzip.NewWriter(w)
+			_, _ = io.WriteString(gz, "hello")
+			_ = gz.Close()
+		}))
+		defer server.Close()
+
+		recorder := &HARRecorder{}
+		capture := TransportWithHARCapture(server.Client().Transport, recorder)
+		req := httptest.NewRequest(http.MethodGet, server.URL+"/gzip", nil)
+		req.RequestURI = ""
+		req.Header.Set("Accept-Encoding", "gzip")
+
+		captured, err := capture.RoundTrip(req)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		encoded, _ := io.ReadAll(captured.Body)
+		_ = captured.Body.Close()
+
+		// act
+		resp, body := roundTrip(t, TransportFromHAR(recorder.HAR()), http.MethodGet, server.URL+"/gzip")
+
+		// assert
+		assert.Equal(t, string(encoded), body)
+		assert.Equal(t, "gzip", resp.Header.Get("Content-Encoding"))
+		assert.Equal(t, int64(len(encoded)), resp.ContentLength)
+	})
+
+	t.Run("fails requests without a matching entry", func(t *testing.T) {
+		// arrange
+		rt := TransportFromHAR(har)
+
+		// act
+		resp, err := rt.RoundTrip(httptest.NewRequest(http.MethodGet, "http://a.example/missing", nil))
+
+		// assert
+		assert.Equal(t, (*http.Response)(nil), resp)
+		assert.Equal(t, true, errors.Is(err, ErrHARNoEntry))
+	})
+
+	t.Run("replays what was captured", func(t *testing.T) {
+		// arrange
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("X-Served-By", "origin")
+			_, _ = io.WriteString(w, "captured "+r.URL.Path)
+		}))
+		defer server.Close()
+
+		recorder := &HARRecorder{}
+		capture := TransportWithHARCapture(server.Client().Transport, recorder)
+		_, _ = roundTrip(t, capture, http.MethodGet, server.URL+"/x")
+
+		var buf bytes.Buffer
+		if err := WriteHAR(&buf, recorder.HAR()); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		loaded, err := ReadHAR(&buf)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		server.Close()
+
+		// act
+		resp, body := roundTrip(t, TransportFromHAR(loaded), http.MethodGet, server.URL+"/x")
+
+		// assert
+		assert.Equal(t, "captured /x", body)
+		assert.Equal(t, "origin", resp.Header.Get("X-Served-By"))
+	})
+}
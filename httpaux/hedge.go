@@ -0,0 +1,284 @@
+package httpaux
+
+import (
+	"context"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+)
+
+const (
+	defaultHedgeAttempts   = 2
+	defaultHedgeMinSamples = 20
+	hedgeLatencySamples    = 128
+)
+
+// HedgeConfig configures TransportWithHedging.
+type HedgeConfig struct {
+	// Delay is how long to wait for response headers before launching the next attempt. It must be positive:
+	// TransportWithHedging panics otherwise, as a zero delay would send every eligible request MaxAttempts times at
+	// once. When Percentile is set it is only used until enough latencies have been observed.
+	Delay time.Duration
+	// Percentile, if in (0, 1), derives the delay from the given percentile of recently observed
+	// time-to-headers latencies instead of using Delay.
+	Percentile float64
+	// MinSamples is the number of observed latencies required before Percentile is used. Defaults to 20.
+	MinSamples int
+	// MaxAttempts is the total number of attempts, including the first one. Defaults to 2.
+	MaxAttempts int
+	// IsIdempotent reports whether a request may be sent more than once. Defaults to IsIdempotentRequest.
+	IsIdempotent func(req *http.Request) bool
+}
+
+// IsIdempotentRequest reports whether req uses an idempotent method as defined by RFC 9110
+// or carries an Idempotency-Key header.
+func IsIdempotentRequest(req *http.Request) bool {
+	switch req.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace, http.MethodPut, http.MethodDelete:
+		return true
+	default:
+		return req.Header.Get("Idempotency-Key") != ""
+	}
+}
+
+var _ http.RoundTripper = (*hedgingTransport)(nil)
+
+type hedgingTransport struct {
+	next   http.RoundTripper
+	config HedgeConfig
+
+	mu        sync.Mutex
+	latencies []time.Duration
+	cursor    int
+}
+
+// TransportWithHedging wraps next with an http.RoundTripper that reduces tail latency of idempotent requests by
+// launching additional attempts when the previous ones have not produced response headers within the hedge delay.
+//
+// The first successful response, one without error and with a status below 500, is returned. The other attempts are
+// canceled and the bodies of any responses they produce are drained and closed. An attempt that fails before the
+// hedge delay does not end the race: the next attempt is still launched when the delay elapses. If no attempt
+// succeeds, the first failure is returned. If the request context is done first, every attempt is canceled and the
+// context error is returned right away. Requests that are not idempotent, or that have a body but no GetBody to
+// replay it, are sent once without hedging. A nil next uses http.DefaultTransport.
+func TransportWithHedging(next http.RoundTripper, config HedgeConfig) http.RoundTripper {
+	if config.Delay <= 0 {
+		panic("config.Delay must be positive")
+	}
+
+	if config.IsIdempotent == nil {
+		config.IsIdempotent = IsIdempotentRequest
+	}
+
+	if config.MaxAttempts <= 0 {
+		config.MaxAttempts = defaultHedgeAttempts
+	}
+
+	if config.MinSamples <= 0 {
+		config.MinSamples = defaultHedgeMinSamples
+	}
+
+	return &hedgingTransport{
+		next:      transportOrDefault(next),
+		config:    config,
+		mu:        sync.Mutex{},
+		latencies: make([]time.Duration, 0, hedgeLatencySamples),
+		cursor:    0,
+	}
+}
+
+type hedgeOutcome struct {
+	attempt int
+	resp    *http.Response
+	err     error
+	latency time.Duration
+}
+
+func (o hedgeOutcome) succeeded() bool {
+	return o.err == nil && o.resp.StatusCode < http.StatusInternalServerError
+}
+
+// hedgeRace is the set of attempts launched for a single request.
+type hedgeRace struct {
+	outcomes chan hedgeOutcome
+	cancels  []context.CancelFunc
+	inflight int
+	failure  *hedgeOutcome
+}
+
+// RoundTrip sends the request, hedging it when eligible.
+func (t *hedgingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	replayable := req.Body == nil || req.Body == http.NoBody || req.GetBody != nil
+	if t.config.MaxAttempts < 2 || !replayable || !t.config.IsIdempotent(req) {
+		return t.next.RoundTrip(req)
+	}
+
+	race := &hedgeRace{
+		outcomes: make(chan hedgeOutcome, t.config.MaxAttempts),
+		cancels:  make([]context.CancelFunc, 0, t.config.MaxAttempts),
+		inflight: 0,
+		failure:  nil,
+	}
+	hedging := t.launch(req, race)
+
+	timer := time.NewTimer(t.delay())
+	defer timer.Stop()
+
+	for {
+		var hedge <-chan time.Time
+
+		more := hedging && len(race.cancels) < t.config.MaxAttempts
+		if more {
+			hedge = timer.C
+		}
+
+		select {
+		case <-req.Context().Done():
+			race.abandon(-1)
+
+			return nil, req.Context().Err()
+		case <-hedge:
+			hedging = t.launch(req, race)
+			timer.Reset(t.delay())
+		case outcome := <-race.outcomes:
+			if final := t.settle(race, outcome, more); final != nil {
+				return race.result(*final)
+			}
+		}
+	}
+}
+
+// settle processes the outcome of an attempt and returns the final outcome of the race, or nil if it is not over,
+// which a failure is not while attempts are in flight or more may be launched.
+func (t *hedgingTransport) settle(race *hedgeRace, outcome hedgeOutcome, more bool) *hedgeOutcome {
+	race.inflight--
+
+	if outcome.succeeded() {
+		t.observe(outcome.latency)
+		race.abandon(outcome.attempt)
+
+		return &outcome
+	}
+
+	if race.failure == nil {
+		race.failure = &outcome
+	} else {
+		discardHedgeOutcome(outcome, race.cancels[outcome.attempt])
+	}
+
+	if race.inflight > 0 || more {
+		return nil
+	}
+
+	return race.failure
+}
+
+// launch starts a new attempt and reports whether further attempts may be launched.
+func (t *hedgingTransport) launch(req *http.Request, race *hedgeRace) bool {
+	attempt := len(race.cancels)
+	ctx, cancel := context.WithCancel(req.Context())
+	attemptReq := req.Clone(ctx)
+
+	if attempt > 0 && req.GetBody != nil {
+		body, err := req.GetBody()
+		if err != nil {
+			cancel()
+
+			// the request body can no longer be replayed; let the attempts in flight decide.
+			return false
+		}
+
+		attemptReq.Body = body
+	}
+
+	race.cancels = append(race.cancels, cancel)
+	race.inflight++
+
+	go func() {
+		start := time.Now()
+		resp, err := t.next.RoundTrip(attemptReq)
+
+		race.outcomes <- hedgeOutcome{attempt: attempt, resp: resp, err: err, latency: time.Since(start)}
+	}()
+
+	return true
+}
+
+// result returns the outcome as the result of the race. The attempt context is canceled once the response body
+// is closed, so that it stays readable until then.
+func (r *hedgeRace) result(o hedgeOutcome) (*http.Response, error) {
+	cancel := r.cancels[o.attempt]
+
+	if o.err != nil {
+		cancel()
+
+		return nil, o.err
+	}
+
+	return CloneHTTPResponseWithBody(o.resp, bodyWithCloseHook(o.resp.Body, cancel)), nil
+}
+
+// abandon cancels every attempt but the winner, or every attempt if winner is negative, and, in the background,
+// disposes of their outcomes.
+func (r *hedgeRace) abandon(winner int) {
+	for attempt, cancel := range r.cancels {
+		if attempt != winner {
+			cancel()
+		}
+	}
+
+	failure, inflight := r.failure, r.inflight
+
+	go func() {
+		if failure != nil {
+			discardHedgeOutcome(*failure, r.cancels[failure.attempt])
+		}
+
+		for range inflight {
+			o := <-r.outcomes
+			discardHedgeOutcome(o, r.cancels[o.attempt])
+		}
+	}()
+}
+
+func (t *hedgingTransport) delay() time.Duration {
+	if t.config.Percentile <= 0 || t.config.Percentile >= 1 {
+		return t.config.Delay
+	}
+
+	t.mu.Lock()
+	latencies := slices.Clone(t.latencies)
+	t.mu.Unlock()
+
+	if len(latencies) < t.config.MinSamples {
+		return t.config.Delay
+	}
+
+	slices.Sort(latencies)
+
+	return latencies[int(t.config.Percentile*float64(len(latencies)-1))]
+}
+
+func (t *hedgingTransport) observe(latency time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if len(t.latencies) < cap(t.latencies) {
+		t.latencies = append(t.latencies, latency)
+
+		return
+	}
+
+	t.latencies[t.cursor] = latency
+	t.cursor = (t.cursor + 1) % len(t.latencies)
+}
+
+// discardHedgeOutcome disposes of the response of a losing attempt and cancels it.
+func discardHedgeOutcome(o hedgeOutcome, cancel context.CancelFunc) {
+	defer cancel()
+
+	if o.err == nil {
+		discardBody(o.resp.Body)
+	}
+}
@@ -0,0 +1,271 @@
+package httpaux
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/angrifel/unapologetic/internal/assert"
+	"github.com/angrifel/unapologetic/iospy"
+)
+
+func TestTransportWithHedging(t *testing.T) {
+	t.Run("a hedge beats a slow first attempt which gets canceled", func(t *testing.T) {
+		// arrange
+		var attempts atomic.Int32
+
+		firstCanceled := make(chan error, 1)
+		rt := TransportWithHedging(RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			if attempts.Add(1) == 1 {
+				<-req.Context().Done()
+				firstCanceled <- req.Context().Err()
+
+				return nil, req.Context().Err()
+			}
+
+			return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader("hedge"))}, nil
+		}), HedgeConfig{Delay: 10 * time.Millisecond})
+
+		// act
+		resp, err := rt.RoundTrip(httptest.NewRequest(http.MethodGet, "http://a.example/", nil))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		data, _ := io.ReadAll(resp.Body)
+		_ = resp.Body.Close()
+
+		// assert
+		assert.Equal(t, "hedge", string(data))
+		assert.Equal(t, int32(2), attempts.Load())
+		assert.Equal(t, context.Canceled, <-firstCanceled)
+	})
+
+	t.Run("a fast first attempt is not hedged and its context lives until the body is closed", func(t *testing.T) {
+		// arrange
+		var attempts atomic.Int32
+
+		var attemptCtx context.Context
+
+		rt := TransportWithHedging(RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			attempts.Add(1)
+			attemptCtx = req.Context()
+
+			return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader(""))}, nil
+		}), HedgeConfig{Delay: 50 * time.Millisecond})
+
+		// act
+		resp, err := rt.RoundTrip(httptest.NewRequest(http.MethodGet, "http://a.example/", nil))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		time.Sleep(60 * time.Millisecond)
+		ctxErrBeforeClose := attemptCtx.Err()
+		_ = resp.Body.Close()
+
+		// assert
+		assert.Equal(t, int32(1), attempts.Load())
+		assert.Equal(t, nil, ctxErrBeforeClose)
+		assert.Equal(t, context.Canceled, attemptCtx.Err())
+	})
+
+	t.Run("non-idempotent requests and bodies without GetBody are not hedged", func(t *testing.T) {
+		for name, req := range map[string]*http.Request{
+			"POST":             httptest.NewRequest(http.MethodPost, "http://a.example/", nil),
+			"PUT without body": {Method: http.MethodPut, URL: httptest.NewRequest(http.MethodPut, "http://a.example/", nil).URL, Header: http.Header{}, Body: io.NopCloser(strings.NewReader("x"))},
+		} {
+			t.Run(name, func(t *testing.T) {
+				// arrange
+				var attempts atomic.Int32
+
+				rt := TransportWithHedging(RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+					attempts.Add(1)
+					time.Sleep(20 * time.Millisecond)
+
+					return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader(""))}, nil
+				}), HedgeConfig{Delay: time.Millisecond})
+
+				// act
+				resp, err := rt.RoundTrip(req)
+
+				// assert
+				assert.Equal(t, nil, err)
+				assert.Equal(t, int32(1), attempts.Load())
+
+				_ = resp.Body.Close()
+			})
+		}
+	})
+
+	t.Run("every attempt receives a replayed body", func(t *testing.T) {
+		// arrange
+		var mu sync.Mutex
+
+		bodies := []string{}
+		release := make(chan struct{})
+		rt := TransportWithHedging(RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			data, _ := io.ReadAll(req.Body)
+
+			mu.Lock()
+			bodies = append(bodies, string(data))
+			n := len(bodies)
+			mu.Unlock()
+
+			if n < 3 {
+				select {
+				case <-release:
+				case <-req.Context().Done():
+					return nil, req.Context().Err()
+				}
+			}
+
+			return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader(strconv.Itoa(n)))}, nil
+		}), HedgeConfig{Delay: 5 * time.Millisecond, MaxAttempts: 3})
+		req := httptest.NewRequest(http.MethodPut, "http://a.example/", bytes.NewReader([]byte("payload")))
+		req.GetBody = func() (io.ReadCloser, error) { return io.NopCloser(strings.NewReader("payload")), nil }
+
+		// act
+		resp, err := rt.RoundTrip(req)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		data, _ := io.ReadAll(resp.Body)
+		_ = resp.Body.Close()
+		close(release)
+
+		// assert
+		assert.Equal(t, "3", string(data))
+		assert.Equal(t, 3, len(bodies))
+
+		for _, body := range bodies {
+			assert.Equal(t, "payload", body)
+		}
+	})
+
+	t.Run("returns the first failure when no attempt succeeds and closes the others", func(t *testing.T) {
+		// arrange
+		var attempts atomic.Int32
+
+		closers := make(chan iospy.CloserWitness, 2)
+		rt := TransportWithHedging(RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			n := attempts.Add(1)
+			if n == 1 {
+				time.Sleep(20 * time.Millisecond)
+			}
+
+			closer := iospy.WitnessCloser(io.NopCloser(nil))
+			closers <- closer.(iospy.CloserWitness)
+
+			return &http.Response{
+				StatusCode: http.StatusServiceUnavailable,
+				Header:     http.Header{"X-Attempt": {strconv.Itoa(int(n))}},
+				Body: struct {
+					io.Reader
+					io.Closer
+				}{strings.NewReader("unavailable"), closer},
+			}, nil
+		}), HedgeConfig{Delay: time.Millisecond})
+
+		// act
+		resp, err := rt.RoundTrip(httptest.NewRequest(http.MethodGet, "http://a.example/", nil))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		// the second attempt fails first; the slow first attempt fails last and is discarded.
+		returned, discarded := <-closers, <-closers
+
+		// assert
+		assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+		assert.Equal(t, "2", resp.Header.Get("X-Attempt"))
+		assert.Equal(t, 0, len(returned.ObservedCloseCalls()))
+		assert.Equal(t, 1, len(discarded.ObservedCloseCalls()))
+
+		_ = resp.Body.Close()
+	})
+
+	t.Run("launches the hedge when the first attempt fails before the delay", func(t *testing.T) {
+		// arrange
+		var attempts atomic.Int32
+
+		rt := TransportWithHedging(RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			if attempts.Add(1) == 1 {
+				return nil, errors.New("connection reset")
+			}
+
+			return &http.Response{StatusCode: http.StatusOK, Body: http.NoBody}, nil
+		}), HedgeConfig{Delay: 5 * time.Millisecond})
+
+		// act
+		resp, err := rt.RoundTrip(httptest.NewRequest(http.MethodGet, "http://a.example/", nil))
+
+		// assert
+		assert.Equal(t, nil, err)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, int32(2), attempts.Load())
+
+		_ = resp.Body.Close()
+	})
+
+	t.Run("stops hedging and cancels every attempt when the caller cancels", func(t *testing.T) {
+		// arrange
+		var attempts atomic.Int32
+
+		canceled := make(chan struct{}, 3)
+		rt := TransportWithHedging(RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			attempts.Add(1)
+			<-req.Context().Done()
+			canceled <- struct{}{}
+
+			return nil, req.Context().Err()
+		}), HedgeConfig{Delay: time.Second, MaxAttempts: 3})
+
+		ctx, cancel := context.WithCancel(context.Background())
+		time.AfterFunc(10*time.Millisecond, cancel)
+
+		// act
+		start := time.Now()
+		_, err := rt.RoundTrip(httptest.NewRequest(http.MethodGet, "http://a.example/", nil).WithContext(ctx))
+		elapsed := time.Since(start)
+
+		// assert
+		assert.Equal(t, context.Canceled, err)
+		assert.Less(t, elapsed, time.Second/2)
+		<-canceled
+		assert.Equal(t, int32(1), attempts.Load())
+	})
+
+	t.Run("panics on a non-positive delay", func(t *testing.T) {
+		defer func() {
+			assert.NotEqual(t, nil, recover())
+		}()
+
+		TransportWithHedging(nil, HedgeConfig{})
+	})
+
+	t.Run("derives the delay from observed latency percentile", func(t *testing.T) {
+		// arrange
+		rt := TransportWithHedging(nil, HedgeConfig{Delay: time.Second, Percentile: 0.9, MinSamples: 10}).(*hedgingTransport)
+		delayBefore := rt.delay()
+
+		// act
+		for i := range 11 {
+			rt.observe(time.Duration(i) * time.Millisecond)
+		}
+
+		// assert
+		assert.Equal(t, time.Second, delayBefore)
+		assert.Equal(t, 9*time.Millisecond, rt.delay())
+	})
+}
@@ -0,0 +1,100 @@
+package httpaux
+
+import (
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/angrifel/unapologetic/internal/assert"
+)
+
+func TestTransportWithHMACSignature(t *testing.T) {
+	var (
+		sent     *http.Request
+		sentBody []byte
+	)
+
+	next := RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		sent, sentBody = req, nil
+
+		if req.Body != nil {
+			sentBody, _ = io.ReadAll(req.Body)
+		}
+
+		return &http.Response{StatusCode: http.StatusOK, Body: http.NoBody}, nil
+	})
+	config := HMACConfig{
+		KeyID:         "key-1",
+		Secret:        []byte("s3cr3t"),
+		SignedHeaders: []string{"Content-Type"},
+		Now:           func() time.Time { return time.Unix(1700000000, 0) },
+		Nonce:         func() string { return "n0nce" },
+	}
+
+	t.Run("signs the canonical request", func(t *testing.T) {
+		// arrange
+		rt := TransportWithHMACSignature(next, config)
+		req, _ := http.NewRequest(http.MethodPost, "https://api.example/v1/items?b=2&a=1",
+			io.NopCloser(strings.NewReader(`{"id":1}`)))
+		req.Header.Set("Content-Type", "application/json")
+
+		canonical := "POST\n/v1/items\na=1&b=2\n" +
+			"content-type:application/json\n" +
+			"host:api.example\n" +
+			"x-content-sha256:" + sha256Hex(`{"id":1}`) + "\n" +
+			"x-signature-nonce:n0nce\n" +
+			"x-signature-timestamp:1700000000\n" +
+			"\ncontent-type;host;x-content-sha256;x-signature-nonce;x-signature-timestamp\n" +
+			sha256Hex(`{"id":1}`)
+
+		// act
+		_, err := rt.RoundTrip(req)
+
+		// assert
+		assert.Equal(t, nil, err)
+		assert.Equal(t, `{"id":1}`, string(sentBody))
+		assert.Equal(t, "1700000000", sent.Header.Get(HeaderSignatureTimestamp))
+		assert.Equal(t, "n0nce", sent.Header.Get(HeaderSignatureNonce))
+		assert.Equal(t, "HMAC-SHA256 Credential=key-1, "+
+			"SignedHeaders=content-type;host;x-content-sha256;x-signature-nonce;x-signature-timestamp, "+
+			"Signature="+hexHMAC([]byte("s3cr3t"), canonical), sent.Header.Get("Authorization"))
+		assert.Equal(t, "", req.Header.Get("Authorization"))
+	})
+
+	t.Run("leaves an unsigned payload untouched", func(t *testing.T) {
+		// arrange
+		unsigned := config
+		unsigned.UnsignedPayload = true
+		rt := TransportWithHMACSignature(next, unsigned)
+		body := io.NopCloser(strings.NewReader("data"))
+		req, _ := http.NewRequest(http.MethodPut, "https://api.example/blob", body)
+
+		// act
+		_, err := rt.RoundTrip(req)
+
+		// assert
+		assert.Equal(t, nil, err)
+		assert.Equal(t, UnsignedPayload, sent.Header.Get(HeaderContentSHA256))
+		assert.Equal[io.ReadCloser](t, body, sent.Body)
+	})
+
+	t.Run("uses a fresh nonce by default", func(t *testing.T) {
+		// arrange
+		random := config
+		random.Nonce = nil
+		rt := TransportWithHMACSignature(next, random)
+
+		// act
+		req, _ := http.NewRequest(http.MethodGet, "https://api.example/", nil)
+		_, _ = rt.RoundTrip(req)
+		first := sent.Header.Get(HeaderSignatureNonce)
+		_, _ = rt.RoundTrip(req)
+		second := sent.Header.Get(HeaderSignatureNonce)
+
+		// assert
+		assert.Equal(t, 32, len(first))
+		assert.NotEqual(t, first, second)
+	})
+}
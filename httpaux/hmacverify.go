@@ -0,0 +1,331 @@
+package httpaux
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/angrifel/unapologetic/ioaux"
+)
+
+const (
+	defaultMaxClockSkew       = 5 * time.Minute
+	defaultMaxSignedBodySize  = 10 << 20
+	inMemoryNoncePruneEntries = 1024
+)
+
+var (
+	// ErrSignatureMissing is reported when a request has no, or a malformed, HMAC signature.
+	ErrSignatureMissing = errors.New("httpaux: missing or malformed request signature")
+	// ErrSignatureUnknownKey is reported when a request is signed with an unknown key ID.
+	ErrSignatureUnknownKey = errors.New("httpaux: request signed with an unknown key")
+	// ErrSignatureMismatch is reported when the signature or the payload hash of a request does not match.
+	ErrSignatureMismatch = errors.New("httpaux: request signature mismatch")
+	// ErrSignatureExpired is reported when the signature timestamp is outside of the allowed clock skew.
+	ErrSignatureExpired = errors.New("httpaux: request signature expired")
+	// ErrSignatureReplayed is reported when the nonce of a request has already been used.
+	ErrSignatureReplayed = errors.New("httpaux: request signature replayed")
+	// ErrUnsignedPayload is reported when a request with an unsigned payload is not allowed.
+	ErrUnsignedPayload = errors.New("httpaux: unsigned request payload not allowed")
+	// ErrRequestBodyTooLarge is reported when a request body is too large to be hashed.
+	ErrRequestBodyTooLarge = errors.New("httpaux: request body too large")
+)
+
+// NonceStore remembers the nonces of verified requests for replay protection.
+// Implementations must be safe for concurrent use.
+type NonceStore interface {
+	// UseNonce records nonce, which must be remembered at least until expiry, and reports whether it was unused.
+	UseNonce(ctx context.Context, nonce string, expiry time.Time) (bool, error)
+}
+
+// InMemoryNonceStore is a NonceStore that keeps nonces in memory, forgetting them once expired.
+// The zero value is ready to use.
+type InMemoryNonceStore struct {
+	// Now returns the current time. Defaults to time.Now.
+	Now func() time.Time
+
+	mu        sync.Mutex
+	nonces    map[string]time.Time
+	pruneSize int
+}
+
+var (
+	_ NonceStore   = (*InMemoryNonceStore)(nil)
+	_ http.Handler = (*hmacVerifyHandler)(nil)
+)
+
+// UseNonce records nonce until expiry and reports whether it was unused.
+func (s *InMemoryNonceStore) UseNonce(_ context.Context, nonce string, expiry time.Time) (bool, error) {
+	now := time.Now()
+	if s.Now != nil {
+		now = s.Now()
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.nonces == nil {
+		s.nonces = make(map[string]time.Time)
+	}
+
+	if until, ok := s.nonces[nonce]; ok && now.Before(until) {
+		return false, nil
+	}
+
+	s.nonces[nonce] = expiry
+
+	// prune expired nonces whenever the map has doubled since the last pruning, keeping it amortized O(1).
+	if len(s.nonces) >= max(s.pruneSize*2, inMemoryNoncePruneEntries) { //nolint:mnd // see above
+		for n, until := range s.nonces {
+			if !now.Before(until) {
+				delete(s.nonces, n)
+			}
+		}
+
+		s.pruneSize = len(s.nonces)
+	}
+
+	return true, nil
+}
+
+// HMACVerifyConfig configures HandlerWithHMACVerification.
+type HMACVerifyConfig struct {
+	// Secret returns the shared key of a key ID, or false if the key ID is unknown. It is required.
+	Secret func(keyID string) ([]byte, bool)
+	// RequiredHeaders lists the request headers that must be signed in addition to Host, the signature timestamp,
+	// nonce and content hash.
+	RequiredHeaders []string
+	// AllowUnsignedPayload accepts requests whose payload hash is UnsignedPayload. Their bodies are not read.
+	AllowUnsignedPayload bool
+	// MaxClockSkew is how far the signature timestamp may be from the current time. Defaults to 5m.
+	MaxClockSkew time.Duration
+	// Nonces remembers the nonces of verified requests. Defaults to an InMemoryNonceStore.
+	Nonces NonceStore
+	// MaxBodySize is the largest body that is buffered to be hashed. Defaults to 10MiB.
+	MaxBodySize int64
+	// OnError writes the response to requests that fail verification. Defaults to 413 Request Entity Too Large for
+	// ErrRequestBodyTooLarge, 401 Unauthorized for the other signature errors and 400 Bad Request otherwise.
+	OnError func(w http.ResponseWriter, r *http.Request, err error)
+	// Now returns the current time. Defaults to time.Now.
+	Now func() time.Time
+}
+
+type hmacVerifyHandler struct {
+	next   http.Handler
+	config HMACVerifyConfig
+}
+
+// HandlerWithHMACVerification wraps next with an http.Handler that only lets through requests signed by
+// TransportWithHMACSignature with a known key, within the allowed clock skew and with a nonce not seen before.
+//
+// The signature is checked over the claimed payload hash before the body is read. Signed payloads are then buffered,
+// up to config.MaxBodySize, with ioaux.ReadSeekCloser to be hashed, and r.Body is rewound so next reads the whole
+// body. The key ID of verified requests is available through HMACKeyID.
+func HandlerWithHMACVerification(next http.Handler, config HMACVerifyConfig) http.Handler {
+	if next == nil || config.Secret == nil {
+		panic("next and config.Secret must not be nil")
+	}
+
+	if config.MaxClockSkew == 0 {
+		config.MaxClockSkew = defaultMaxClockSkew
+	}
+
+	if config.Nonces == nil {
+		config.Nonces = &InMemoryNonceStore{Now: config.Now, mu: sync.Mutex{}, nonces: nil, pruneSize: 0}
+	}
+
+	if config.MaxBodySize == 0 {
+		config.MaxBodySize = defaultMaxSignedBodySize
+	}
+
+	if config.OnError == nil {
+		config.OnError = defaultHMACVerifyError
+	}
+
+	if config.Now == nil {
+		config.Now = time.Now
+	}
+
+	return &hmacVerifyHandler{next: next, config: config}
+}
+
+func defaultHMACVerifyError(w http.ResponseWriter, _ *http.Request, err error) {
+	switch {
+	case errors.Is(err, ErrRequestBodyTooLarge):
+		http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+	case errors.Is(err, ErrSignatureMissing), errors.Is(err, ErrSignatureUnknownKey),
+		errors.Is(err, ErrSignatureMismatch), errors.Is(err, ErrSignatureExpired),
+		errors.Is(err, ErrSignatureReplayed), errors.Is(err, ErrUnsignedPayload):
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+	default:
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	}
+}
+
+// ServeHTTP verifies the request and, if valid, serves it with next.
+func (h *hmacVerifyHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	keyID, err := h.verify(r)
+	if err != nil {
+		h.config.OnError(w, r, err)
+
+		return
+	}
+
+	h.next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), hmacKeyIDContextKey{}, keyID)))
+}
+
+func (h *hmacVerifyHandler) verify(r *http.Request) (string, error) {
+	auth, err := parseHMACAuthorization(r.Header.Get("Authorization"))
+	if err != nil {
+		return "", err
+	}
+
+	for _, name := range hmacSignedHeaders(h.config.RequiredHeaders) {
+		if !slices.Contains(auth.signedHeaders, name) {
+			return "", fmt.Errorf("%w: header %q is not signed", ErrSignatureMissing, name)
+		}
+	}
+
+	timestamp, err := strconv.ParseInt(r.Header.Get(HeaderSignatureTimestamp), 10, 64)
+	if err != nil {
+		return "", fmt.Errorf("%w: invalid timestamp", ErrSignatureMissing)
+	}
+
+	signedAt := time.Unix(timestamp, 0)
+	if skew := h.config.Now().Sub(signedAt); skew > h.config.MaxClockSkew || skew < -h.config.MaxClockSkew {
+		return "", ErrSignatureExpired
+	}
+
+	secret, ok := h.config.Secret(auth.keyID)
+	if !ok {
+		return "", ErrSignatureUnknownKey
+	}
+
+	// the signature covers the claimed payload hash, so it is checked first and only authenticated callers get their
+	// body buffered.
+	payloadHash := r.Header.Get(HeaderContentSHA256)
+	if payloadHash == UnsignedPayload && !h.config.AllowUnsignedPayload {
+		return "", ErrUnsignedPayload
+	}
+
+	expected := hmacSignature(secret, r, auth.signedHeaders, payloadHash)
+	if !hmac.Equal([]byte(expected), []byte(auth.signature)) {
+		return "", ErrSignatureMismatch
+	}
+
+	if err := h.verifyPayload(r, payloadHash); err != nil {
+		return "", err
+	}
+
+	nonce := r.Header.Get(HeaderSignatureNonce)
+	if nonce == "" {
+		return "", fmt.Errorf("%w: missing nonce", ErrSignatureMissing)
+	}
+
+	fresh, err := h.config.Nonces.UseNonce(r.Context(), auth.keyID+"\x00"+nonce, signedAt.Add(h.config.MaxClockSkew))
+	if err != nil {
+		return "", fmt.Errorf("httpaux: checking nonce: %w", err)
+	}
+
+	if !fresh {
+		return "", ErrSignatureReplayed
+	}
+
+	return auth.keyID, nil
+}
+
+// verifyPayload checks payloadHash against the request body, which is buffered and rewound for the next handler.
+func (h *hmacVerifyHandler) verifyPayload(r *http.Request, payloadHash string) error {
+	if payloadHash == UnsignedPayload {
+		return nil
+	}
+
+	hash := sha256.New()
+
+	if r.Body != nil && r.Body != http.NoBody {
+		body := ioaux.ReadSeekCloser(struct {
+			io.Reader
+			io.Closer
+		}{
+			Reader: io.LimitReader(r.Body, h.config.MaxBodySize+1),
+			Closer: r.Body,
+		})
+		r.Body = body
+
+		// io.ReadAll, rather than io.Copy, so that the read error of the buffered body is not bypassed by WriteTo.
+		data, err := io.ReadAll(body)
+		if err != nil {
+			return fmt.Errorf("%w: %w", ErrUnreadableRequestBody, err)
+		}
+
+		if int64(len(data)) > h.config.MaxBodySize {
+			return ErrRequestBodyTooLarge
+		}
+
+		_, _ = hash.Write(data)
+
+		if _, err := body.Seek(0, io.SeekStart); err != nil {
+			return fmt.Errorf("httpaux: rewinding request body: %w", err)
+		}
+	}
+
+	if hex.EncodeToString(hash.Sum(nil)) != payloadHash {
+		return fmt.Errorf("%w: payload hash", ErrSignatureMismatch)
+	}
+
+	return nil
+}
+
+type hmacAuthorization struct {
+	keyID         string
+	signedHeaders []string
+	signature     string
+}
+
+// parseHMACAuthorization parses the Authorization header set by TransportWithHMACSignature.
+func parseHMACAuthorization(value string) (hmacAuthorization, error) {
+	result := hmacAuthorization{keyID: "", signedHeaders: nil, signature: ""}
+
+	params, ok := strings.CutPrefix(value, HMACAlgorithm+" ")
+	if !ok {
+		return result, ErrSignatureMissing
+	}
+
+	for param := range strings.SplitSeq(params, ",") {
+		name, value, _ := strings.Cut(strings.TrimSpace(param), "=")
+
+		switch name {
+		case "Credential":
+			result.keyID = value
+		case "SignedHeaders":
+			result.signedHeaders = strings.Split(value, ";")
+		case "Signature":
+			result.signature = value
+		}
+	}
+
+	if result.keyID == "" || result.signedHeaders == nil || result.signature == "" {
+		return result, ErrSignatureMissing
+	}
+
+	return result, nil
+}
+
+type hmacKeyIDContextKey struct{}
+
+// HMACKeyID returns the key ID of a request verified by HandlerWithHMACVerification, from its context.
+func HMACKeyID(ctx context.Context) (string, bool) {
+	keyID, ok := ctx.Value(hmacKeyIDContextKey{}).(string)
+
+	return keyID, ok
+}
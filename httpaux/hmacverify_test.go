@@ -0,0 +1,252 @@
+package httpaux
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/angrifel/unapologetic/internal/assert"
+	"github.com/angrifel/unapologetic/iospy"
+)
+
+func TestHandlerWithHMACVerification(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	secrets := func(keyID string) ([]byte, bool) { return []byte("s3cr3t"), keyID == "key-1" }
+	clientConfig := HMACConfig{KeyID: "key-1", Secret: []byte("s3cr3t"), Now: func() time.Time { return now }}
+
+	// serve signs req with the client config and serves it with handler, as a server would receive it.
+	serve := func(handler http.Handler, config HMACConfig, req *http.Request) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		rt := TransportWithHMACSignature(RoundTripperFunc(func(signed *http.Request) (*http.Response, error) {
+			handler.ServeHTTP(rec, signed)
+
+			return rec.Result(), nil
+		}), config)
+		_, _ = rt.RoundTrip(req)
+
+		return rec
+	}
+
+	var (
+		served   bool
+		seenBody string
+		seenKey  string
+	)
+
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		served, seenBody = true, string(body)
+		seenKey, _ = HMACKeyID(r.Context())
+	})
+	serverConfig := HMACVerifyConfig{Secret: secrets, Now: func() time.Time { return now }}
+
+	t.Run("lets a signed request through with a readable body", func(t *testing.T) {
+		// arrange
+		served = false
+		handler := HandlerWithHMACVerification(next, serverConfig)
+		req, _ := http.NewRequest(http.MethodPost, "https://api.example/v1/items?a=1", strings.NewReader("payload"))
+
+		// act
+		rec := serve(handler, clientConfig, req)
+
+		// assert
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, true, served)
+		assert.Equal(t, "payload", seenBody)
+		assert.Equal(t, "key-1", seenKey)
+	})
+
+	t.Run("works over the network", func(t *testing.T) {
+		// arrange
+		served = false
+		server := httptest.NewServer(HandlerWithHMACVerification(next, HMACVerifyConfig{Secret: secrets}))
+		defer server.Close()
+
+		client := &http.Client{Transport: TransportWithHMACSignature(nil, HMACConfig{
+			KeyID:         "key-1",
+			Secret:        []byte("s3cr3t"),
+			SignedHeaders: []string{"Content-Type"},
+		})}
+
+		// act
+		resp, err := client.Post(server.URL+"/a%2Fb?z=1&y=2", "text/plain", io.NopCloser(strings.NewReader("hi")))
+
+		// assert
+		assert.Equal(t, nil, err)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, "hi", seenBody)
+
+		_ = resp.Body.Close()
+	})
+
+	t.Run("rejects tampered requests", func(t *testing.T) {
+		// arrange
+		served = false
+		handler := HandlerWithHMACVerification(next, serverConfig)
+		req, _ := http.NewRequest(http.MethodGet, "https://api.example/v1/items?a=1", nil)
+
+		// act
+		rec := serve(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			r.URL.RawQuery = "a=2"
+			handler.ServeHTTP(w, r)
+		}), clientConfig, req)
+
+		// assert
+		assert.Equal(t, http.StatusUnauthorized, rec.Code)
+		assert.Equal(t, false, served)
+	})
+
+	t.Run("reports verification errors", func(t *testing.T) {
+		for _, test := range []struct {
+			name     string
+			client   HMACConfig
+			server   HMACVerifyConfig
+			body     string
+			expected error
+		}{
+			{
+				name:     "unknown key",
+				client:   HMACConfig{KeyID: "key-2", Secret: []byte("s3cr3t"), Now: clientConfig.Now},
+				server:   serverConfig,
+				expected: ErrSignatureUnknownKey,
+			},
+			{
+				name:     "wrong secret",
+				client:   HMACConfig{KeyID: "key-1", Secret: []byte("other"), Now: clientConfig.Now},
+				server:   serverConfig,
+				expected: ErrSignatureMismatch,
+			},
+			{
+				name:     "clock skew",
+				client:   HMACConfig{KeyID: "key-1", Secret: []byte("s3cr3t"), Now: func() time.Time { return now.Add(-time.Hour) }},
+				server:   serverConfig,
+				expected: ErrSignatureExpired,
+			},
+			{
+				name:     "unsigned payload",
+				client:   HMACConfig{KeyID: "key-1", Secret: []byte("s3cr3t"), UnsignedPayload: true, Now: clientConfig.Now},
+				server:   serverConfig,
+				expected: ErrUnsignedPayload,
+			},
+			{
+				name:     "missing required header",
+				client:   clientConfig,
+				server:   HMACVerifyConfig{Secret: secrets, RequiredHeaders: []string{"Content-Type"}, Now: serverConfig.Now},
+				expected: ErrSignatureMissing,
+			},
+			{
+				name:     "body too large",
+				client:   clientConfig,
+				server:   HMACVerifyConfig{Secret: secrets, MaxBodySize: 3, Now: serverConfig.Now},
+				body:     "four",
+				expected: ErrRequestBodyTooLarge,
+			},
+		} {
+			t.Run(test.name, func(t *testing.T) {
+				// arrange
+				var reported error
+
+				config := test.server
+				config.OnError = func(w http.ResponseWriter, _ *http.Request, err error) {
+					reported = err
+					w.WriteHeader(http.StatusUnauthorized)
+				}
+				handler := HandlerWithHMACVerification(next, config)
+				req, _ := http.NewRequest(http.MethodPut, "https://api.example/", strings.NewReader(test.body))
+
+				// act
+				serve(handler, test.client, req)
+
+				// assert
+				assert.Equal(t, true, errors.Is(reported, test.expected))
+			})
+		}
+	})
+
+	t.Run("answers bodies cut short with 400", func(t *testing.T) {
+		// arrange
+		served = false
+		handler := HandlerWithHMACVerification(next, serverConfig)
+		req, _ := http.NewRequest(http.MethodPost, "https://api.example/", strings.NewReader("payload"))
+
+		// act
+		rec := serve(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			r.Body = io.NopCloser(iospy.LimitReaderWithError(r.Body, 3, errors.New("client disconnected")))
+			handler.ServeHTTP(w, r)
+		}), clientConfig, req)
+
+		// assert
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+		assert.Equal(t, false, served)
+	})
+
+	t.Run("does not read the body of requests with a wrong signature", func(t *testing.T) {
+		// arrange
+		var body io.Reader
+
+		handler := HandlerWithHMACVerification(next, serverConfig)
+		req, _ := http.NewRequest(http.MethodPost, "https://api.example/", strings.NewReader("payload"))
+
+		// act
+		rec := serve(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			body = iospy.WitnessReader(r.Body)
+			r.Body = io.NopCloser(body)
+			handler.ServeHTTP(w, r)
+		}), HMACConfig{KeyID: "key-1", Secret: []byte("other"), Now: clientConfig.Now}, req)
+
+		// assert
+		assert.Equal(t, http.StatusUnauthorized, rec.Code)
+		assert.Equal(t, 0, len(body.(iospy.ReaderWitness).ObservedReadCalls()))
+	})
+
+	t.Run("rejects replayed nonces", func(t *testing.T) {
+		// arrange
+		handler := HandlerWithHMACVerification(next, serverConfig)
+		replayed := clientConfig
+		replayed.Nonce = func() string { return "same" }
+
+		// act
+		first := serve(handler, replayed, httptest.NewRequest(http.MethodGet, "https://api.example/", nil))
+		second := serve(handler, replayed, httptest.NewRequest(http.MethodGet, "https://api.example/", nil))
+
+		// assert
+		assert.Equal(t, http.StatusOK, first.Code)
+		assert.Equal(t, http.StatusUnauthorized, second.Code)
+	})
+
+	t.Run("rejects requests without a signature", func(t *testing.T) {
+		// arrange
+		served = false
+		handler := HandlerWithHMACVerification(next, serverConfig)
+		rec := httptest.NewRecorder()
+
+		// act
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+
+		// assert
+		assert.Equal(t, http.StatusUnauthorized, rec.Code)
+		assert.Equal(t, false, served)
+	})
+}
+
+func TestInMemoryNonceStore(t *testing.T) {
+	// arrange
+	now := time.Unix(1700000000, 0)
+	store := &InMemoryNonceStore{Now: func() time.Time { return now }}
+
+	// act
+	first, _ := store.UseNonce(context.Background(), "n", now.Add(time.Minute))
+	again, _ := store.UseNonce(context.Background(), "n", now.Add(time.Minute))
+	now = now.Add(2 * time.Minute)
+	expired, _ := store.UseNonce(context.Background(), "n", now.Add(time.Minute))
+
+	// assert
+	assert.Equal(t, true, first)
+	assert.Equal(t, false, again)
+	assert.Equal(t, true, expired)
+}
@@ -0,0 +1,15 @@
+// Package httpauxtest provides testing utilities for http.RoundTripper implementations.
+//
+// # Request Mutation
+//
+// The http.RoundTripper contract forbids modifying the request it is given. AssertRequestNotMutated sends a
+// request through a transport wrapped around a stub and reports every part of the request the transport changed,
+// both while the stub handles the request and after RoundTrip returns:
+//
+//	func TestTransportDoesNotMutateRequests(t *testing.T) {
+//		req := httptest.NewRequest(http.MethodPost, "https://api.example.com/items", strings.NewReader("{}"))
+//		httpauxtest.AssertRequestNotMutated(t, func(next http.RoundTripper) http.RoundTripper {
+//			return NewTransport(next)
+//		}, req)
+//	}
+package httpauxtest
@@ -0,0 +1,141 @@
+package httpauxtest
+
+import (
+	"context"
+	"maps"
+	"net/http"
+	"net/url"
+	"reflect"
+	"slices"
+	"testing"
+)
+
+// roundTripperFunc is an adapter to allow the use of ordinary functions as http.RoundTripper.
+type roundTripperFunc func(req *http.Request) (*http.Response, error)
+
+var _ http.RoundTripper = roundTripperFunc(nil)
+
+// RoundTrip calls f(req).
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+// requestSnapshot captures the parts of a request an http.RoundTripper must not modify.
+type requestSnapshot struct {
+	method        string
+	urlPointer    *url.URL
+	url           string
+	host          string
+	header        http.Header
+	trailer       http.Header
+	contentLength int64
+	hasGetBody    bool
+	body          any
+	ctx           context.Context //nolint:containedctx // compared by identity
+}
+
+func snapshotRequest(req *http.Request) requestSnapshot {
+	var body any
+	// bodies are compared by identity, which only comparable bodies allow.
+	if req.Body != nil && reflect.ValueOf(req.Body).Comparable() {
+		body = req.Body
+	}
+
+	return requestSnapshot{
+		method:        req.Method,
+		urlPointer:    req.URL,
+		url:           req.URL.String(),
+		host:          req.Host,
+		header:        req.Header.Clone(),
+		trailer:       req.Trailer.Clone(),
+		contentLength: req.ContentLength,
+		hasGetBody:    req.GetBody != nil,
+		body:          body,
+		ctx:           req.Context(),
+	}
+}
+
+func compareSnapshots(t testing.TB, when string, expected, actual requestSnapshot) {
+	t.Helper()
+
+	if expected.method != actual.method {
+		t.Errorf("request method mutated %s: expected %q, got %q", when, expected.method, actual.method)
+	}
+
+	if expected.urlPointer != actual.urlPointer {
+		t.Errorf("request URL replaced %s", when)
+	}
+
+	if expected.url != actual.url {
+		t.Errorf("request URL mutated %s: expected %q, got %q", when, expected.url, actual.url)
+	}
+
+	if expected.host != actual.host {
+		t.Errorf("request host mutated %s: expected %q, got %q", when, expected.host, actual.host)
+	}
+
+	if !maps.EqualFunc(expected.header, actual.header, slices.Equal) {
+		t.Errorf("request header mutated %s: expected %v, got %v", when, expected.header, actual.header)
+	}
+
+	if !maps.EqualFunc(expected.trailer, actual.trailer, slices.Equal) {
+		t.Errorf("request trailer mutated %s: expected %v, got %v", when, expected.trailer, actual.trailer)
+	}
+
+	if expected.contentLength != actual.contentLength {
+		t.Errorf("request content length mutated %s: expected %d, got %d",
+			when, expected.contentLength, actual.contentLength)
+	}
+
+	if expected.hasGetBody != actual.hasGetBody {
+		t.Errorf("request GetBody mutated %s: expected set %t, got set %t", when, expected.hasGetBody, actual.hasGetBody)
+	}
+
+	if expected.body != actual.body {
+		t.Errorf("request body replaced %s", when)
+	}
+
+	if expected.ctx != actual.ctx {
+		t.Errorf("request context replaced %s", when)
+	}
+}
+
+// AssertRequestNotMutated sends req through the http.RoundTripper wrap builds around a stub and reports, through
+// t, every change the transport makes to req, both while the stub handles the request and after RoundTrip
+// returns. The method, URL, host, header, trailer, content length, GetBody, context and, when comparable, body of
+// req are checked. The stub answers with an empty 200 OK text/plain response. A RoundTrip error is reported too.
+func AssertRequestNotMutated(t testing.TB, wrap func(next http.RoundTripper) http.RoundTripper, req *http.Request) {
+	t.Helper()
+
+	before := snapshotRequest(req)
+
+	transport := wrap(roundTripperFunc(func(sent *http.Request) (*http.Response, error) {
+		compareSnapshots(t, "before the round trip", before, snapshotRequest(req))
+
+		return &http.Response{
+			Status:           "200 OK",
+			StatusCode:       http.StatusOK,
+			Proto:            "HTTP/1.1",
+			ProtoMajor:       1,
+			ProtoMinor:       1,
+			Header:           http.Header{"Content-Type": {"text/plain"}},
+			Body:             http.NoBody,
+			ContentLength:    0,
+			TransferEncoding: nil,
+			Close:            false,
+			Uncompressed:     false,
+			Trailer:          nil,
+			Request:          sent,
+			TLS:              nil,
+		}, nil
+	}))
+
+	resp, err := transport.RoundTrip(req)
+	if err != nil {
+		t.Errorf("unexpected round trip error: %v", err)
+	} else {
+		_ = resp.Body.Close()
+	}
+
+	compareSnapshots(t, "after the round trip", before, snapshotRequest(req))
+}
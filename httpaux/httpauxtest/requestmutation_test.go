@@ -0,0 +1,122 @@
+package httpauxtest
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// errorRecorder records the failures reported through it instead of failing the test.
+type errorRecorder struct {
+	testing.TB
+
+	errors []string
+}
+
+func (r *errorRecorder) Helper() {}
+
+func (r *errorRecorder) Errorf(format string, args ...any) {
+	r.errors = append(r.errors, fmt.Sprintf(format, args...))
+}
+
+func TestAssertRequestNotMutated(t *testing.T) {
+	newRequest := func() *http.Request {
+		req, _ := http.NewRequestWithContext(context.Background(), http.MethodPost, "https://api.example.com/items?a=1",
+			strings.NewReader("payload"))
+		req.Header.Set("X-Original", "yes")
+
+		return req
+	}
+
+	t.Run("passes transports that clone the request", func(t *testing.T) {
+		// arrange
+		recorder := &errorRecorder{TB: t}
+
+		// act
+		AssertRequestNotMutated(recorder, func(next http.RoundTripper) http.RoundTripper {
+			return roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+				req = req.Clone(req.Context())
+				req.Header.Set("X-Added", "yes")
+				req.URL.RawQuery = "b=2"
+
+				return next.RoundTrip(req)
+			})
+		}, newRequest())
+
+		// assert
+		if len(recorder.errors) != 0 {
+			t.Errorf("expected no errors, got %v", recorder.errors)
+		}
+	})
+
+	t.Run("reports mutations before and after the round trip", func(t *testing.T) {
+		// arrange
+		recorder := &errorRecorder{TB: t}
+
+		// act
+		AssertRequestNotMutated(recorder, func(next http.RoundTripper) http.RoundTripper {
+			return roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+				req.Header.Set("X-Added", "yes")
+
+				return next.RoundTrip(req)
+			})
+		}, newRequest())
+
+		// assert
+		if len(recorder.errors) != 2 {
+			t.Fatalf("expected 2 errors, got %v", recorder.errors)
+		}
+
+		if !strings.Contains(recorder.errors[0], "header mutated before") {
+			t.Errorf("expected a header mutation before the round trip, got %q", recorder.errors[0])
+		}
+
+		if !strings.Contains(recorder.errors[1], "header mutated after") {
+			t.Errorf("expected a header mutation after the round trip, got %q", recorder.errors[1])
+		}
+	})
+
+	t.Run("reports replaced URLs, bodies and contexts", func(t *testing.T) {
+		// arrange
+		recorder := &errorRecorder{TB: t}
+		req := newRequest()
+
+		// act
+		AssertRequestNotMutated(recorder, func(next http.RoundTripper) http.RoundTripper {
+			return roundTripperFunc(func(sent *http.Request) (*http.Response, error) {
+				resp, err := next.RoundTrip(sent)
+
+				u := *sent.URL
+				*sent = *sent.WithContext(context.WithoutCancel(sent.Context()))
+				sent.URL = &u
+				sent.Body = http.NoBody
+
+				return resp, err
+			})
+		}, req)
+
+		// assert
+		if len(recorder.errors) != 3 {
+			t.Fatalf("expected 3 errors, got %v", recorder.errors)
+		}
+	})
+
+	t.Run("reports round trip errors", func(t *testing.T) {
+		// arrange
+		recorder := &errorRecorder{TB: t}
+
+		// act
+		AssertRequestNotMutated(recorder, func(http.RoundTripper) http.RoundTripper {
+			return roundTripperFunc(func(*http.Request) (*http.Response, error) {
+				return nil, fmt.Errorf("refused")
+			})
+		}, newRequest())
+
+		// assert
+		if len(recorder.errors) != 1 || !strings.Contains(recorder.errors[0], "refused") {
+			t.Errorf("expected the round trip error, got %v", recorder.errors)
+		}
+	})
+}
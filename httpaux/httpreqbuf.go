@@ -0,0 +1,49 @@
+package httpaux
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+
+	"github.com/angrifel/unapologetic/ioaux"
+)
+
+// BufferRequestBody drains and closes the request body. The returned request is a clone of req whose body is an
+// in-memory io.ReadCloser that returns the content of the original body, and whose GetBody returns independent
+// copies of it, so the request can be replayed.
+// Any errors occurring while reading or closing the original body are
+// retained and returned back by the in-memory io.ReadCloser and by every copy.
+func BufferRequestBody(req *http.Request) *http.Request {
+	if req == nil {
+		return nil
+	}
+
+	result := req.Clone(req.Context())
+	if req.Body == nil || req.Body == http.NoBody {
+		return result
+	}
+
+	data, readErr := io.ReadAll(req.Body)
+	closeErr := req.Body.Close()
+
+	result.Body = memoryBody(data, readErr, closeErr)
+	result.GetBody = func() (io.ReadCloser, error) { return memoryBody(data, readErr, closeErr), nil }
+
+	return result
+}
+
+// memoryBody returns an io.ReadSeekCloser over data that returns readErr, if not nil, instead of io.EOF and closeErr
+// on Close, mirroring the body it was read from.
+func memoryBody(data []byte, readErr, closeErr error) io.ReadSeekCloser {
+	if readErr == nil {
+		readErr = io.EOF
+	}
+
+	return ioaux.ReadSeekCloser(struct {
+		io.Reader
+		io.Closer
+	}{
+		Reader: io.MultiReader(bytes.NewReader(data), ioaux.ReaderFunc(func([]byte) (int, error) { return 0, readErr })),
+		Closer: ioaux.CloserFunc(func() error { return closeErr }),
+	})
+}
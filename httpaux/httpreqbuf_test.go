@@ -0,0 +1,64 @@
+package httpaux
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/angrifel/unapologetic/internal/assert"
+	"github.com/angrifel/unapologetic/ioaux"
+	"github.com/angrifel/unapologetic/iospy"
+)
+
+func TestBufferRequestBody(t *testing.T) {
+	t.Run("call with nil request returns nil", func(t *testing.T) {
+		assert.Equal(t, (*http.Request)(nil), BufferRequestBody(nil))
+	})
+
+	t.Run("request without body is cloned", func(t *testing.T) {
+		// arrange
+		req := httptest.NewRequest(http.MethodGet, "http://a.example/", nil)
+
+		// act
+		buffered := BufferRequestBody(req)
+		buffered.Header.Set("X-Test", "1")
+
+		// assert
+		assert.NotEqual(t, req, buffered)
+		assert.Equal(t, "", req.Header.Get("X-Test"))
+		assert.Equal[io.ReadCloser](t, http.NoBody, buffered.Body)
+	})
+
+	t.Run("body is drained, closed and replayable with its errors", func(t *testing.T) {
+		// arrange
+		readErr := errors.New("read error")
+		closeErr := errors.New("close error")
+		closerWitness := iospy.WitnessCloser(ioaux.CloserFunc(func() error { return closeErr }))
+		req := httptest.NewRequest(http.MethodPut, "http://a.example/", struct {
+			io.Reader
+			io.Closer
+		}{
+			Reader: iospy.ReaderWithEOFError(strings.NewReader("payload"), readErr),
+			Closer: closerWitness,
+		})
+
+		// act
+		buffered := BufferRequestBody(req)
+
+		// assert
+		assert.Equal(t, 1, len(closerWitness.(iospy.CloserWitness).ObservedCloseCalls()))
+
+		for range 2 {
+			data, err := io.ReadAll(buffered.Body)
+			assert.Equal(t, "payload", string(data))
+			assert.Equal(t, readErr, err)
+			assert.Equal(t, closeErr, buffered.Body.Close())
+
+			buffered.Body, err = buffered.GetBody()
+			assert.Equal(t, nil, err)
+		}
+	})
+}
@@ -0,0 +1,505 @@
+package httpaux
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/angrifel/unapologetic/ioaux"
+)
+
+const (
+	// HeaderIdempotencyKey is the header carrying the idempotency key of a request.
+	HeaderIdempotencyKey = "Idempotency-Key"
+	// HeaderIdempotentReplayed is set to "true" on responses replayed by HandlerWithIdempotency.
+	HeaderIdempotentReplayed = "Idempotent-Replayed"
+)
+
+const (
+	defaultIdempotencyTTL           = 24 * time.Hour
+	defaultMaxIdempotentBodySize    = 10 << 20
+	inMemoryIdempotencyPruneEntries = 1024
+)
+
+var (
+	// ErrIdempotencyKeyReused is reported when an idempotency key is reused for a different request.
+	ErrIdempotencyKeyReused = errors.New("httpaux: idempotency key reused with a different request")
+	// ErrIdempotencyInProgress is reported when a request with the same idempotency key is still being processed.
+	ErrIdempotencyInProgress = errors.New("httpaux: request with the same idempotency key in progress")
+	// ErrUnreadableRequestBody is reported when the body of a request cannot be read to fingerprint or verify it.
+	ErrUnreadableRequestBody = errors.New("httpaux: unreadable request body")
+)
+
+type idempotencyKeyContextKey struct{}
+
+// ContextWithIdempotencyKey returns a copy of ctx carrying key, which TransportWithIdempotencyKey sends instead of
+// generating one, so that a logical request keeps its key however many times it is sent.
+func ContextWithIdempotencyKey(ctx context.Context, key string) context.Context {
+	return context.WithValue(ctx, idempotencyKeyContextKey{}, key)
+}
+
+// IdempotencyKeyConfig configures TransportWithIdempotencyKey.
+type IdempotencyKeyConfig struct {
+	// Methods lists the request methods that get an idempotency key. Defaults to POST and PATCH.
+	Methods []string
+	// NewKey returns a new idempotency key. Defaults to a random UUID.
+	NewKey func() string
+}
+
+type idempotencyKeyTransport struct {
+	next   http.RoundTripper
+	config IdempotencyKeyConfig
+}
+
+var (
+	_ http.RoundTripper   = (*idempotencyKeyTransport)(nil)
+	_ http.Handler        = (*idempotencyHandler)(nil)
+	_ responseInterceptor = (*idempotencyRecorder)(nil)
+	_ IdempotencyStore    = (*InMemoryIdempotencyStore)(nil)
+)
+
+// TransportWithIdempotencyKey wraps next with an http.RoundTripper that sets the Idempotency-Key header of requests
+// with one of config.Methods, to the key carried by the request context or else a new one. Requests that already
+// have the header keep it.
+//
+// The key is generated once per request passed to this transport, so where retries happen decides whether they keep
+// it. Retries performed by next, a retrying transport wrapped by this one, resend the same key. Retries performed
+// around this transport, such as a retry loop calling http.Client.Do again, send every attempt through it and get a
+// new key each time, unless the logical request carries its key with ContextWithIdempotencyKey. Either place this
+// transport above the retrying layer or pin the key in the context. A nil next uses http.DefaultTransport.
+func TransportWithIdempotencyKey(next http.RoundTripper, config IdempotencyKeyConfig) http.RoundTripper {
+	if config.Methods == nil {
+		config.Methods = []string{http.MethodPost, http.MethodPatch}
+	}
+
+	if config.NewKey == nil {
+		config.NewKey = randomUUID
+	}
+
+	return &idempotencyKeyTransport{next: transportOrDefault(next), config: config}
+}
+
+// RoundTrip sends req with an idempotency key.
+func (t *idempotencyKeyTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if !slices.Contains(t.config.Methods, req.Method) || req.Header.Get(HeaderIdempotencyKey) != "" {
+		return t.next.RoundTrip(req)
+	}
+
+	key, ok := req.Context().Value(idempotencyKeyContextKey{}).(string)
+	if !ok || key == "" {
+		key = t.config.NewKey()
+	}
+
+	out := req.Clone(req.Context())
+	out.Header.Set(HeaderIdempotencyKey, key)
+
+	return t.next.RoundTrip(out)
+}
+
+// randomUUID returns a random (version 4) UUID.
+func randomUUID() string {
+	var b [16]byte
+
+	_, _ = rand.Read(b[:])
+	b[6] = b[6]&0x0f | 0x40 //nolint:mnd // version 4
+	b[8] = b[8]&0x3f | 0x80 //nolint:mnd // RFC 9562 variant
+
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
+
+// IdempotentResponse is a response stored by an IdempotencyStore to be replayed.
+type IdempotentResponse struct {
+	// StatusCode is the status code of the response.
+	StatusCode int
+	// Header is the header of the response.
+	Header http.Header
+	// Body is the body of the response.
+	Body []byte
+}
+
+// IdempotencyRecord is what an IdempotencyStore knows about an idempotency key.
+type IdempotencyRecord struct {
+	// Fingerprint identifies the request that reserved the key.
+	Fingerprint string
+	// Response is the response to that request, or nil while it is in progress.
+	Response *IdempotentResponse
+}
+
+// IdempotencyStore remembers the requests served by HandlerWithIdempotency by idempotency key.
+// Implementations must be safe for concurrent use.
+type IdempotencyStore interface {
+	// Reserve claims key, until expiry, for the request identified by fingerprint and reports true. If key is already
+	// claimed, it reports false and returns its record.
+	Reserve(ctx context.Context, key, fingerprint string, expiry time.Time) (IdempotencyRecord, bool, error)
+	// Complete stores the response to the request that reserved key.
+	Complete(ctx context.Context, key string, response IdempotentResponse) error
+	// Release forgets key, so that the request can be served again.
+	Release(ctx context.Context, key string) error
+}
+
+// InMemoryIdempotencyStore is an IdempotencyStore that keeps the records in memory, forgetting them once expired.
+// The zero value is ready to use.
+type InMemoryIdempotencyStore struct {
+	// Now returns the current time. Defaults to time.Now.
+	Now func() time.Time
+
+	mu        sync.Mutex
+	records   map[string]inMemoryIdempotencyRecord
+	pruneSize int
+}
+
+type inMemoryIdempotencyRecord struct {
+	record IdempotencyRecord
+	expiry time.Time
+}
+
+// Reserve claims key for fingerprint until expiry, unless it is already claimed.
+func (s *InMemoryIdempotencyStore) Reserve(
+	_ context.Context, key, fingerprint string, expiry time.Time,
+) (IdempotencyRecord, bool, error) {
+	now := time.Now()
+	if s.Now != nil {
+		now = s.Now()
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.records == nil {
+		s.records = make(map[string]inMemoryIdempotencyRecord)
+	}
+
+	if existing, ok := s.records[key]; ok && now.Before(existing.expiry) {
+		return existing.record, false, nil
+	}
+
+	record := IdempotencyRecord{Fingerprint: fingerprint, Response: nil}
+	s.records[key] = inMemoryIdempotencyRecord{record: record, expiry: expiry}
+
+	// prune expired records whenever the map has doubled since the last pruning, keeping it amortized O(1).
+	if len(s.records) >= max(s.pruneSize*2, inMemoryIdempotencyPruneEntries) { //nolint:mnd // see above
+		for k, r := range s.records {
+			if !now.Before(r.expiry) {
+				delete(s.records, k)
+			}
+		}
+
+		s.pruneSize = len(s.records)
+	}
+
+	return record, true, nil
+}
+
+// Complete stores the response to the request that reserved key.
+func (s *InMemoryIdempotencyStore) Complete(_ context.Context, key string, response IdempotentResponse) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if existing, ok := s.records[key]; ok {
+		existing.record.Response = &response
+		s.records[key] = existing
+	}
+
+	return nil
+}
+
+// Release forgets key.
+func (s *InMemoryIdempotencyStore) Release(_ context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.records, key)
+
+	return nil
+}
+
+// IdempotencyConfig configures HandlerWithIdempotency.
+type IdempotencyConfig struct {
+	// Store remembers the requests and their responses. Defaults to an InMemoryIdempotencyStore.
+	Store IdempotencyStore
+	// Methods lists the request methods deduplicated. Defaults to POST and PATCH.
+	Methods []string
+	// TTL is how long a key is remembered. Defaults to 24 hours.
+	TTL time.Duration
+	// MaxBodySize is the largest request body that is buffered to be fingerprinted. Defaults to 10MiB.
+	MaxBodySize int64
+	// MaxResponseSize is the largest response body that is stored to be replayed. Larger responses are passed through
+	// without being stored, as if the request had no key. Defaults to 10MiB.
+	MaxResponseSize int64
+	// OnError writes the response to requests that cannot be served. Defaults to 422 Unprocessable Entity for
+	// ErrIdempotencyKeyReused, 409 Conflict for ErrIdempotencyInProgress, 413 Request Entity Too Large for
+	// ErrRequestBodyTooLarge, 400 Bad Request for ErrUnreadableRequestBody and 500 Internal Server Error otherwise,
+	// without the text of errors other than these.
+	OnError func(w http.ResponseWriter, r *http.Request, err error)
+	// Now returns the current time. Defaults to time.Now.
+	Now func() time.Time
+}
+
+type idempotencyHandler struct {
+	next   http.Handler
+	config IdempotencyConfig
+}
+
+// HandlerWithIdempotency wraps next with an http.Handler that serves requests with an Idempotency-Key header only
+// once per key. The first response is buffered, as BufferResponseBody would, while it is written, and replayed to
+// the requests that reuse the key, with an Idempotent-Replayed header.
+//
+// Requests are fingerprinted by method, URL and a hash of the body, buffered up to config.MaxBodySize: reusing a key
+// for a different request fails with ErrIdempotencyKeyReused, and while the first request is in progress, with
+// ErrIdempotencyInProgress. Server error responses, responses larger than config.MaxResponseSize and hijacked
+// connections are not stored, so the request can be retried. Requests without the header are served as they are.
+func HandlerWithIdempotency(next http.Handler, config IdempotencyConfig) http.Handler {
+	if next == nil {
+		panic("next must not be nil")
+	}
+
+	if config.Store == nil {
+		config.Store = &InMemoryIdempotencyStore{Now: config.Now, mu: sync.Mutex{}, records: nil, pruneSize: 0}
+	}
+
+	if config.Methods == nil {
+		config.Methods = []string{http.MethodPost, http.MethodPatch}
+	}
+
+	if config.TTL <= 0 {
+		config.TTL = defaultIdempotencyTTL
+	}
+
+	if config.MaxBodySize <= 0 {
+		config.MaxBodySize = defaultMaxIdempotentBodySize
+	}
+
+	if config.MaxResponseSize <= 0 {
+		config.MaxResponseSize = defaultMaxIdempotentBodySize
+	}
+
+	if config.OnError == nil {
+		config.OnError = defaultIdempotencyError
+	}
+
+	if config.Now == nil {
+		config.Now = time.Now
+	}
+
+	return &idempotencyHandler{next: next, config: config}
+}
+
+func defaultIdempotencyError(w http.ResponseWriter, _ *http.Request, err error) {
+	switch {
+	case errors.Is(err, ErrIdempotencyKeyReused):
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+	case errors.Is(err, ErrIdempotencyInProgress):
+		http.Error(w, err.Error(), http.StatusConflict)
+	case errors.Is(err, ErrRequestBodyTooLarge):
+		http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+	case errors.Is(err, ErrUnreadableRequestBody):
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+	default:
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+	}
+}
+
+// ServeHTTP serves the first request with a key and replays its response to the others.
+func (h *idempotencyHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	key := r.Header.Get(HeaderIdempotencyKey)
+	if key == "" || !slices.Contains(h.config.Methods, r.Method) {
+		h.next.ServeHTTP(w, r)
+
+		return
+	}
+
+	fingerprint, err := h.fingerprint(r)
+	if err != nil {
+		h.config.OnError(w, r, err)
+
+		return
+	}
+
+	ctx := r.Context()
+
+	record, reserved, err := h.config.Store.Reserve(ctx, key, fingerprint, h.config.Now().Add(h.config.TTL))
+
+	switch {
+	case err != nil:
+		h.config.OnError(w, r, fmt.Errorf("httpaux: reserving idempotency key: %w", err))
+	case !reserved && record.Fingerprint != fingerprint:
+		h.config.OnError(w, r, ErrIdempotencyKeyReused)
+	case !reserved && record.Response == nil:
+		h.config.OnError(w, r, ErrIdempotencyInProgress)
+	case !reserved:
+		replayIdempotentResponse(w, record.Response)
+	default:
+		h.serve(w, r, key)
+	}
+}
+
+// serve serves r with next, recording the response for key.
+func (h *idempotencyHandler) serve(w http.ResponseWriter, r *http.Request, key string) {
+	ctx := context.WithoutCancel(r.Context())
+	recorder := &idempotencyRecorder{
+		ResponseWriter: w,
+		maxSize:        h.config.MaxResponseSize,
+		statusCode:     0,
+		header:         nil,
+		body:           bytes.Buffer{},
+		discarded:      false,
+	}
+	completed := false
+
+	defer func() {
+		if !completed {
+			// next panicked: let the request be served again.
+			_ = h.config.Store.Release(ctx, key)
+		}
+	}()
+
+	h.next.ServeHTTP(exposeCapabilities(w, recorder), r)
+
+	completed = true
+	response := recorder.response()
+
+	if recorder.discarded || response.StatusCode >= http.StatusInternalServerError {
+		_ = h.config.Store.Release(ctx, key)
+
+		return
+	}
+
+	_ = h.config.Store.Complete(ctx, key, response)
+}
+
+// fingerprint hashes the method, URL and body of r, whose body is buffered and rewound for the next handler.
+func (h *idempotencyHandler) fingerprint(r *http.Request) (string, error) {
+	hash := sha256.New()
+	_, _ = io.WriteString(hash, r.Method+" "+r.URL.RequestURI()+"\n")
+
+	if r.Body != nil && r.Body != http.NoBody {
+		body := ioaux.ReadSeekCloser(struct {
+			io.Reader
+			io.Closer
+		}{
+			Reader: io.LimitReader(r.Body, h.config.MaxBodySize+1),
+			Closer: r.Body,
+		})
+		r.Body = body
+
+		// io.ReadAll, rather than io.Copy, so that the read error of the buffered body is not bypassed by WriteTo.
+		data, err := io.ReadAll(body)
+		if err != nil {
+			return "", fmt.Errorf("%w: %w", ErrUnreadableRequestBody, err)
+		}
+
+		_, _ = hash.Write(data)
+
+		if int64(len(data)) > h.config.MaxBodySize {
+			return "", ErrRequestBodyTooLarge
+		}
+
+		if _, err := body.Seek(0, io.SeekStart); err != nil {
+			return "", fmt.Errorf("httpaux: rewinding request body: %w", err)
+		}
+	}
+
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+func replayIdempotentResponse(w http.ResponseWriter, response *IdempotentResponse) {
+	header := w.Header()
+	for name, values := range response.Header {
+		header[name] = slices.Clone(values)
+	}
+
+	header.Set(HeaderIdempotentReplayed, "true")
+	w.WriteHeader(response.StatusCode)
+	_, _ = w.Write(response.Body)
+}
+
+// idempotencyRecorder writes the response through to the ResponseWriter while recording it, up to maxSize bytes of
+// body.
+type idempotencyRecorder struct {
+	http.ResponseWriter
+
+	maxSize    int64
+	statusCode int
+	header     http.Header
+	body       bytes.Buffer
+	// discarded reports whether the response cannot be stored, because it is too large or the connection was hijacked.
+	discarded bool
+}
+
+func (w *idempotencyRecorder) WriteHeader(statusCode int) {
+	if w.statusCode == 0 && statusCode >= http.StatusOK {
+		w.statusCode = statusCode
+		w.header = w.Header().Clone()
+	}
+
+	w.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (w *idempotencyRecorder) Write(p []byte) (int, error) {
+	if w.statusCode == 0 {
+		w.WriteHeader(http.StatusOK)
+	}
+
+	if !w.discarded && int64(w.body.Len()+len(p)) > w.maxSize {
+		w.discard()
+	}
+
+	if !w.discarded {
+		w.body.Write(p)
+	}
+
+	return w.ResponseWriter.Write(p)
+}
+
+// Unwrap returns the wrapped ResponseWriter, for http.ResponseController.
+func (w *idempotencyRecorder) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// flush flushes the wrapped ResponseWriter.
+func (w *idempotencyRecorder) flush() {
+	if w.statusCode == 0 {
+		w.WriteHeader(http.StatusOK)
+	}
+
+	_ = http.NewResponseController(w.ResponseWriter).Flush()
+}
+
+// hijack hijacks the connection of the wrapped ResponseWriter. The response of a hijacked connection is not stored.
+func (w *idempotencyRecorder) hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, rw, err := http.NewResponseController(w.ResponseWriter).Hijack()
+	if err != nil {
+		return nil, nil, fmt.Errorf("httpaux: hijacking connection: %w", err)
+	}
+
+	w.discard()
+
+	return conn, rw, nil
+}
+
+// discard stops recording the response and marks it as not to be stored.
+func (w *idempotencyRecorder) discard() {
+	w.discarded = true
+	w.body = bytes.Buffer{}
+}
+
+// response returns the recorded response, which is 200 OK with no body if next wrote nothing.
+func (w *idempotencyRecorder) response() IdempotentResponse {
+	if w.statusCode == 0 {
+		w.statusCode = http.StatusOK
+		w.header = w.Header().Clone()
+	}
+
+	return IdempotentResponse{StatusCode: w.statusCode, Header: w.header, Body: w.body.Bytes()}
+}
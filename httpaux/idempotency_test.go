@@ -0,0 +1,405 @@
+package httpaux
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"testing/iotest"
+	"time"
+
+	"github.com/angrifel/unapologetic/internal/assert"
+)
+
+func TestTransportWithIdempotencyKey(t *testing.T) {
+	var sent []string
+
+	next := RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		sent = append(sent, req.Header.Get(HeaderIdempotencyKey))
+
+		return &http.Response{StatusCode: http.StatusOK, Body: http.NoBody, Request: req}, nil
+	})
+
+	send := func(t *testing.T, transport http.RoundTripper, req *http.Request) {
+		t.Helper()
+
+		_, err := transport.RoundTrip(req)
+		assert.Equal(t, nil, err)
+	}
+
+	t.Run("generates a key per request", func(t *testing.T) {
+		// arrange
+		sent = nil
+		transport := TransportWithIdempotencyKey(next, IdempotencyKeyConfig{})
+		req, _ := http.NewRequestWithContext(context.Background(), http.MethodPost, "http://example.com", nil)
+
+		// act
+		send(t, transport, req)
+		send(t, transport, req)
+
+		// assert
+		uuid := regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`)
+
+		assert.Equal(t, true, uuid.MatchString(sent[0]))
+		assert.Equal(t, true, sent[0] != sent[1])
+		assert.Equal(t, "", req.Header.Get(HeaderIdempotencyKey))
+	})
+
+	t.Run("keeps the key of the context across attempts", func(t *testing.T) {
+		// arrange
+		sent = nil
+		transport := TransportWithIdempotencyKey(next, IdempotencyKeyConfig{})
+		ctx := ContextWithIdempotencyKey(context.Background(), "payment-42")
+		req, _ := http.NewRequestWithContext(ctx, http.MethodPost, "http://example.com", nil)
+
+		// act
+		send(t, transport, req)
+		send(t, transport, req.Clone(ctx))
+
+		// assert
+		assert.Equal(t, "payment-42", sent[0])
+		assert.Equal(t, "payment-42", sent[1])
+	})
+
+	t.Run("keeps the key across retries made below it", func(t *testing.T) {
+		// arrange
+		sent = nil
+		retrying := RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			for range 2 {
+				_, _ = next.RoundTrip(req)
+			}
+
+			return next.RoundTrip(req)
+		})
+		transport := TransportWithIdempotencyKey(retrying, IdempotencyKeyConfig{})
+		req, _ := http.NewRequestWithContext(context.Background(), http.MethodPost, "http://example.com", nil)
+
+		// act
+		send(t, transport, req)
+
+		// assert
+		assert.Equal(t, 3, len(sent))
+		assert.Equal(t, sent[0], sent[1])
+		assert.Equal(t, sent[0], sent[2])
+	})
+
+	t.Run("needs the key in the context across retries made above it", func(t *testing.T) {
+		// arrange
+		sent = nil
+		transport := TransportWithIdempotencyKey(next, IdempotencyKeyConfig{})
+		client := &http.Client{Transport: transport}
+		retry := func(ctx context.Context) {
+			for range 2 {
+				req, _ := http.NewRequestWithContext(ctx, http.MethodPost, "http://example.com", nil)
+				resp, err := client.Do(req)
+				assert.Equal(t, nil, err)
+
+				_ = resp.Body.Close()
+			}
+		}
+
+		// act
+		retry(context.Background())
+		retry(ContextWithIdempotencyKey(context.Background(), "payment-42"))
+
+		// assert
+		assert.Equal(t, 4, len(sent))
+		assert.NotEqual(t, sent[0], sent[1])
+		assert.Equal(t, "payment-42", sent[2])
+		assert.Equal(t, "payment-42", sent[3])
+	})
+
+	t.Run("leaves other methods and explicit keys alone", func(t *testing.T) {
+		// arrange
+		sent = nil
+		transport := TransportWithIdempotencyKey(next, IdempotencyKeyConfig{NewKey: func() string { return "new" }})
+		get, _ := http.NewRequestWithContext(context.Background(), http.MethodGet, "http://example.com", nil)
+		explicit, _ := http.NewRequestWithContext(context.Background(), http.MethodPatch, "http://example.com", nil)
+		explicit.Header.Set(HeaderIdempotencyKey, "mine")
+
+		// act
+		send(t, transport, get)
+		send(t, transport, explicit)
+
+		// assert
+		assert.Equal(t, "", sent[0])
+		assert.Equal(t, "mine", sent[1])
+	})
+}
+
+func TestHandlerWithIdempotency(t *testing.T) {
+	newHandler := func(calls *atomic.Int32, config IdempotencyConfig) http.Handler {
+		return HandlerWithIdempotency(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			n := calls.Add(1)
+			body, _ := io.ReadAll(r.Body)
+
+			if string(body) == "fail" {
+				w.WriteHeader(http.StatusServiceUnavailable)
+
+				return
+			}
+
+			w.Header().Set("X-Call", strconv.Itoa(int(n)))
+			w.WriteHeader(http.StatusCreated)
+			_, _ = w.Write([]byte("charged " + string(body)))
+		}), config)
+	}
+
+	post := func(handler http.Handler, key, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/charges", strings.NewReader(body))
+		if key != "" {
+			req.Header.Set(HeaderIdempotencyKey, key)
+		}
+
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, req)
+
+		return recorder
+	}
+
+	t.Run("replays the first response", func(t *testing.T) {
+		// arrange
+		var calls atomic.Int32
+
+		handler := newHandler(&calls, IdempotencyConfig{})
+
+		// act
+		first := post(handler, "k1", "10")
+		second := post(handler, "k1", "10")
+
+		// assert
+		assert.Equal(t, int32(1), calls.Load())
+		assert.Equal(t, http.StatusCreated, second.Code)
+		assert.Equal(t, "charged 10", second.Body.String())
+		assert.Equal(t, "1", second.Header().Get("X-Call"))
+		assert.Equal(t, "", first.Header().Get(HeaderIdempotentReplayed))
+		assert.Equal(t, "true", second.Header().Get(HeaderIdempotentReplayed))
+	})
+
+	t.Run("rejects conflicting payloads", func(t *testing.T) {
+		// arrange
+		var calls atomic.Int32
+
+		handler := newHandler(&calls, IdempotencyConfig{})
+
+		// act
+		post(handler, "k1", "10")
+		conflict := post(handler, "k1", "20")
+
+		// assert
+		assert.Equal(t, int32(1), calls.Load())
+		assert.Equal(t, http.StatusUnprocessableEntity, conflict.Code)
+	})
+
+	t.Run("rejects duplicates of requests in progress", func(t *testing.T) {
+		// arrange
+		var (
+			handler   http.Handler
+			duplicate *httptest.ResponseRecorder
+		)
+
+		handler = HandlerWithIdempotency(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {
+			duplicate = post(handler, "k1", "10")
+		}), IdempotencyConfig{})
+
+		// act
+		post(handler, "k1", "10")
+
+		// assert
+		assert.Equal(t, http.StatusConflict, duplicate.Code)
+	})
+
+	t.Run("does not store server errors", func(t *testing.T) {
+		// arrange
+		var calls atomic.Int32
+
+		handler := newHandler(&calls, IdempotencyConfig{})
+
+		// act
+		post(handler, "k1", "fail")
+		retry := post(handler, "k1", "fail")
+
+		// assert
+		assert.Equal(t, int32(2), calls.Load())
+		assert.Equal(t, http.StatusServiceUnavailable, retry.Code)
+	})
+
+	t.Run("serves requests without a key every time", func(t *testing.T) {
+		// arrange
+		var calls atomic.Int32
+
+		handler := newHandler(&calls, IdempotencyConfig{})
+
+		// act
+		post(handler, "", "10")
+		post(handler, "", "10")
+
+		// assert
+		assert.Equal(t, int32(2), calls.Load())
+	})
+
+	t.Run("limits the body size", func(t *testing.T) {
+		// arrange
+		var calls atomic.Int32
+
+		handler := newHandler(&calls, IdempotencyConfig{MaxBodySize: 2})
+
+		// act
+		response := post(handler, "k1", "1000")
+
+		// assert
+		assert.Equal(t, int32(0), calls.Load())
+		assert.Equal(t, http.StatusRequestEntityTooLarge, response.Code)
+	})
+
+	t.Run("does not store responses larger than the limit", func(t *testing.T) {
+		// arrange
+		var calls atomic.Int32
+
+		handler := newHandler(&calls, IdempotencyConfig{MaxResponseSize: 4})
+
+		// act
+		first := post(handler, "k1", "10")
+		second := post(handler, "k1", "10")
+
+		// assert
+		assert.Equal(t, int32(2), calls.Load())
+		assert.Equal(t, "charged 10", first.Body.String())
+		assert.Equal(t, "", second.Header().Get(HeaderIdempotentReplayed))
+	})
+
+	t.Run("rejects unreadable bodies without leaking the error", func(t *testing.T) {
+		// arrange
+		var calls atomic.Int32
+
+		handler := newHandler(&calls, IdempotencyConfig{})
+		req := httptest.NewRequest(http.MethodPost, "/charges", iotest.ErrReader(errors.New("connection reset by peer")))
+		req.Header.Set(HeaderIdempotencyKey, "k1")
+
+		recorder := httptest.NewRecorder()
+
+		// act
+		handler.ServeHTTP(recorder, req)
+
+		// assert
+		assert.Equal(t, int32(0), calls.Load())
+		assert.Equal(t, http.StatusBadRequest, recorder.Code)
+		assert.Equal(t, false, strings.Contains(recorder.Body.String(), "connection reset"))
+	})
+
+	t.Run("keeps flushing available to handlers", func(t *testing.T) {
+		// arrange
+		flushed := false
+		handler := HandlerWithIdempotency(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			_, _ = w.Write([]byte("partial"))
+			w.(http.Flusher).Flush()
+
+			flushed = true
+		}), IdempotencyConfig{})
+
+		// act
+		response := post(handler, "k1", "10")
+
+		// assert
+		assert.Equal(t, true, flushed)
+		assert.Equal(t, true, response.Flushed)
+	})
+
+	t.Run("does not claim capabilities the writer lacks", func(t *testing.T) {
+		// arrange
+		var flusher, hijacker bool
+
+		handler := HandlerWithIdempotency(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			_, flusher = w.(http.Flusher)
+			_, hijacker = w.(http.Hijacker)
+		}), IdempotencyConfig{})
+		req := httptest.NewRequest(http.MethodPost, "/payments", strings.NewReader("10"))
+		req.Header.Set(HeaderIdempotencyKey, "k1")
+
+		// act
+		handler.ServeHTTP(struct{ http.ResponseWriter }{httptest.NewRecorder()}, req)
+
+		// assert
+		assert.Equal(t, false, flusher)
+		assert.Equal(t, false, hijacker)
+	})
+
+	t.Run("releases the key when the handler panics", func(t *testing.T) {
+		// arrange
+		store := &InMemoryIdempotencyStore{}
+		handler := HandlerWithIdempotency(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {
+			panic(http.ErrAbortHandler)
+		}), IdempotencyConfig{Store: store})
+
+		// act
+		func() {
+			defer func() { _ = recover() }()
+
+			post(handler, "k1", "10")
+		}()
+
+		// assert
+		_, reserved, err := store.Reserve(context.Background(), "k1", "other", timeFarAway())
+
+		assert.Equal(t, nil, err)
+		assert.Equal(t, true, reserved)
+	})
+
+	t.Run("reports store errors", func(t *testing.T) {
+		// arrange
+		errStore := errors.New("store down")
+
+		var reported error
+
+		handler := HandlerWithIdempotency(http.NotFoundHandler(), IdempotencyConfig{
+			Store:   failingIdempotencyStore{err: errStore},
+			OnError: func(w http.ResponseWriter, _ *http.Request, err error) { reported = err },
+		})
+
+		// act
+		post(handler, "k1", "10")
+
+		// assert
+		assert.Equal(t, true, errors.Is(reported, errStore))
+	})
+
+	t.Run("does not leak store errors by default", func(t *testing.T) {
+		// arrange
+		handler := HandlerWithIdempotency(http.NotFoundHandler(), IdempotencyConfig{
+			Store: failingIdempotencyStore{err: errors.New("store down")},
+		})
+
+		// act
+		response := post(handler, "k1", "10")
+
+		// assert
+		assert.Equal(t, http.StatusInternalServerError, response.Code)
+		assert.Equal(t, false, strings.Contains(response.Body.String(), "store down"))
+	})
+}
+
+type failingIdempotencyStore struct {
+	err error
+}
+
+func (s failingIdempotencyStore) Reserve(context.Context, string, string, time.Time) (IdempotencyRecord, bool, error) {
+	return IdempotencyRecord{}, false, s.err
+}
+
+func (s failingIdempotencyStore) Complete(context.Context, string, IdempotentResponse) error {
+	return s.err
+}
+
+func (s failingIdempotencyStore) Release(context.Context, string) error {
+	return s.err
+}
+
+func timeFarAway() time.Time {
+	return time.Now().Add(time.Hour)
+}
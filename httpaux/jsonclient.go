@@ -0,0 +1,177 @@
+package httpaux
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+)
+
+const defaultMaxJSONResponseSize = 10 << 20
+
+var (
+	// ErrUnexpectedContentType is reported when a response is not JSON.
+	ErrUnexpectedContentType = errors.New("httpaux: unexpected response content type")
+	// ErrResponseTooLarge is reported when a response body exceeds the maximum size.
+	ErrResponseTooLarge = errors.New("httpaux: response body too large")
+)
+
+// ResponseError is returned by DoJSON and GetJSON when a response is rejected or cannot be decoded.
+// Response is the response, its body buffered with BufferResponseBody and readable from the start, for debugging.
+type ResponseError struct {
+	// Response is the rejected response, with a buffered body.
+	Response *http.Response
+	// Err is the reason the response was rejected, e.g. an *UnexpectedStatusError or a JSON decoding error.
+	Err error
+}
+
+func (e *ResponseError) Error() string {
+	if e.Response == nil || e.Response.Request == nil {
+		return "httpaux: " + e.Err.Error()
+	}
+
+	return fmt.Sprintf("httpaux: %s %s: %v", e.Response.Request.Method, e.Response.Request.URL, e.Err)
+}
+
+// Unwrap returns the reason the response was rejected.
+func (e *ResponseError) Unwrap() error {
+	return e.Err
+}
+
+// JSONConfig configures DoJSON and GetJSON.
+type JSONConfig struct {
+	// Header is sent with the request, overriding the default Accept and Content-Type headers.
+	Header http.Header
+	// ExpectedStatus lists the accepted status codes. Defaults to any 2xx status code.
+	ExpectedStatus []int
+	// MaxResponseSize is the size of the largest response body accepted. Defaults to 10MiB.
+	MaxResponseSize int64
+}
+
+// DoJSON sends request, encoded as JSON, with client, a nil client using http.DefaultClient, and decodes the JSON
+// response into a Resp.
+//
+// The request body is encoded as it is sent, and encoded again when it must be replayed, e.g. on redirects. The
+// response must have an expected status code and, unless it is 204 No Content, a JSON content type. A
+// *ResponseError, carrying the buffered response, is returned when it does not or its body cannot be decoded.
+func DoJSON[Req, Resp any](
+	ctx context.Context, client *http.Client, method, url string, request Req, config JSONConfig,
+) (Resp, error) {
+	encode := func() (io.ReadCloser, error) { return encodeJSONBody(request), nil }
+	result, _, err := doJSON[Resp](ctx, client, method, url, encode, config)
+
+	return result, err
+}
+
+// GetJSON sends a GET request with client, a nil client using http.DefaultClient, and decodes the JSON response into
+// a Resp, as DoJSON does.
+func GetJSON[Resp any](ctx context.Context, client *http.Client, url string, config JSONConfig) (Resp, error) {
+	result, _, err := doJSON[Resp](ctx, client, http.MethodGet, url, nil, config)
+
+	return result, err
+}
+
+// doJSON sends the request and decodes its response, which is also returned with its body buffered.
+func doJSON[Resp any](
+	ctx context.Context, client *http.Client, method, url string, getBody func() (io.ReadCloser, error),
+	config JSONConfig,
+) (Resp, *http.Response, error) {
+	var result Resp
+
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	if config.MaxResponseSize <= 0 {
+		config.MaxResponseSize = defaultMaxJSONResponseSize
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, url, nil)
+	if err != nil {
+		return result, nil, err
+	}
+
+	req.Header.Set("Accept", "application/json")
+
+	if getBody != nil {
+		req.Header.Set("Content-Type", "application/json")
+		req.Body, _ = getBody()
+		req.GetBody = getBody
+		req.ContentLength = -1
+	}
+
+	for name, values := range config.Header {
+		req.Header[http.CanonicalHeaderKey(name)] = slices.Clone(values)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return result, nil, err
+	}
+
+	resp.Body = struct {
+		io.Reader
+		io.Closer
+	}{Reader: io.LimitReader(resp.Body, config.MaxResponseSize+1), Closer: resp.Body}
+	resp = BufferResponseBody(resp)
+
+	data, err := io.ReadAll(resp.Body)
+	if seeker, ok := resp.Body.(io.Seeker); ok {
+		_, _ = seeker.Seek(0, io.SeekStart)
+	}
+
+	switch {
+	case err != nil:
+		return result, resp, &ResponseError{Response: resp, Err: err}
+	case int64(len(data)) > config.MaxResponseSize:
+		return result, resp, &ResponseError{Response: resp, Err: ErrResponseTooLarge}
+	case !isExpectedStatus(resp.StatusCode, config.ExpectedStatus):
+		return result, resp, &ResponseError{
+			Response: resp,
+			Err:      &UnexpectedStatusError{StatusCode: resp.StatusCode, Status: resp.Status},
+		}
+	case resp.StatusCode == http.StatusNoContent:
+		return result, resp, nil
+	case !isJSONMediaType(resp.Header.Get("Content-Type")):
+		return result, resp, &ResponseError{
+			Response: resp,
+			Err:      fmt.Errorf("%w: %q", ErrUnexpectedContentType, resp.Header.Get("Content-Type")),
+		}
+	}
+
+	if err := json.Unmarshal(data, &result); err != nil {
+		return result, resp, &ResponseError{Response: resp, Err: err}
+	}
+
+	return result, resp, nil
+}
+
+// encodeJSONBody returns a body that streams the JSON encoding of value from a goroutine started by the first Read.
+func encodeJSONBody(value any) io.ReadCloser {
+	pr, pw := io.Pipe()
+
+	return &lazyPipeReader{PipeReader: pr, once: sync.Once{}, start: func() {
+		pw.CloseWithError(json.NewEncoder(pw).Encode(value))
+	}}
+}
+
+func isExpectedStatus(statusCode int, expected []int) bool {
+	if len(expected) == 0 {
+		return statusCode >= http.StatusOK && statusCode < http.StatusMultipleChoices
+	}
+
+	return slices.Contains(expected, statusCode)
+}
+
+// isJSONMediaType reports whether contentType is application/json or a +json structured syntax suffix type.
+func isJSONMediaType(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+
+	return err == nil && (mediaType == "application/json" || strings.HasSuffix(mediaType, "+json"))
+}
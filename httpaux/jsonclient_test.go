@@ -0,0 +1,174 @@
+package httpaux
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/angrifel/unapologetic/internal/assert"
+)
+
+// jsonClientProbe reports, on marshaled, every time it is encoded.
+type jsonClientProbe struct {
+	marshaled chan struct{}
+}
+
+func (p jsonClientProbe) MarshalJSON() ([]byte, error) {
+	p.marshaled <- struct{}{}
+
+	return []byte("{}"), nil
+}
+
+type jsonClientGreeting struct {
+	Name    string `json:"name,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+
+func TestDoJSON(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/greet":
+			var in jsonClientGreeting
+
+			if r.Header.Get("Content-Type") != "application/json" || json.NewDecoder(r.Body).Decode(&in) != nil {
+				w.WriteHeader(http.StatusBadRequest)
+
+				return
+			}
+
+			w.Header().Set("Content-Type", "application/json; charset=utf-8")
+			w.WriteHeader(http.StatusCreated)
+			_ = json.NewEncoder(w).Encode(jsonClientGreeting{Message: "hello " + in.Name + " " + r.Header.Get("X-Trace")})
+		case "/redirect":
+			http.Redirect(w, r, "/greet", http.StatusPermanentRedirect)
+		case "/problem":
+			w.Header().Set("Content-Type", "application/problem+json")
+			w.WriteHeader(http.StatusConflict)
+			_, _ = w.Write([]byte(`{"title":"conflict"}`))
+		case "/html":
+			w.Header().Set("Content-Type", "text/html")
+			_, _ = w.Write([]byte("<html></html>"))
+		case "/broken":
+			w.Header().Set("Content-Type", "application/json")
+			_, _ = w.Write([]byte(`{"message":`))
+		case "/empty":
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}))
+	defer server.Close()
+
+	t.Run("sends and decodes JSON", func(t *testing.T) {
+		// act
+		out, err := DoJSON[jsonClientGreeting, jsonClientGreeting](context.Background(), nil, http.MethodPost,
+			server.URL+"/greet", jsonClientGreeting{Name: "gopher"}, JSONConfig{Header: http.Header{"X-Trace": {"t1"}}})
+
+		// assert
+		assert.Equal(t, nil, err)
+		assert.Equal(t, "hello gopher t1", out.Message)
+	})
+
+	t.Run("replays the body on redirects", func(t *testing.T) {
+		// act
+		out, err := DoJSON[jsonClientGreeting, jsonClientGreeting](context.Background(), server.Client(),
+			http.MethodPost, server.URL+"/redirect", jsonClientGreeting{Name: "again"}, JSONConfig{})
+
+		// assert
+		assert.Equal(t, nil, err)
+		assert.Equal(t, "hello again ", out.Message)
+	})
+
+	t.Run("rejects unexpected statuses with the buffered response", func(t *testing.T) {
+		// act
+		_, err := GetJSON[jsonClientGreeting](context.Background(), nil, server.URL+"/problem", JSONConfig{})
+
+		// assert
+		var respErr *ResponseError
+
+		assert.Equal(t, true, errors.Is(err, ErrUnexpectedStatus))
+		assert.Equal(t, true, errors.As(err, &respErr))
+		assert.Equal(t, http.StatusConflict, respErr.Response.StatusCode)
+
+		body, _ := io.ReadAll(respErr.Response.Body)
+		assert.Equal(t, `{"title":"conflict"}`, string(body))
+	})
+
+	t.Run("accepts configured statuses", func(t *testing.T) {
+		// act
+		out, err := GetJSON[map[string]string](context.Background(), nil, server.URL+"/problem", JSONConfig{
+			ExpectedStatus: []int{http.StatusConflict},
+		})
+
+		// assert
+		assert.Equal(t, nil, err)
+		assert.Equal(t, "conflict", out["title"])
+	})
+
+	t.Run("rejects other content types", func(t *testing.T) {
+		// act
+		_, err := GetJSON[jsonClientGreeting](context.Background(), nil, server.URL+"/html", JSONConfig{})
+
+		// assert
+		assert.Equal(t, true, errors.Is(err, ErrUnexpectedContentType))
+	})
+
+	t.Run("attaches the response to decode errors", func(t *testing.T) {
+		// act
+		_, err := GetJSON[jsonClientGreeting](context.Background(), nil, server.URL+"/broken", JSONConfig{})
+
+		// assert
+		var respErr *ResponseError
+
+		assert.Equal(t, true, errors.As(err, &respErr))
+
+		body, _ := io.ReadAll(respErr.Response.Body)
+		assert.Equal(t, `{"message":`, string(body))
+	})
+
+	t.Run("limits the response size", func(t *testing.T) {
+		// act
+		_, err := GetJSON[jsonClientGreeting](context.Background(), nil, server.URL+"/broken", JSONConfig{
+			MaxResponseSize: 4,
+		})
+
+		// assert
+		assert.Equal(t, true, errors.Is(err, ErrResponseTooLarge))
+	})
+
+	t.Run("accepts no content", func(t *testing.T) {
+		// act
+		out, err := GetJSON[*jsonClientGreeting](context.Background(), nil, server.URL+"/empty", JSONConfig{})
+
+		// assert
+		assert.Equal(t, nil, err)
+		assert.Equal(t, nil, out)
+	})
+	t.Run("does not encode request bodies that are never read", func(t *testing.T) {
+		// arrange
+		probe := jsonClientProbe{marshaled: make(chan struct{}, 1)}
+		client := &http.Client{Transport: RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			return &http.Response{
+				StatusCode: http.StatusNoContent,
+				Header:     http.Header{},
+				Body:       http.NoBody,
+				Request:    req,
+			}, nil
+		})}
+
+		// act
+		_, err := DoJSON[jsonClientProbe, *jsonClientGreeting](context.Background(), client, http.MethodPost,
+			"http://a.example/", probe, JSONConfig{})
+
+		// assert
+		assert.Equal(t, nil, err)
+		select {
+		case <-probe.marshaled:
+			t.Fatal("request body encoded although it was never read")
+		case <-time.After(10 * time.Millisecond):
+		}
+	})
+}
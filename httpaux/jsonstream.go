@@ -0,0 +1,195 @@
+package httpaux
+
+import (
+	"bufio"
+	"bytes"
+	"cmp"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"iter"
+	"slices"
+	"sync"
+)
+
+const (
+	// NDJSONContentType is the media type of newline-delimited JSON.
+	NDJSONContentType = "application/x-ndjson"
+	// JSONSeqContentType is the media type of JSON text sequences (RFC 7464).
+	JSONSeqContentType = "application/json-seq"
+)
+
+const (
+	defaultMaxRecordSize = 1 << 20
+	jsonSeqSeparator     = 0x1e
+	scannerInitialBuffer = 4 << 10
+)
+
+// ErrRecordTooLarge is reported when a record of a JSON stream exceeds the maximum record size.
+var ErrRecordTooLarge = errors.New("httpaux: JSON stream record too large")
+
+// JSONStreamError is yielded by DecodeNDJSON and DecodeJSONSeq when a record cannot be read or decoded.
+type JSONStreamError struct {
+	// Line is the 1-based line of the stream the record starts at.
+	Line int
+	// Err is the underlying error.
+	Err error
+}
+
+func (e *JSONStreamError) Error() string {
+	return fmt.Sprintf("httpaux: JSON stream line %d: %v", e.Line, e.Err)
+}
+
+// Unwrap returns the underlying error.
+func (e *JSONStreamError) Unwrap() error {
+	return e.Err
+}
+
+// JSONStreamConfig configures DecodeNDJSON and DecodeJSONSeq.
+type JSONStreamConfig struct {
+	// MaxRecordSize is the size of the largest record accepted. Defaults to 1MiB.
+	MaxRecordSize int
+}
+
+// DecodeNDJSON returns an iterator that decodes body, typically a response body, as newline-delimited JSON, one
+// value of type T per line. Blank lines are skipped.
+//
+// Iteration stops after the first error, a *JSONStreamError carrying the line number of the offending record.
+// body is closed once iteration stops, whether it ran to completion or was stopped early.
+func DecodeNDJSON[T any](body io.ReadCloser, config JSONStreamConfig) iter.Seq2[T, error] {
+	return decodeJSONStream[T](body, config, bufio.ScanLines, func([]byte) int { return 1 })
+}
+
+// DecodeJSONSeq returns an iterator that decodes body, typically a response body, as a JSON text sequence
+// (RFC 7464), one value of type T per record. Records are introduced by the record separator (0x1E), may span
+// several lines and are usually terminated by a line feed.
+//
+// Iteration stops after the first error, a *JSONStreamError carrying the line number the offending record starts
+// at. body is closed once iteration stops, whether it ran to completion or was stopped early.
+func DecodeJSONSeq[T any](body io.ReadCloser, config JSONStreamConfig) iter.Seq2[T, error] {
+	return decodeJSONStream[T](body, config, scanJSONSeq, func(record []byte) int {
+		return bytes.Count(record, []byte("\n"))
+	})
+}
+
+// decodeJSONStream decodes the records split out of body, lines reporting how many lines each record spans.
+func decodeJSONStream[T any](
+	body io.ReadCloser, config JSONStreamConfig, split bufio.SplitFunc, lines func(record []byte) int,
+) iter.Seq2[T, error] {
+	maxRecordSize := config.MaxRecordSize
+	if maxRecordSize <= 0 {
+		maxRecordSize = defaultMaxRecordSize
+	}
+
+	return func(yield func(T, error) bool) {
+		defer body.Close()
+
+		var zero T
+
+		scanner := bufio.NewScanner(body)
+		scanner.Buffer(make([]byte, 0, min(scannerInitialBuffer, maxRecordSize)), maxRecordSize)
+		scanner.Split(split)
+
+		line := 1
+
+		for scanner.Scan() {
+			record, start := scanner.Bytes(), line
+			line += lines(record)
+
+			if len(bytes.TrimSpace(record)) == 0 {
+				continue
+			}
+
+			var value T
+			if err := json.Unmarshal(record, &value); err != nil {
+				// the last record is cut short when reading fails: report the read error instead.
+				yield(zero, &JSONStreamError{Line: start, Err: cmp.Or(scanner.Err(), err)})
+
+				return
+			}
+
+			if !yield(value, nil) {
+				return
+			}
+		}
+
+		if err := scanner.Err(); err != nil {
+			if errors.Is(err, bufio.ErrTooLong) {
+				err = ErrRecordTooLarge
+			}
+
+			yield(zero, &JSONStreamError{Line: line, Err: err})
+		}
+	}
+}
+
+// scanJSONSeq is a bufio.SplitFunc that splits a JSON text sequence into the records between record separators.
+func scanJSONSeq(data []byte, atEOF bool) (int, []byte, error) {
+	if atEOF && len(data) == 0 {
+		return 0, nil, nil
+	}
+
+	if i := bytes.IndexByte(data, jsonSeqSeparator); i >= 0 {
+		return i + 1, data[:i], nil
+	}
+
+	if atEOF {
+		return len(data), data, nil
+	}
+
+	return 0, nil, nil
+}
+
+// EncodeNDJSON returns a body, typically a request body, that streams values encoded as newline-delimited JSON.
+// values is consumed lazily, as the body is read, from a separate goroutine started by the first Read. Once read
+// from, the body must be read to the end or closed, as http.Client does with request bodies, for the goroutine to
+// exit. Closing the body stops the iteration; an encoding error is returned by Read.
+func EncodeNDJSON[T any](values iter.Seq[T]) io.ReadCloser {
+	return encodeJSONStream(values, nil)
+}
+
+// EncodeJSONSeq returns a body, typically a request body, that streams values encoded as a JSON text sequence
+// (RFC 7464). values is consumed lazily, as the body is read, from a separate goroutine started by the first Read.
+// Once read from, the body must be read to the end or closed, as http.Client does with request bodies, for the
+// goroutine to exit. Closing the body stops the iteration; an encoding error is returned by Read.
+func EncodeJSONSeq[T any](values iter.Seq[T]) io.ReadCloser {
+	return encodeJSONStream(values, []byte{jsonSeqSeparator})
+}
+
+// encodeJSONStream writes every value as prefix, its JSON encoding and a line feed.
+func encodeJSONStream[T any](values iter.Seq[T], prefix []byte) io.ReadCloser {
+	pr, pw := io.Pipe()
+
+	return &lazyPipeReader{PipeReader: pr, once: sync.Once{}, start: func() {
+		var err error
+
+		for value := range values {
+			var record []byte
+
+			if record, err = json.Marshal(value); err != nil {
+				break
+			}
+
+			if _, err = pw.Write(append(append(slices.Clip(prefix), record...), '\n')); err != nil {
+				break
+			}
+		}
+
+		pw.CloseWithError(err)
+	}}
+}
+
+// lazyPipeReader runs start in a goroutine on the first Read, so that a body that is never read starts nothing.
+type lazyPipeReader struct {
+	*io.PipeReader
+
+	once  sync.Once
+	start func()
+}
+
+func (r *lazyPipeReader) Read(p []byte) (int, error) {
+	r.once.Do(func() { go r.start() })
+
+	return r.PipeReader.Read(p)
+}
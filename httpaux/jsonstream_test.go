@@ -0,0 +1,216 @@
+package httpaux
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"iter"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/angrifel/unapologetic/internal/assert"
+	"github.com/angrifel/unapologetic/iospy"
+)
+
+type jsonStreamItem struct {
+	N int `json:"n"`
+}
+
+func collectJSONStream[T any](values iter.Seq2[T, error]) ([]T, error) {
+	var result []T
+
+	for value, err := range values {
+		if err != nil {
+			return result, err
+		}
+
+		result = append(result, value)
+	}
+
+	return result, nil
+}
+
+func TestDecodeNDJSON(t *testing.T) {
+	t.Run("decodes every line", func(t *testing.T) {
+		// arrange
+		body := io.NopCloser(strings.NewReader("{\"n\":1}\n\n{\"n\":2}\r\n{\"n\":3}"))
+
+		// act
+		items, err := collectJSONStream(DecodeNDJSON[jsonStreamItem](body, JSONStreamConfig{}))
+
+		// assert
+		assert.Equal(t, nil, err)
+		assert.Equal(t, true, slices.Equal([]jsonStreamItem{{N: 1}, {N: 2}, {N: 3}}, items))
+	})
+
+	t.Run("reports the line of decode errors", func(t *testing.T) {
+		// arrange
+		body := io.NopCloser(strings.NewReader("{\"n\":1}\n\n{\"n\":\"two\"}\n{\"n\":3}\n"))
+
+		// act
+		items, err := collectJSONStream(DecodeNDJSON[jsonStreamItem](body, JSONStreamConfig{}))
+
+		// assert
+		var streamErr *JSONStreamError
+
+		var typeErr *json.UnmarshalTypeError
+
+		assert.Equal(t, 1, len(items))
+		assert.Equal(t, true, errors.As(err, &streamErr))
+		assert.Equal(t, 3, streamErr.Line)
+		assert.Equal(t, true, errors.As(err, &typeErr))
+	})
+
+	t.Run("guards the record size", func(t *testing.T) {
+		// arrange
+		body := io.NopCloser(strings.NewReader("{\"n\":1}\n{\"n\":12345678}\n"))
+
+		// act
+		_, err := collectJSONStream(DecodeNDJSON[jsonStreamItem](body, JSONStreamConfig{MaxRecordSize: 10}))
+
+		// assert
+		assert.Equal(t, true, errors.Is(err, ErrRecordTooLarge))
+	})
+
+	t.Run("closes the body when stopped early", func(t *testing.T) {
+		// arrange
+		closer := iospy.WitnessCloser(io.NopCloser(nil))
+		body := struct {
+			io.Reader
+			io.Closer
+		}{Reader: strings.NewReader("{\"n\":1}\n{\"n\":2}\n"), Closer: closer}
+
+		// act
+		for range DecodeNDJSON[jsonStreamItem](body, JSONStreamConfig{}) {
+			break
+		}
+
+		// assert
+		assert.Equal(t, 1, len(closer.(iospy.CloserWitness).ObservedCloseCalls()))
+	})
+
+	t.Run("reports read errors", func(t *testing.T) {
+		// arrange
+		errBroken := errors.New("broken")
+		body := io.NopCloser(iospy.LimitReaderWithError(strings.NewReader("{\"n\":1}\n{\"n\":2}\n"), 9, errBroken))
+
+		// act
+		items, err := collectJSONStream(DecodeNDJSON[jsonStreamItem](body, JSONStreamConfig{}))
+
+		// assert
+		assert.Equal(t, 1, len(items))
+		assert.Equal(t, true, errors.Is(err, errBroken))
+	})
+}
+
+func TestDecodeJSONSeq(t *testing.T) {
+	t.Run("decodes every record", func(t *testing.T) {
+		// arrange
+		body := io.NopCloser(strings.NewReader("\x1e{\"n\":1}\n\x1e{\n  \"n\": 2\n}\n\x1e{\"n\":3}\n"))
+
+		// act
+		items, err := collectJSONStream(DecodeJSONSeq[jsonStreamItem](body, JSONStreamConfig{}))
+
+		// assert
+		assert.Equal(t, nil, err)
+		assert.Equal(t, true, slices.Equal([]jsonStreamItem{{N: 1}, {N: 2}, {N: 3}}, items))
+	})
+
+	t.Run("reports the line a bad record starts at", func(t *testing.T) {
+		// arrange
+		body := io.NopCloser(strings.NewReader("\x1e{\n  \"n\": 1\n}\n\x1e{\"n\":\n"))
+
+		// act
+		_, err := collectJSONStream(DecodeJSONSeq[jsonStreamItem](body, JSONStreamConfig{}))
+
+		// assert
+		var streamErr *JSONStreamError
+
+		assert.Equal(t, true, errors.As(err, &streamErr))
+		assert.Equal(t, 4, streamErr.Line)
+	})
+}
+
+func TestEncodeJSONStream(t *testing.T) {
+	items := slices.Values([]jsonStreamItem{{N: 1}, {N: 2}})
+
+	t.Run("encodes NDJSON", func(t *testing.T) {
+		// act
+		data, err := io.ReadAll(EncodeNDJSON(items))
+
+		// assert
+		assert.Equal(t, nil, err)
+		assert.Equal(t, "{\"n\":1}\n{\"n\":2}\n", string(data))
+	})
+
+	t.Run("encodes JSON text sequences", func(t *testing.T) {
+		// act
+		data, err := io.ReadAll(EncodeJSONSeq(items))
+
+		// assert
+		assert.Equal(t, nil, err)
+		assert.Equal(t, "\x1e{\"n\":1}\n\x1e{\"n\":2}\n", string(data))
+	})
+
+	t.Run("round trips", func(t *testing.T) {
+		// act
+		decoded, err := collectJSONStream(DecodeJSONSeq[jsonStreamItem](EncodeJSONSeq(items), JSONStreamConfig{}))
+
+		// assert
+		assert.Equal(t, nil, err)
+		assert.Equal(t, true, slices.Equal(slices.Collect(items), decoded))
+	})
+
+	t.Run("reports encoding errors", func(t *testing.T) {
+		// act
+		_, err := io.ReadAll(EncodeNDJSON(slices.Values([]any{1, func() {}})))
+
+		// assert
+		var unsupported *json.UnsupportedTypeError
+
+		assert.Equal(t, true, errors.As(err, &unsupported))
+	})
+
+	t.Run("stops the iteration when closed", func(t *testing.T) {
+		// arrange
+		stopped := make(chan struct{})
+		body := EncodeNDJSON(func(yield func(int) bool) {
+			defer close(stopped)
+
+			for i := 0; yield(i); i++ {
+			}
+		})
+
+		// act
+		_, _ = io.ReadFull(body, make([]byte, 4))
+		_ = body.Close()
+
+		// assert
+		<-stopped
+	})
+
+	t.Run("does not consume values until read", func(t *testing.T) {
+		// arrange
+		consumed := make(chan struct{}, 1)
+
+		// act
+		body := EncodeNDJSON(func(yield func(int) bool) {
+			consumed <- struct{}{}
+			yield(1)
+		})
+
+		// assert
+		select {
+		case <-consumed:
+			t.Fatal("values consumed before the body was read")
+		case <-time.After(10 * time.Millisecond):
+		}
+
+		data, err := io.ReadAll(body)
+
+		assert.Equal(t, nil, err)
+		assert.Equal(t, "1\n", string(data))
+	})
+}
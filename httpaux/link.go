@@ -0,0 +1,143 @@
+package httpaux
+
+import (
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// Link is a web link of a Link header (RFC 8288).
+type Link struct {
+	// URL is the target URI reference, as written in the header.
+	URL string
+	// Rel is the relation type, possibly several separated by spaces.
+	Rel string
+	// Params holds the target attributes, keyed by lower-case name, rel included.
+	Params map[string]string
+}
+
+// HasRel reports whether rel is one of the relation types of the link, compared case-insensitively.
+func (l Link) HasRel(rel string) bool {
+	return slices.ContainsFunc(strings.Fields(l.Rel), func(r string) bool { return strings.EqualFold(r, rel) })
+}
+
+// ParseLinkHeader parses every Link header of header into its links. Malformed links are skipped.
+func ParseLinkHeader(header http.Header) []Link {
+	var links []Link
+
+	for _, value := range header.Values("Link") {
+		for len(value) > 0 {
+			var (
+				link Link
+				ok   bool
+			)
+
+			link, value, ok = parseLink(value)
+			if ok {
+				links = append(links, link)
+			}
+		}
+	}
+
+	return links
+}
+
+// parseLink parses the first link of s, returning it along with the rest of s, after the separating comma.
+func parseLink(s string) (Link, string, bool) {
+	link := Link{URL: "", Rel: "", Params: map[string]string{}}
+
+	s = strings.TrimLeft(s, " \t,")
+
+	if !strings.HasPrefix(s, "<") {
+		_, rest, _ := cutUnquoted(s, ',')
+
+		return link, rest, false
+	}
+
+	end := strings.IndexByte(s, '>')
+	if end < 0 {
+		return link, "", false
+	}
+
+	link.URL, s = s[1:end], s[end+1:]
+
+	for {
+		s = strings.TrimLeft(s, " \t")
+
+		if !strings.HasPrefix(s, ";") {
+			_, rest, _ := cutUnquoted(s, ',')
+			link.Rel = link.Params["rel"]
+
+			return link, rest, true
+		}
+
+		var param string
+
+		param, s = cutParam(s[1:])
+
+		name, value, _ := strings.Cut(param, "=")
+		name = strings.ToLower(strings.TrimSpace(name))
+		value = unquote(strings.TrimSpace(value))
+
+		// only the first occurrence of a parameter is considered.
+		if _, seen := link.Params[name]; name != "" && !seen {
+			link.Params[name] = value
+		}
+	}
+}
+
+// cutParam returns the parameter at the start of s, up to the next unquoted ';' or ',', and the rest of s, starting
+// at that separator.
+func cutParam(s string) (string, string) {
+	quoted := false
+
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case c == '\\' && quoted:
+			i++
+		case c == '"':
+			quoted = !quoted
+		case (c == ';' || c == ',') && !quoted:
+			return s[:i], s[i:]
+		}
+	}
+
+	return s, ""
+}
+
+// cutUnquoted cuts s around the first sep outside of a quoted string.
+func cutUnquoted(s string, sep byte) (string, string, bool) {
+	quoted := false
+
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case c == '\\' && quoted:
+			i++
+		case c == '"':
+			quoted = !quoted
+		case c == sep && !quoted:
+			return s[:i], s[i+1:], true
+		}
+	}
+
+	return s, "", false
+}
+
+// unquote removes the quotes and escapes of a quoted-string, leaving tokens untouched.
+func unquote(s string) string {
+	if len(s) < 2 || s[0] != '"' || s[len(s)-1] != '"' {
+		return s
+	}
+
+	var b strings.Builder
+
+	for i := 1; i < len(s)-1; i++ {
+		if s[i] == '\\' && i+1 < len(s)-1 {
+			i++
+		}
+
+		b.WriteByte(s[i])
+	}
+
+	return b.String()
+}
@@ -0,0 +1,56 @@
+package httpaux
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/angrifel/unapologetic/internal/assert"
+)
+
+func TestParseLinkHeader(t *testing.T) {
+	t.Run("parses every link", func(t *testing.T) {
+		// arrange
+		header := http.Header{"Link": {
+			`<https://api.example.com/items?page=2>; rel="next", <https://api.example.com/items?page=9>; rel=last`,
+			`</items?page=1>; REL="first prev"; title="a, b; c"`,
+		}}
+
+		// act
+		links := ParseLinkHeader(header)
+
+		// assert
+		assert.Equal(t, 3, len(links))
+		assert.Equal(t, "https://api.example.com/items?page=2", links[0].URL)
+		assert.Equal(t, "next", links[0].Rel)
+		assert.Equal(t, "last", links[1].Rel)
+		assert.Equal(t, "/items?page=1", links[2].URL)
+		assert.Equal(t, true, links[2].HasRel("prev"))
+		assert.Equal(t, true, links[2].HasRel("FIRST"))
+		assert.Equal(t, false, links[2].HasRel("next"))
+		assert.Equal(t, "a, b; c", links[2].Params["title"])
+	})
+
+	t.Run("unescapes quoted values and keeps the first occurrence", func(t *testing.T) {
+		// arrange
+		header := http.Header{"Link": {`<a>; title="say \"hi\""; title="ignored"; rel=next`}}
+
+		// act
+		links := ParseLinkHeader(header)
+
+		// assert
+		assert.Equal(t, 1, len(links))
+		assert.Equal(t, `say "hi"`, links[0].Params["title"])
+	})
+
+	t.Run("skips malformed links", func(t *testing.T) {
+		// arrange
+		header := http.Header{"Link": {`oops; rel=next, <b>; rel=next, <c`}}
+
+		// act
+		links := ParseLinkHeader(header)
+
+		// assert
+		assert.Equal(t, 1, len(links))
+		assert.Equal(t, "b", links[0].URL)
+	})
+}
@@ -0,0 +1,266 @@
+package httpaux
+
+import (
+	"crypto/tls"
+	"io"
+	"maps"
+	"net/http"
+	"net/http/httptrace"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// StatusClassError is the MetricsKey.StatusClass reported for round trips that failed without a response.
+const StatusClassError = "error"
+
+// Metrics is a sink for the measurements taken by TransportWithMetrics.
+// Implementations must be safe for concurrent use.
+type Metrics interface {
+	// ObserveRoundTrip records the measurements of a single round trip.
+	ObserveRoundTrip(key MetricsKey, sample MetricsSample)
+}
+
+// MetricsKey identifies the series a MetricsSample belongs to.
+type MetricsKey struct {
+	// Host is the request URL host.
+	Host string
+	// Method is the request method.
+	Method string
+	// StatusClass is the response status class ("2xx", "4xx", ...) or StatusClassError.
+	StatusClass string
+}
+
+// MetricsSample holds the timings observed during a single round trip.
+// Phases that did not happen (e.g. DNS on a reused connection) are reported as zero.
+type MetricsSample struct {
+	// DNS is the time spent resolving the host name.
+	DNS time.Duration
+	// Connect is the time spent establishing the TCP connection. When several dials race, as with happy eyeballs,
+	// it is the time of the first one to succeed.
+	Connect time.Duration
+	// TLSHandshake is the time spent on the TLS handshake.
+	TLSHandshake time.Duration
+	// TimeToFirstByte is the time from the start of the round trip to the first response byte.
+	TimeToFirstByte time.Duration
+	// BodyRead is the time from the end of the round trip until the body was fully read or closed, or zero for
+	// responses without a body.
+	BodyRead time.Duration
+	// ConnReused reports whether the request was sent on a previously used connection.
+	ConnReused bool
+}
+
+// MetricsAggregate is the running aggregate of every MetricsSample observed for a MetricsKey.
+type MetricsAggregate struct {
+	// Count is the number of samples observed.
+	Count int
+	// ConnReused is the number of samples that reused a connection.
+	ConnReused int
+	// DNS is the sum of all DNS durations.
+	DNS time.Duration
+	// Connect is the sum of all Connect durations.
+	Connect time.Duration
+	// TLSHandshake is the sum of all TLSHandshake durations.
+	TLSHandshake time.Duration
+	// TimeToFirstByte is the sum of all TimeToFirstByte durations.
+	TimeToFirstByte time.Duration
+	// BodyRead is the sum of all BodyRead durations.
+	BodyRead time.Duration
+}
+
+// InMemoryMetrics is a Metrics implementation that aggregates samples in memory.
+// The zero value is ready to use.
+type InMemoryMetrics struct {
+	mu     sync.Mutex
+	series map[MetricsKey]MetricsAggregate
+}
+
+var (
+	_ Metrics           = (*InMemoryMetrics)(nil)
+	_ http.RoundTripper = (*metricsTransport)(nil)
+	_ io.ReadCloser     = (*metricsBody)(nil)
+)
+
+// ObserveRoundTrip adds sample to the aggregate of key.
+func (m *InMemoryMetrics) ObserveRoundTrip(key MetricsKey, sample MetricsSample) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.series == nil {
+		m.series = make(map[MetricsKey]MetricsAggregate)
+	}
+
+	agg := m.series[key]
+	agg.Count++
+	agg.DNS += sample.DNS
+	agg.Connect += sample.Connect
+	agg.TLSHandshake += sample.TLSHandshake
+	agg.TimeToFirstByte += sample.TimeToFirstByte
+	agg.BodyRead += sample.BodyRead
+
+	if sample.ConnReused {
+		agg.ConnReused++
+	}
+
+	m.series[key] = agg
+}
+
+// Snapshot returns a copy of the aggregates observed so far.
+func (m *InMemoryMetrics) Snapshot() map[MetricsKey]MetricsAggregate {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return maps.Clone(m.series)
+}
+
+type metricsTransport struct {
+	next http.RoundTripper
+	sink Metrics
+}
+
+// TransportWithMetrics wraps next with an http.RoundTripper that attaches an httptrace.ClientTrace to every request
+// and reports its DNS, connect, TLS handshake, time-to-first-byte and body-read timings to sink.
+//
+// Samples for successful round trips are reported once the response body has been read to the end or closed,
+// so callers must consume or close the body for the sample to be recorded. Failed round trips are reported
+// immediately with StatusClassError. A nil next uses http.DefaultTransport.
+func TransportWithMetrics(next http.RoundTripper, sink Metrics) http.RoundTripper {
+	if sink == nil {
+		panic("sink must not be nil")
+	}
+
+	return &metricsTransport{next: transportOrDefault(next), sink: sink}
+}
+
+// RoundTrip sends the request through the wrapped transport while tracing it.
+func (t *metricsTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	trace := newRoundTripTrace(time.Now())
+	traced := req.WithContext(httptrace.WithClientTrace(req.Context(), trace.clientTrace()))
+
+	resp, err := t.next.RoundTrip(traced)
+	if err != nil {
+		t.sink.ObserveRoundTrip(MetricsKey{Host: req.URL.Host, Method: req.Method, StatusClass: StatusClassError}, trace.snapshot())
+
+		return resp, err
+	}
+
+	key := MetricsKey{Host: req.URL.Host, Method: req.Method, StatusClass: statusClass(resp.StatusCode)}
+
+	if resp.Body == nil {
+		t.sink.ObserveRoundTrip(key, trace.snapshot())
+
+		return resp, nil
+	}
+
+	bodyStart := time.Now()
+	body := &metricsBody{ReadCloser: resp.Body, once: sync.Once{}, done: func() {
+		sample := trace.snapshot()
+		sample.BodyRead = time.Since(bodyStart)
+		t.sink.ObserveRoundTrip(key, sample)
+	}}
+
+	return CloneHTTPResponseWithBody(resp, body), nil
+}
+
+func statusClass(code int) string {
+	return strconv.Itoa(code/100) + "xx" //nolint:mnd // status classes are the hundreds digit
+}
+
+// roundTripTrace collects the httptrace events of a single round trip.
+// Its callbacks may be invoked concurrently, hence the mutex.
+type roundTripTrace struct {
+	mu     sync.Mutex
+	start  time.Time
+	sample MetricsSample
+
+	dnsStart, tlsStart time.Time
+	// connectStarts holds the start of every dial by network and address, as several may race.
+	connectStarts map[string]time.Time
+}
+
+func newRoundTripTrace(start time.Time) *roundTripTrace {
+	return &roundTripTrace{
+		mu:            sync.Mutex{},
+		start:         start,
+		sample:        MetricsSample{},
+		dnsStart:      time.Time{},
+		tlsStart:      time.Time{},
+		connectStarts: make(map[string]time.Time),
+	}
+}
+
+func (r *roundTripTrace) clientTrace() *httptrace.ClientTrace {
+	return &httptrace.ClientTrace{
+		DNSStart: func(httptrace.DNSStartInfo) { r.mark(&r.dnsStart) },
+		DNSDone:  func(httptrace.DNSDoneInfo) { r.measure(&r.dnsStart, &r.sample.DNS) },
+		ConnectStart: func(network, addr string) {
+			r.mu.Lock()
+			defer r.mu.Unlock()
+
+			r.connectStarts[network+" "+addr] = time.Now()
+		},
+		ConnectDone: func(network, addr string, err error) {
+			r.mu.Lock()
+			defer r.mu.Unlock()
+
+			if start, ok := r.connectStarts[network+" "+addr]; ok && err == nil && r.sample.Connect == 0 {
+				r.sample.Connect = time.Since(start)
+			}
+		},
+		TLSHandshakeStart: func() { r.mark(&r.tlsStart) },
+		TLSHandshakeDone: func(tls.ConnectionState, error) {
+			r.measure(&r.tlsStart, &r.sample.TLSHandshake)
+		},
+		GotConn: func(info httptrace.GotConnInfo) {
+			r.mu.Lock()
+			defer r.mu.Unlock()
+
+			r.sample.ConnReused = info.Reused
+		},
+		GotFirstResponseByte: func() { r.measure(&r.start, &r.sample.TimeToFirstByte) },
+	}
+}
+
+func (r *roundTripTrace) mark(at *time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	*at = time.Now()
+}
+
+func (r *roundTripTrace) measure(since *time.Time, into *time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	*into = time.Since(*since)
+}
+
+func (r *roundTripTrace) snapshot() MetricsSample {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.sample
+}
+
+// metricsBody invokes done exactly once, when the body reaches EOF or is closed, whichever comes first.
+type metricsBody struct {
+	io.ReadCloser
+	once sync.Once
+	done func()
+}
+
+func (b *metricsBody) Read(p []byte) (n int, err error) {
+	n, err = b.ReadCloser.Read(p)
+	if err == io.EOF { //nolint:errorlint // the intention is to compare for io.EOF
+		b.once.Do(b.done)
+	}
+
+	return n, err
+}
+
+func (b *metricsBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.once.Do(b.done)
+
+	return err
+}
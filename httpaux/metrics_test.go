@@ -0,0 +1,148 @@
+package httpaux
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httptrace"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/angrifel/unapologetic/internal/assert"
+)
+
+func TestTransportWithMetrics(t *testing.T) {
+	t.Run("reports timings and connection reuse per host, method and status class", func(t *testing.T) {
+		// arrange
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path == "/missing" {
+				http.NotFound(w, r)
+
+				return
+			}
+
+			_, _ = io.WriteString(w, "hello")
+		}))
+		defer server.Close()
+
+		sink := &InMemoryMetrics{}
+		client := &http.Client{Transport: TransportWithMetrics(server.Client().Transport, sink)}
+		host := server.Listener.Addr().String()
+
+		// act
+		for _, path := range []string{"/", "/", "/missing"} {
+			resp, err := client.Get(server.URL + path)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			_, _ = io.ReadAll(resp.Body)
+			_ = resp.Body.Close()
+		}
+
+		// assert
+		snapshot := sink.Snapshot()
+		ok := snapshot[MetricsKey{Host: host, Method: http.MethodGet, StatusClass: "2xx"}]
+		notFound := snapshot[MetricsKey{Host: host, Method: http.MethodGet, StatusClass: "4xx"}]
+
+		assert.Equal(t, 2, len(snapshot))
+		assert.Equal(t, 2, ok.Count)
+		assert.Equal(t, 1, ok.ConnReused)
+		assert.Greater(t, ok.Connect, 0)
+		assert.Greater(t, ok.TimeToFirstByte, 0)
+		assert.Equal(t, 1, notFound.Count)
+		assert.Equal(t, 1, notFound.ConnReused)
+	})
+
+	t.Run("reports body read duration once the body is closed", func(t *testing.T) {
+		// arrange
+		sink := &InMemoryMetrics{}
+		rt := TransportWithMetrics(RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(strings.NewReader("hello"))}, nil
+		}), sink)
+		req := httptest.NewRequest(http.MethodPost, "http://example.com/", nil)
+
+		// act
+		resp, err := rt.RoundTrip(req)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		beforeClose := len(sink.Snapshot())
+
+		time.Sleep(time.Millisecond)
+		_ = resp.Body.Close()
+		_ = resp.Body.Close()
+
+		// assert
+		agg := sink.Snapshot()[MetricsKey{Host: "example.com", Method: http.MethodPost, StatusClass: "2xx"}]
+
+		assert.Equal(t, 0, beforeClose)
+		assert.Equal(t, 1, agg.Count)
+		assert.GreaterOrEqual(t, agg.BodyRead, time.Millisecond)
+	})
+
+	t.Run("reports failed round trips immediately", func(t *testing.T) {
+		// arrange
+		sink := &InMemoryMetrics{}
+		expectedErr := errors.New("dial error")
+		rt := TransportWithMetrics(RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			return nil, expectedErr
+		}), sink)
+		req := &http.Request{Method: http.MethodGet, URL: &url.URL{Scheme: "http", Host: "example.com"}, Header: http.Header{}}
+
+		// act
+		resp, err := rt.RoundTrip(req)
+
+		// assert
+		assert.Equal(t, nil, resp)
+		assert.Equal(t, expectedErr, err)
+		assert.Equal(t, 1, sink.Snapshot()[MetricsKey{Host: "example.com", Method: http.MethodGet, StatusClass: StatusClassError}].Count)
+	})
+
+	t.Run("reports responses without a body immediately", func(t *testing.T) {
+		// arrange
+		sink := &InMemoryMetrics{}
+		rt := TransportWithMetrics(RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			return &http.Response{StatusCode: http.StatusNoContent}, nil
+		}), sink)
+
+		// act
+		resp, err := rt.RoundTrip(httptest.NewRequest(http.MethodGet, "http://example.com/", nil))
+
+		// assert
+		aggregate := sink.Snapshot()[MetricsKey{Host: "example.com", Method: http.MethodGet, StatusClass: "2xx"}]
+
+		assert.Equal(t, nil, err)
+		assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+		assert.Equal(t, 1, aggregate.Count)
+		assert.Equal(t, time.Duration(0), aggregate.BodyRead)
+	})
+
+	t.Run("measures the first successful of racing dials", func(t *testing.T) {
+		// arrange
+		sink := &InMemoryMetrics{}
+		rt := TransportWithMetrics(RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			trace := httptrace.ContextClientTrace(req.Context())
+			trace.ConnectStart("tcp", "[::1]:80")
+			time.Sleep(50 * time.Millisecond)
+			trace.ConnectStart("tcp", "127.0.0.1:80")
+			trace.ConnectDone("tcp", "127.0.0.1:80", nil)
+			trace.ConnectDone("tcp", "[::1]:80", errors.New("canceled"))
+
+			return &http.Response{StatusCode: http.StatusOK}, nil
+		}), sink)
+
+		// act
+		_, err := rt.RoundTrip(httptest.NewRequest(http.MethodGet, "http://example.com/", nil))
+
+		// assert
+		aggregate := sink.Snapshot()[MetricsKey{Host: "example.com", Method: http.MethodGet, StatusClass: "2xx"}]
+
+		assert.Equal(t, nil, err)
+		assert.Less(t, aggregate.Connect, 50*time.Millisecond)
+	})
+}
@@ -0,0 +1,189 @@
+package httpaux
+
+import (
+	"context"
+	"iter"
+	"net/http"
+	"slices"
+)
+
+// NextLink returns the URL of the rel="next" link of the Link header of resp, resolved against the URL of its
+// request, or false if there is none.
+func NextLink(resp *http.Response) (string, bool) {
+	for _, link := range ParseLinkHeader(resp.Header) {
+		if !link.HasRel("next") {
+			continue
+		}
+
+		if resp.Request == nil || resp.Request.URL == nil {
+			return link.URL, true
+		}
+
+		next, err := resp.Request.URL.Parse(link.URL)
+		if err != nil {
+			return "", false
+		}
+
+		return next.String(), true
+	}
+
+	return "", false
+}
+
+// QueryCursor returns a PaginateJSONConfig.Next function that requests the next page by setting the query
+// parameter param of the current URL to the cursor extracted from the page. An empty cursor ends the pagination.
+func QueryCursor[Page any](param string, cursor func(page Page) string) func(*http.Response, Page) (string, bool) {
+	return func(resp *http.Response, page Page) (string, bool) {
+		value := cursor(page)
+		if value == "" || resp.Request == nil {
+			return "", false
+		}
+
+		next := *resp.Request.URL
+		query := next.Query()
+		query.Set(param, value)
+		next.RawQuery = query.Encode()
+
+		return next.String(), true
+	}
+}
+
+// PaginateConfig configures Paginate.
+type PaginateConfig struct {
+	// Header is sent with every request.
+	Header http.Header
+	// MaxPages, if positive, is the maximum number of pages requested.
+	MaxPages int
+	// Next returns the URL of the page following resp, or false if resp is the last page. It must not read the body.
+	// Defaults to NextLink.
+	Next func(resp *http.Response) (string, bool)
+}
+
+// Paginate returns an iterator over the pages of a paginated resource, starting at url, requested with GET through
+// client, a nil client using http.DefaultClient.
+//
+// Each page is yielded as a response whose body is closed once the consumer is done with it, before the next page
+// is requested. A page with a status code other than 2xx ends the iteration with a *ResponseError, as does a done
+// ctx with its error.
+func Paginate(ctx context.Context, client *http.Client, url string, config PaginateConfig) iter.Seq2[*http.Response, error] {
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	if config.Next == nil {
+		config.Next = NextLink
+	}
+
+	return func(yield func(*http.Response, error) bool) {
+		for page := 1; ; page++ {
+			resp, err := getPage(ctx, client, url, config.Header)
+			if err != nil {
+				yield(nil, err)
+
+				return
+			}
+
+			next, more := config.Next(resp)
+			proceed := yield(resp, nil)
+
+			discardBody(resp.Body)
+
+			if !proceed || !more || (config.MaxPages > 0 && page >= config.MaxPages) {
+				return
+			}
+
+			url = next
+		}
+	}
+}
+
+func getPage(ctx context.Context, client *http.Client, url string, header http.Header) (*http.Response, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	for name, values := range header {
+		req.Header[http.CanonicalHeaderKey(name)] = slices.Clone(values)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if !isExpectedStatus(resp.StatusCode, nil) {
+		resp = BufferResponseBody(resp)
+
+		return nil, &ResponseError{
+			Response: resp,
+			Err:      &UnexpectedStatusError{StatusCode: resp.StatusCode, Status: resp.Status},
+		}
+	}
+
+	return resp, nil
+}
+
+// PaginateJSONConfig configures PaginateJSON.
+type PaginateJSONConfig[Page, Item any] struct {
+	// JSON configures the request and the decoding of every page.
+	JSON JSONConfig
+	// MaxPages, if positive, is the maximum number of pages requested.
+	MaxPages int
+	// Items returns the items of a page. It is required.
+	Items func(page Page) []Item
+	// Next returns the URL of the page following page, or false if it is the last one. Defaults to the NextLink of
+	// resp. QueryCursor builds Next functions for cursors carried in the page.
+	Next func(resp *http.Response, page Page) (string, bool)
+}
+
+// PaginateJSON returns an iterator over the items of a paginated JSON resource, starting at url, requested with GET
+// through client, a nil client using http.DefaultClient. Every page is requested and decoded as GetJSON does, once
+// the items of the previous page are consumed. Errors, including those of a done ctx, end the iteration.
+func PaginateJSON[Page, Item any](
+	ctx context.Context, client *http.Client, url string, config PaginateJSONConfig[Page, Item],
+) iter.Seq2[Item, error] {
+	if config.Items == nil {
+		panic("config.Items must not be nil")
+	}
+
+	if config.Next == nil {
+		config.Next = func(resp *http.Response, _ Page) (string, bool) { return NextLink(resp) }
+	}
+
+	return func(yield func(Item, error) bool) {
+		var zero Item
+
+		for pageNumber := 1; ; pageNumber++ {
+			if err := ctx.Err(); err != nil {
+				yield(zero, err)
+
+				return
+			}
+
+			page, resp, err := doJSON[Page](ctx, client, http.MethodGet, url, nil, config.JSON)
+			if err != nil {
+				yield(zero, err)
+
+				return
+			}
+
+			for _, item := range config.Items(page) {
+				if !yield(item, nil) {
+					return
+				}
+			}
+
+			next, more := config.Next(resp, page)
+			if !more || (config.MaxPages > 0 && pageNumber >= config.MaxPages) {
+				return
+			}
+
+			url = next
+		}
+	}
+}
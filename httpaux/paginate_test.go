@@ -0,0 +1,235 @@
+package httpaux
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"sync/atomic"
+	"testing"
+
+	"github.com/angrifel/unapologetic/internal/assert"
+)
+
+type paginatePage struct {
+	Items  []int  `json:"items"`
+	Cursor string `json:"cursor,omitempty"`
+}
+
+func newPaginateServer(t *testing.T, pages int, requests *atomic.Int32) *httptest.Server {
+	t.Helper()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+
+		page, _ := strconv.Atoi(r.URL.Query().Get("page"))
+		if page == 0 {
+			page = 1
+		}
+
+		if r.URL.Path == "/broken" && page == 2 {
+			w.WriteHeader(http.StatusInternalServerError)
+
+			return
+		}
+
+		cursor := ""
+		if page < pages {
+			cursor = strconv.Itoa(page + 1)
+			w.Header().Set("Link", fmt.Sprintf(`<%s?page=%d>; rel="next"`, r.URL.Path, page+1))
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(paginatePage{Items: []int{page*10 + 1, page*10 + 2}, Cursor: cursor})
+	}))
+	t.Cleanup(server.Close)
+
+	return server
+}
+
+func TestPaginate(t *testing.T) {
+	t.Run("follows next links", func(t *testing.T) {
+		// arrange
+		var requests atomic.Int32
+
+		server := newPaginateServer(t, 3, &requests)
+
+		var bodies []*http.Response
+
+		// act
+		for resp, err := range Paginate(context.Background(), nil, server.URL+"/items", PaginateConfig{}) {
+			assert.Equal(t, nil, err)
+
+			bodies = append(bodies, resp)
+		}
+
+		// assert
+		assert.Equal(t, 3, len(bodies))
+		assert.Equal(t, "3", bodies[2].Request.URL.Query().Get("page"))
+
+		_, err := bodies[0].Body.Read(make([]byte, 1))
+		assert.Equal(t, false, err == nil)
+	})
+
+	t.Run("honors the page limit", func(t *testing.T) {
+		// arrange
+		var requests atomic.Int32
+
+		server := newPaginateServer(t, 5, &requests)
+
+		// act
+		for range Paginate(context.Background(), nil, server.URL+"/items", PaginateConfig{MaxPages: 2}) {
+		}
+
+		// assert
+		assert.Equal(t, int32(2), requests.Load())
+	})
+
+	t.Run("stops requesting when the consumer stops", func(t *testing.T) {
+		// arrange
+		var requests atomic.Int32
+
+		server := newPaginateServer(t, 5, &requests)
+
+		// act
+		for range Paginate(context.Background(), nil, server.URL+"/items", PaginateConfig{}) {
+			break
+		}
+
+		// assert
+		assert.Equal(t, int32(1), requests.Load())
+	})
+
+	t.Run("ends with a response error on unexpected statuses", func(t *testing.T) {
+		// arrange
+		var requests atomic.Int32
+
+		server := newPaginateServer(t, 5, &requests)
+
+		var last error
+
+		// act
+		for _, err := range Paginate(context.Background(), nil, server.URL+"/broken", PaginateConfig{}) {
+			last = err
+		}
+
+		// assert
+		var respErr *ResponseError
+
+		assert.Equal(t, true, errors.As(last, &respErr))
+		assert.Equal(t, true, errors.Is(last, ErrUnexpectedStatus))
+		assert.Equal(t, http.StatusInternalServerError, respErr.Response.StatusCode)
+	})
+
+	t.Run("ends when the context is done", func(t *testing.T) {
+		// arrange
+		var requests atomic.Int32
+
+		server := newPaginateServer(t, 5, &requests)
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		var last error
+
+		// act
+		for resp, err := range Paginate(ctx, nil, server.URL+"/items", PaginateConfig{}) {
+			if resp != nil {
+				_, _ = io.Copy(io.Discard, resp.Body)
+				cancel()
+			}
+
+			last = err
+		}
+
+		// assert
+		assert.Equal(t, true, errors.Is(last, context.Canceled))
+		assert.Equal(t, int32(1), requests.Load())
+	})
+}
+
+func TestPaginateJSON(t *testing.T) {
+	items := func(page paginatePage) []int { return page.Items }
+
+	collect := func(seq func(func(int, error) bool)) ([]int, error) {
+		var result []int
+
+		for item, err := range seq {
+			if err != nil {
+				return result, err
+			}
+
+			result = append(result, item)
+		}
+
+		return result, nil
+	}
+
+	t.Run("yields the items of every linked page", func(t *testing.T) {
+		// arrange
+		var requests atomic.Int32
+
+		server := newPaginateServer(t, 3, &requests)
+
+		// act
+		result, err := collect(PaginateJSON(context.Background(), nil, server.URL+"/items",
+			PaginateJSONConfig[paginatePage, int]{Items: items}))
+
+		// assert
+		assert.Equal(t, nil, err)
+		assert.Equal(t, true, slices.Equal([]int{11, 12, 21, 22, 31, 32}, result))
+	})
+
+	t.Run("follows cursors", func(t *testing.T) {
+		// arrange
+		var requests atomic.Int32
+
+		server := newPaginateServer(t, 2, &requests)
+
+		// act
+		result, err := collect(PaginateJSON(context.Background(), nil, server.URL+"/items?size=2",
+			PaginateJSONConfig[paginatePage, int]{
+				Items: items,
+				Next:  QueryCursor("page", func(page paginatePage) string { return page.Cursor }),
+			}))
+
+		// assert
+		assert.Equal(t, nil, err)
+		assert.Equal(t, true, slices.Equal([]int{11, 12, 21, 22}, result))
+	})
+
+	t.Run("does not request the next page when stopped", func(t *testing.T) {
+		// arrange
+		var requests atomic.Int32
+
+		server := newPaginateServer(t, 3, &requests)
+
+		// act
+		for range PaginateJSON(context.Background(), nil, server.URL+"/items",
+			PaginateJSONConfig[paginatePage, int]{Items: items}) {
+			break
+		}
+
+		// assert
+		assert.Equal(t, int32(1), requests.Load())
+	})
+
+	t.Run("reports page errors", func(t *testing.T) {
+		// arrange
+		var requests atomic.Int32
+
+		server := newPaginateServer(t, 3, &requests)
+
+		// act
+		result, err := collect(PaginateJSON(context.Background(), nil, server.URL+"/broken",
+			PaginateJSONConfig[paginatePage, int]{Items: items, MaxPages: 5}))
+
+		// assert
+		assert.Equal(t, 2, len(result))
+		assert.Equal(t, true, errors.Is(err, ErrUnexpectedStatus))
+	})
+}
@@ -0,0 +1,465 @@
+package httpaux
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultRangeMaxRetries = 3
+	defaultRangeRetryDelay = 500 * time.Millisecond
+)
+
+var (
+	// ErrRangeIgnored is matched by RangeIgnoredError when inspected with errors.Is.
+	ErrRangeIgnored = errors.New("httpaux: server ignored range request")
+	// ErrResourceChanged is returned when the validator of a remote resource changed while it was being read.
+	ErrResourceChanged = errors.New("httpaux: remote resource changed")
+
+	errInvalidOffset = errors.New("httpaux: invalid offset")
+)
+
+// RangeIgnoredError is returned when a server answers a Range request with the whole resource, or with a range other
+// than the requested one. It matches ErrRangeIgnored when inspected with errors.Is.
+type RangeIgnoredError struct {
+	// Offset is the offset the request asked for.
+	Offset int64
+	// StatusCode is the status code of the response.
+	StatusCode int
+}
+
+func (e *RangeIgnoredError) Error() string {
+	return "httpaux: server ignored range request at offset " + strconv.FormatInt(e.Offset, 10) +
+		" (status " + strconv.Itoa(e.StatusCode) + ")"
+}
+
+// Is reports whether target is ErrRangeIgnored.
+func (e *RangeIgnoredError) Is(target error) bool {
+	return target == ErrRangeIgnored //nolint:errorlint // sentinel identity is the intention
+}
+
+// RangeReaderConfig configures NewRangeReader.
+type RangeReaderConfig struct {
+	// Header is sent with every request.
+	Header http.Header
+	// MaxRetries is how many consecutive transient failures are retried before giving up. Defaults to 3; a negative
+	// value disables retries.
+	MaxRetries int
+	// RetryDelay is the delay before the first retry, doubled on every consecutive failure. Defaults to 500ms.
+	RetryDelay time.Duration
+}
+
+var (
+	_ io.ReadSeekCloser = (*RangeReader)(nil)
+	_ io.ReaderAt       = (*RangeReader)(nil)
+)
+
+// RangeReader exposes a remote resource as an io.ReadSeekCloser and an io.ReaderAt, fetching it with Range requests.
+//
+// Reads stream the resource from the current offset and, after transient errors, resume transparently from the
+// last offset read. Every request but the first one carries an If-Range validator, so content that changed in the
+// meantime is reported as ErrResourceChanged instead of being mixed with the old one. A server that does not honor
+// ranges is reported with a RangeIgnoredError.
+//
+// Read, Seek and Close must not be called concurrently; ReadAt is safe for concurrent use.
+type RangeReader struct {
+	ctx    context.Context //nolint:containedctx // the reader issues requests on behalf of its creator
+	rt     http.RoundTripper
+	url    string
+	config RangeReaderConfig
+
+	size      int64
+	validator string
+	etag      string
+
+	offset     int64
+	body       io.ReadCloser
+	bodyOffset int64
+	closed     bool
+}
+
+// NewRangeReader opens the resource at url, requesting it from its start through rt, a nil rt using
+// http.DefaultTransport. ctx governs every request issued by the reader.
+func NewRangeReader(ctx context.Context, rt http.RoundTripper, url string, config RangeReaderConfig) (*RangeReader, error) {
+	if config.MaxRetries == 0 {
+		config.MaxRetries = defaultRangeMaxRetries
+	}
+
+	if config.RetryDelay == 0 {
+		config.RetryDelay = defaultRangeRetryDelay
+	}
+
+	r := &RangeReader{
+		ctx:        ctx,
+		rt:         transportOrDefault(rt),
+		url:        url,
+		config:     config,
+		size:       -1,
+		validator:  "",
+		etag:       "",
+		offset:     0,
+		body:       nil,
+		bodyOffset: 0,
+		closed:     false,
+	}
+
+	var err error
+
+	r.body, err = r.retry(func() (io.ReadCloser, error) { return r.open(0, -1, true) })
+	if err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+// Size returns the size of the resource, or -1 if the server did not report it.
+func (r *RangeReader) Size() int64 {
+	return r.size
+}
+
+// Read reads from the current offset, resuming the download after transient errors.
+func (r *RangeReader) Read(p []byte) (int, error) {
+	if r.closed {
+		return 0, fs.ErrClosed
+	}
+
+	if r.atEnd(r.offset) {
+		return 0, io.EOF
+	}
+
+	if r.body != nil && r.bodyOffset != r.offset {
+		r.closeBody()
+	}
+
+	failures := 0
+
+	for {
+		if r.body == nil {
+			body, err := r.retry(func() (io.ReadCloser, error) { return r.open(r.offset, -1, false) })
+			if err != nil {
+				return 0, err
+			}
+
+			r.body, r.bodyOffset = body, r.offset
+		}
+
+		n, err := r.body.Read(p)
+		r.offset += int64(n)
+		r.bodyOffset = r.offset
+
+		switch {
+		case err == nil:
+			return n, nil
+		case errors.Is(err, io.EOF) && (r.size < 0 || r.atEnd(r.offset)):
+			r.closeBody()
+
+			return n, io.EOF
+		}
+
+		// the body failed or ended early: resume from the current offset.
+		r.closeBody()
+
+		if n > 0 {
+			return n, nil
+		}
+
+		if errors.Is(err, io.EOF) {
+			err = io.ErrUnexpectedEOF
+		}
+
+		failures++
+		if failures > r.config.MaxRetries {
+			return 0, fmt.Errorf("httpaux: reading range at offset %d: %w", r.offset, err)
+		}
+
+		if err := r.backoff(failures); err != nil {
+			return 0, err
+		}
+	}
+}
+
+// Seek sets the offset of the next Read. Seeking relative to the end requires a known size.
+func (r *RangeReader) Seek(offset int64, whence int) (int64, error) {
+	if r.closed {
+		return 0, fs.ErrClosed
+	}
+
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += r.offset
+	case io.SeekEnd:
+		if r.size < 0 {
+			return 0, fmt.Errorf("%w: seeking from the end of a resource of unknown size", errInvalidOffset)
+		}
+
+		offset += r.size
+	default:
+		return 0, fmt.Errorf("%w: invalid whence %d", errInvalidOffset, whence)
+	}
+
+	if offset < 0 {
+		return 0, fmt.Errorf("%w: negative position", errInvalidOffset)
+	}
+
+	r.offset = offset
+
+	return offset, nil
+}
+
+// ReadAt reads len(p) bytes at off with a dedicated Range request, retrying transient errors.
+func (r *RangeReader) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, fmt.Errorf("%w: negative offset", errInvalidOffset)
+	}
+
+	if len(p) == 0 {
+		return 0, nil
+	}
+
+	if r.atEnd(off) {
+		return 0, io.EOF
+	}
+
+	read := 0
+	failures := 0
+
+	for read < len(p) {
+		start := off + int64(read)
+
+		body, err := r.retry(func() (io.ReadCloser, error) { return r.open(start, off+int64(len(p))-1, false) })
+		if err != nil {
+			return read, err
+		}
+
+		n, err := io.ReadFull(body, p[read:])
+		_ = body.Close()
+		read += n
+
+		switch {
+		case err == nil:
+			continue
+		case (errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF)) && (r.size < 0 || r.atEnd(off+int64(read))):
+			return read, io.EOF
+		case n > 0:
+			failures = 0
+
+			continue
+		}
+
+		failures++
+		if failures > r.config.MaxRetries {
+			return read, fmt.Errorf("httpaux: reading range at offset %d: %w", start, err)
+		}
+
+		if err := r.backoff(failures); err != nil {
+			return read, err
+		}
+	}
+
+	return read, nil
+}
+
+// Close closes the current response body, if any.
+func (r *RangeReader) Close() error {
+	if r.closed {
+		return fs.ErrClosed
+	}
+
+	r.closed = true
+	r.closeBody()
+
+	return nil
+}
+
+func (r *RangeReader) atEnd(offset int64) bool {
+	return r.size >= 0 && offset >= r.size
+}
+
+func (r *RangeReader) closeBody() {
+	if r.body != nil {
+		_ = r.body.Close()
+		r.body = nil
+	}
+}
+
+// retry calls open, retrying it with backoff while it fails with a transient error.
+func (r *RangeReader) retry(open func() (io.ReadCloser, error)) (io.ReadCloser, error) {
+	for failures := 1; ; failures++ {
+		body, err := open()
+		if err == nil || !isTransientRangeError(err) || failures > r.config.MaxRetries {
+			return body, err
+		}
+
+		if err := r.backoff(failures); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (r *RangeReader) backoff(failures int) error {
+	return sleepContext(r.ctx, r.config.RetryDelay<<(failures-1))
+}
+
+// open requests the range [start, end] of the resource, end being -1 for the rest of it. The first request records
+// the size and validator of the resource instead of checking them.
+func (r *RangeReader) open(start, end int64, first bool) (io.ReadCloser, error) {
+	req, err := http.NewRequestWithContext(r.ctx, http.MethodGet, r.url, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	for name, values := range r.config.Header {
+		req.Header[name] = append([]string(nil), values...)
+	}
+
+	req.Header.Set("Range", formatRange(start, end))
+
+	if !first && r.validator != "" {
+		req.Header.Set("If-Range", r.validator)
+	}
+
+	resp, err := r.rt.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+		rangeStart, _, size, ok := parseContentRange(resp.Header.Get("Content-Range"))
+		if !ok || rangeStart != start {
+			discardBody(resp.Body)
+
+			return nil, &RangeIgnoredError{Offset: start, StatusCode: resp.StatusCode}
+		}
+
+		if first {
+			r.record(resp.Header, size)
+		} else if etag := resp.Header.Get("ETag"); r.etag != "" && etag != "" && etag != r.etag {
+			discardBody(resp.Body)
+
+			return nil, ErrResourceChanged
+		}
+
+		return resp.Body, nil
+	case http.StatusOK:
+		discardBody(resp.Body)
+
+		if first && resp.ContentLength == 0 {
+			// servers do not answer ranges of empty resources with 206.
+			r.record(resp.Header, 0)
+
+			return http.NoBody, nil
+		}
+
+		if !first && r.validator != "" && rangeValidator(resp.Header) != r.validator {
+			return nil, ErrResourceChanged
+		}
+
+		return nil, &RangeIgnoredError{Offset: start, StatusCode: resp.StatusCode}
+	case http.StatusRequestedRangeNotSatisfiable:
+		discardBody(resp.Body)
+
+		if _, _, size, ok := parseContentRange(resp.Header.Get("Content-Range")); ok && first {
+			r.size = size
+		}
+
+		return http.NoBody, nil
+	default:
+		discardBody(resp.Body)
+
+		return nil, &UnexpectedStatusError{StatusCode: resp.StatusCode, Status: resp.Status}
+	}
+}
+
+// record keeps the size and validators of the resource from the response to the first request.
+func (r *RangeReader) record(header http.Header, size int64) {
+	r.size = size
+	r.etag = header.Get("ETag")
+	r.validator = rangeValidator(header)
+}
+
+// isTransientRangeError reports whether a failed range request is worth retrying.
+func isTransientRangeError(err error) bool {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) ||
+		errors.Is(err, ErrRangeIgnored) || errors.Is(err, ErrResourceChanged) {
+		return false
+	}
+
+	var statusErr *UnexpectedStatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.StatusCode >= http.StatusInternalServerError ||
+			statusErr.StatusCode == http.StatusRequestTimeout || statusErr.StatusCode == http.StatusTooManyRequests
+	}
+
+	return true
+}
+
+// rangeValidator returns the If-Range validator of a response: its ETag, if strong, or else its Last-Modified date.
+func rangeValidator(header http.Header) string {
+	if etag := header.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+		return etag
+	}
+
+	return header.Get("Last-Modified")
+}
+
+func formatRange(start, end int64) string {
+	if end < 0 {
+		return "bytes=" + strconv.FormatInt(start, 10) + "-"
+	}
+
+	return "bytes=" + strconv.FormatInt(start, 10) + "-" + strconv.FormatInt(end, 10)
+}
+
+// parseContentRange parses a Content-Range header value, "bytes <start>-<end>/<size>" or "bytes */<size>", into its
+// parts. Unknown parts are -1.
+func parseContentRange(value string) (start, end, size int64, ok bool) {
+	rest, ok := strings.CutPrefix(value, "bytes ")
+	if !ok {
+		return -1, -1, -1, false
+	}
+
+	span, total, ok := strings.Cut(rest, "/")
+	if !ok {
+		return -1, -1, -1, false
+	}
+
+	size = -1
+
+	if total != "*" {
+		var err error
+		if size, err = strconv.ParseInt(total, 10, 64); err != nil {
+			return -1, -1, -1, false
+		}
+	}
+
+	if span == "*" {
+		return -1, -1, size, size >= 0
+	}
+
+	first, last, ok := strings.Cut(span, "-")
+	if !ok {
+		return -1, -1, -1, false
+	}
+
+	start, err := strconv.ParseInt(first, 10, 64)
+	if err != nil {
+		return -1, -1, -1, false
+	}
+
+	end, err = strconv.ParseInt(last, 10, 64)
+	if err != nil || end < start {
+		return -1, -1, -1, false
+	}
+
+	return start, end, size, true
+}
@@ -0,0 +1,229 @@
+package httpaux
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/angrifel/unapologetic/internal/assert"
+	"github.com/angrifel/unapologetic/iospy"
+)
+
+func TestRangeReader(t *testing.T) {
+	content := bytes.Repeat([]byte("0123456789abcdef"), 64)
+	errBroken := errors.New("connection reset")
+	config := RangeReaderConfig{RetryDelay: time.Millisecond}
+
+	var (
+		mu   sync.Mutex
+		etag = `"v1"`
+	)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		w.Header().Set("ETag", etag)
+		mu.Unlock()
+		http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(content))
+	}))
+	defer server.Close()
+
+	// breaking returns a transport whose responses, while broken returns true, fail after 100 bytes of body.
+	breaking := func(broken func() bool) (http.RoundTripper, *[]*http.Request) {
+		var sent []*http.Request
+
+		return RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			sent = append(sent, req)
+
+			resp, err := http.DefaultTransport.RoundTrip(req)
+			if err == nil && broken() {
+				resp.Body = struct {
+					io.Reader
+					io.Closer
+				}{Reader: iospy.LimitReaderWithError(resp.Body, 100, errBroken), Closer: resp.Body}
+			}
+
+			return resp, err
+		}), &sent
+	}
+
+	t.Run("reads the whole resource", func(t *testing.T) {
+		// arrange
+		r, err := NewRangeReader(context.Background(), nil, server.URL, config)
+		assert.Equal(t, nil, err)
+
+		// act
+		data, err := io.ReadAll(r)
+
+		// assert
+		assert.Equal(t, nil, err)
+		assert.Equal(t, int64(len(content)), r.Size())
+		assert.Equal(t, string(content), string(data))
+		assert.Equal(t, nil, r.Close())
+	})
+
+	t.Run("resumes after transient errors", func(t *testing.T) {
+		// arrange
+		calls := 0
+		rt, sent := breaking(func() bool { calls++; return calls <= 2 })
+		r, _ := NewRangeReader(context.Background(), rt, server.URL, config)
+
+		// act
+		data, err := io.ReadAll(r)
+
+		// assert
+		assert.Equal(t, nil, err)
+		assert.Equal(t, string(content), string(data))
+		assert.Equal(t, 3, len(*sent))
+		assert.Equal(t, "bytes=0-", (*sent)[0].Header.Get("Range"))
+		assert.Equal(t, "", (*sent)[0].Header.Get("If-Range"))
+		assert.Equal(t, "bytes=100-", (*sent)[1].Header.Get("Range"))
+		assert.Equal(t, `"v1"`, (*sent)[1].Header.Get("If-Range"))
+		assert.Equal(t, "bytes=200-", (*sent)[2].Header.Get("Range"))
+	})
+
+	t.Run("gives up after too many failures", func(t *testing.T) {
+		// arrange
+		rt, _ := breaking(func() bool { return true })
+		r, _ := NewRangeReader(context.Background(), RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			resp, err := rt.RoundTrip(req)
+			if err == nil && req.Header.Get("Range") != "bytes=0-" {
+				resp.Body = io.NopCloser(iospy.LimitReaderWithError(resp.Body, 0, errBroken))
+			}
+
+			return resp, err
+		}), server.URL, RangeReaderConfig{MaxRetries: 2, RetryDelay: time.Millisecond})
+
+		// act
+		_, err := io.ReadAll(r)
+
+		// assert
+		assert.Equal(t, true, errors.Is(err, errBroken))
+	})
+
+	t.Run("seeks", func(t *testing.T) {
+		// arrange
+		r, _ := NewRangeReader(context.Background(), nil, server.URL, config)
+		buf := make([]byte, 6)
+
+		// act
+		position, seekErr := r.Seek(-6, io.SeekEnd)
+		_, readErr := io.ReadFull(r, buf)
+		_, eofErr := r.Read(buf)
+
+		// assert
+		assert.Equal(t, nil, seekErr)
+		assert.Equal(t, nil, readErr)
+		assert.Equal(t, int64(len(content)-6), position)
+		assert.Equal(t, "abcdef", string(buf))
+		assert.Equal(t, io.EOF, eofErr)
+	})
+
+	t.Run("reads at offsets", func(t *testing.T) {
+		// arrange
+		r, _ := NewRangeReader(context.Background(), nil, server.URL, config)
+		middle := make([]byte, 8)
+		tail := make([]byte, 8)
+
+		// act
+		n, err := r.ReadAt(middle, 20)
+		tailN, tailErr := r.ReadAt(tail, int64(len(content)-4))
+
+		// assert
+		assert.Equal(t, nil, err)
+		assert.Equal(t, 8, n)
+		assert.Equal(t, "456789ab", string(middle))
+		assert.Equal(t, io.EOF, tailErr)
+		assert.Equal(t, 4, tailN)
+		assert.Equal(t, "cdef", string(tail[:tailN]))
+	})
+
+	t.Run("reports changed content", func(t *testing.T) {
+		// arrange
+		rt, _ := breaking(func() bool { return true })
+		r, _ := NewRangeReader(context.Background(), rt, server.URL, config)
+
+		mu.Lock()
+		etag = `"v2"`
+		mu.Unlock()
+
+		defer func() {
+			mu.Lock()
+			etag = `"v1"`
+			mu.Unlock()
+		}()
+
+		// act
+		_, err := io.ReadAll(r)
+
+		// assert
+		assert.Equal(t, true, errors.Is(err, ErrResourceChanged))
+	})
+
+	t.Run("reports ignored ranges", func(t *testing.T) {
+		// arrange
+		ignoring := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			_, _ = w.Write(content)
+		}))
+		defer ignoring.Close()
+
+		// act
+		_, err := NewRangeReader(context.Background(), nil, ignoring.URL, config)
+
+		// assert
+		var ignored *RangeIgnoredError
+
+		assert.Equal(t, true, errors.As(err, &ignored))
+		assert.Equal(t, http.StatusOK, ignored.StatusCode)
+		assert.Equal(t, true, errors.Is(err, ErrRangeIgnored))
+	})
+
+	t.Run("reads an empty resource", func(t *testing.T) {
+		// arrange
+		empty := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(nil))
+		}))
+		defer empty.Close()
+
+		r, err := NewRangeReader(context.Background(), nil, empty.URL, config)
+		assert.Equal(t, nil, err)
+
+		// act
+		data, err := io.ReadAll(r)
+
+		// assert
+		assert.Equal(t, nil, err)
+		assert.Equal(t, 0, len(data))
+		assert.Equal(t, int64(0), r.Size())
+	})
+}
+
+func TestParseContentRange(t *testing.T) {
+	for _, test := range []struct {
+		value            string
+		start, end, size int64
+		ok               bool
+	}{
+		{value: "bytes 0-9/100", start: 0, end: 9, size: 100, ok: true},
+		{value: "bytes 10-19/*", start: 10, end: 19, size: -1, ok: true},
+		{value: "bytes */100", start: -1, end: -1, size: 100, ok: true},
+		{value: "bytes 9-0/100", start: -1, end: -1, size: -1, ok: false},
+		{value: "items 0-9/100", start: -1, end: -1, size: -1, ok: false},
+	} {
+		t.Run(test.value, func(t *testing.T) {
+			// act
+			start, end, size, ok := parseContentRange(test.value)
+
+			// assert
+			assert.Equal(t, test.start, start)
+			assert.Equal(t, test.end, end)
+			assert.Equal(t, test.size, size)
+			assert.Equal(t, test.ok, ok)
+		})
+	}
+}
@@ -0,0 +1,300 @@
+package httpaux
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const rateLimitPruneEntries = 1024
+
+// RateLimitConfig configures TransportWithRateLimit.
+type RateLimitConfig struct {
+	// Key maps a request to the limiter that applies to it. Defaults to the request URL host.
+	Key func(req *http.Request) string
+	// Rate is the number of requests per second allowed per key. Zero disables rate limiting.
+	Rate float64
+	// Burst is the size of the token bucket. Defaults to Rate rounded up, and at least 1.
+	Burst int
+	// MaxInFlight is the maximum number of concurrent requests per key. Zero disables concurrency limiting.
+	MaxInFlight int
+	// AdaptToHeaders makes the limiter pause a key when a 429 Too Many Requests or 503 Service Unavailable response
+	// carries Retry-After, or when any response carries RateLimit-Remaining: 0 together with RateLimit-Reset.
+	AdaptToHeaders bool
+}
+
+var _ http.RoundTripper = (*rateLimitTransport)(nil)
+
+type rateLimitTransport struct {
+	next   http.RoundTripper
+	config RateLimitConfig
+
+	mu        sync.Mutex
+	limiters  map[string]*keyLimiter
+	pruneSize int
+}
+
+// TransportWithRateLimit wraps next with an http.RoundTripper that limits, per key, the rate at which requests are
+// sent using a token bucket and the number of requests in flight using a semaphore.
+//
+// Requests wait for a token and a slot while honoring their context; if the context is done first, RoundTrip returns
+// its error without sending the request. A concurrency slot is held until the response body is closed, not merely
+// until RoundTrip returns. The limiters of keys that are idle, with no request in flight and a full bucket, are
+// evicted over time, so that keys with many values, e.g. per tenant, do not grow the transport without bound.
+// A nil next uses http.DefaultTransport.
+func TransportWithRateLimit(next http.RoundTripper, config RateLimitConfig) http.RoundTripper {
+	if config.Key == nil {
+		config.Key = func(req *http.Request) string { return req.URL.Host }
+	}
+
+	if config.Burst <= 0 {
+		config.Burst = max(1, int(math.Ceil(config.Rate)))
+	}
+
+	return &rateLimitTransport{
+		next:      transportOrDefault(next),
+		config:    config,
+		mu:        sync.Mutex{},
+		limiters:  make(map[string]*keyLimiter),
+		pruneSize: 0,
+	}
+}
+
+// RoundTrip waits for the request's key to allow it and then sends it.
+func (t *rateLimitTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	limiter := t.limiter(t.config.Key(req))
+
+	if err := limiter.wait(req.Context()); err != nil {
+		t.done(limiter)
+
+		return nil, err
+	}
+
+	if err := limiter.acquire(req.Context()); err != nil {
+		t.done(limiter)
+
+		return nil, err
+	}
+
+	release := func() {
+		limiter.release()
+		t.done(limiter)
+	}
+
+	resp, err := t.next.RoundTrip(req)
+	if resp != nil && t.config.AdaptToHeaders {
+		limiter.adapt(resp)
+	}
+
+	if err != nil || resp.Body == nil {
+		release()
+
+		return resp, err
+	}
+
+	return CloneHTTPResponseWithBody(resp, bodyWithCloseHook(resp.Body, release)), nil
+}
+
+// limiter returns the limiter of key, counting the request as one of its users until done is called.
+func (t *rateLimitTransport) limiter(key string) *keyLimiter {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	l, ok := t.limiters[key]
+	if !ok {
+		l = &keyLimiter{
+			mu:           sync.Mutex{},
+			rate:         t.config.Rate,
+			burst:        float64(t.config.Burst),
+			tokens:       float64(t.config.Burst),
+			last:         time.Now(),
+			blockedUntil: time.Time{},
+			slots:        nil,
+			users:        0,
+		}
+
+		if t.config.MaxInFlight > 0 {
+			l.slots = make(chan struct{}, t.config.MaxInFlight)
+		}
+
+		t.limiters[key] = l
+		t.pruneLocked()
+	}
+
+	l.users++
+
+	return l
+}
+
+// done stops counting a request as a user of l.
+func (t *rateLimitTransport) done(l *keyLimiter) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	l.users--
+}
+
+// pruneLocked evicts idle limiters whenever the map has doubled since the last pruning, keeping it amortized O(1).
+// An idle limiter behaves as a new one would, so evicting it changes nothing for the requests that follow.
+func (t *rateLimitTransport) pruneLocked() {
+	if len(t.limiters) < max(t.pruneSize*2, rateLimitPruneEntries) { //nolint:mnd // see above
+		return
+	}
+
+	now := time.Now()
+
+	for key, l := range t.limiters {
+		if l.users == 0 && l.idle(now) {
+			delete(t.limiters, key)
+		}
+	}
+
+	t.pruneSize = len(t.limiters)
+}
+
+// keyLimiter is the token bucket and semaphore of a single key.
+type keyLimiter struct {
+	mu           sync.Mutex
+	rate         float64
+	burst        float64
+	tokens       float64
+	last         time.Time
+	blockedUntil time.Time
+
+	slots chan struct{}
+	// users is the number of requests holding the limiter. It is guarded by rateLimitTransport.mu.
+	users int
+}
+
+// idle reports whether the bucket of l is full and the key is not paused at now.
+func (l *keyLimiter) idle(now time.Time) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	full := l.rate <= 0 || l.tokens+now.Sub(l.last).Seconds()*l.rate >= l.burst
+
+	return full && !l.blockedUntil.After(now)
+}
+
+// wait reserves a token, sleeping until it is available or ctx is done. A reservation abandoned because of ctx is
+// given back to the bucket.
+func (l *keyLimiter) wait(ctx context.Context) error {
+	delay := l.reserve(time.Now())
+	if delay <= 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		l.cancelReservation()
+
+		return ctx.Err()
+	}
+}
+
+func (l *keyLimiter) reserve(now time.Time) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	var delay time.Duration
+
+	if l.rate > 0 {
+		l.tokens = min(l.burst, l.tokens+now.Sub(l.last).Seconds()*l.rate)
+		l.last = now
+		l.tokens--
+
+		if l.tokens < 0 {
+			delay = time.Duration(-l.tokens / l.rate * float64(time.Second))
+		}
+	}
+
+	return max(delay, l.blockedUntil.Sub(now))
+}
+
+func (l *keyLimiter) cancelReservation() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.rate > 0 {
+		l.tokens = min(l.burst, l.tokens+1)
+	}
+}
+
+func (l *keyLimiter) acquire(ctx context.Context) error {
+	if l.slots == nil {
+		return nil
+	}
+
+	select {
+	case l.slots <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (l *keyLimiter) release() {
+	if l.slots != nil {
+		<-l.slots
+	}
+}
+
+// adapt pauses the key until the time advertised by the response rate limit headers, if any. Retry-After is only
+// honored on 429 and 503 responses, the statuses it asks clients to back off with.
+func (l *keyLimiter) adapt(resp *http.Response) {
+	var (
+		now   = time.Now()
+		until time.Time
+		ok    bool
+	)
+
+	if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode == http.StatusServiceUnavailable {
+		until, ok = retryAfter(resp.Header.Get("Retry-After"), now)
+	}
+
+	if !ok && resp.Header.Get("Ratelimit-Remaining") == "0" {
+		until, ok = deltaSeconds(resp.Header.Get("Ratelimit-Reset"), now)
+	}
+
+	if !ok {
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if until.After(l.blockedUntil) {
+		l.blockedUntil = until
+	}
+}
+
+// retryAfter parses a Retry-After header value, either delay-seconds or an HTTP-date.
+func retryAfter(value string, now time.Time) (time.Time, bool) {
+	if until, ok := deltaSeconds(value, now); ok {
+		return until, true
+	}
+
+	date, err := http.ParseTime(value)
+	if err != nil {
+		return time.Time{}, false
+	}
+
+	return date, true
+}
+
+func deltaSeconds(value string, now time.Time) (time.Time, bool) {
+	seconds, err := strconv.ParseUint(value, 10, 32)
+	if err != nil {
+		return time.Time{}, false
+	}
+
+	return now.Add(time.Duration(seconds) * time.Second), true
+}
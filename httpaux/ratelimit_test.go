@@ -0,0 +1,221 @@
+package httpaux
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/angrifel/unapologetic/internal/assert"
+)
+
+func TestTransportWithRateLimit(t *testing.T) {
+	okResponse := RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		return &http.Response{StatusCode: http.StatusOK, Header: http.Header{}, Body: io.NopCloser(strings.NewReader(""))}, nil
+	})
+
+	t.Run("spaces requests according to the rate once the burst is spent", func(t *testing.T) {
+		// arrange
+		rt := TransportWithRateLimit(okResponse, RateLimitConfig{Rate: 20, Burst: 1})
+		start := time.Now()
+
+		// act
+		for range 3 {
+			resp, err := rt.RoundTrip(httptest.NewRequest(http.MethodGet, "http://a.example/", nil))
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			_ = resp.Body.Close()
+		}
+
+		// assert
+		assert.GreaterOrEqual(t, time.Since(start), 90*time.Millisecond)
+	})
+
+	t.Run("keys are limited independently", func(t *testing.T) {
+		// arrange
+		rt := TransportWithRateLimit(okResponse, RateLimitConfig{Rate: 0.001, Burst: 1})
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+
+		// act
+		respA, errA := rt.RoundTrip(httptest.NewRequest(http.MethodGet, "http://a.example/", nil).WithContext(ctx))
+		respB, errB := rt.RoundTrip(httptest.NewRequest(http.MethodGet, "http://b.example/", nil).WithContext(ctx))
+		_, errA2 := rt.RoundTrip(httptest.NewRequest(http.MethodGet, "http://a.example/", nil).WithContext(ctx))
+
+		// assert
+		assert.Equal(t, nil, errA)
+		assert.Equal(t, nil, errB)
+		assert.Equal(t, context.DeadlineExceeded, errA2)
+
+		_ = respA.Body.Close()
+		_ = respB.Body.Close()
+	})
+
+	t.Run("holds the concurrency slot until the body is closed", func(t *testing.T) {
+		// arrange
+		rt := TransportWithRateLimit(okResponse, RateLimitConfig{MaxInFlight: 1})
+		first, err := rt.RoundTrip(httptest.NewRequest(http.MethodGet, "http://a.example/", nil))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		defer cancel()
+
+		// act
+		_, blockedErr := rt.RoundTrip(httptest.NewRequest(http.MethodGet, "http://a.example/", nil).WithContext(ctx))
+		_ = first.Body.Close()
+		_ = first.Body.Close()
+		second, secondErr := rt.RoundTrip(httptest.NewRequest(http.MethodGet, "http://a.example/", nil))
+
+		// assert
+		assert.Equal(t, context.DeadlineExceeded, blockedErr)
+		assert.Equal(t, nil, secondErr)
+
+		_ = second.Body.Close()
+	})
+
+	t.Run("releases the concurrency slot when the round trip fails", func(t *testing.T) {
+		// arrange
+		expectedErr := errors.New("connection reset")
+		rt := TransportWithRateLimit(RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			return nil, expectedErr
+		}), RateLimitConfig{MaxInFlight: 1})
+
+		// act
+		_, err1 := rt.RoundTrip(httptest.NewRequest(http.MethodGet, "http://a.example/", nil))
+		_, err2 := rt.RoundTrip(httptest.NewRequest(http.MethodGet, "http://a.example/", nil))
+
+		// assert
+		assert.Equal(t, expectedErr, err1)
+		assert.Equal(t, expectedErr, err2)
+	})
+
+	t.Run("adapts to Retry-After and RateLimit headers", func(t *testing.T) {
+		for name, header := range map[string]http.Header{
+			"Retry-After":         {"Retry-After": {"120"}},
+			"Retry-After as date": {"Retry-After": {time.Now().Add(time.Hour).UTC().Format(http.TimeFormat)}},
+			"RateLimit-Remaining": {"Ratelimit-Remaining": {"0"}, "Ratelimit-Reset": {"60"}},
+		} {
+			t.Run(name, func(t *testing.T) {
+				// arrange
+				calls := 0
+				rt := TransportWithRateLimit(RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+					calls++
+
+					return &http.Response{StatusCode: http.StatusTooManyRequests, Header: header, Body: io.NopCloser(strings.NewReader(""))}, nil
+				}), RateLimitConfig{AdaptToHeaders: true})
+				ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+				defer cancel()
+
+				// act
+				resp, err := rt.RoundTrip(httptest.NewRequest(http.MethodGet, "http://a.example/", nil))
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+
+				_ = resp.Body.Close()
+				_, blockedErr := rt.RoundTrip(httptest.NewRequest(http.MethodGet, "http://a.example/", nil).WithContext(ctx))
+
+				// assert
+				assert.Equal(t, context.DeadlineExceeded, blockedErr)
+				assert.Equal(t, 1, calls)
+			})
+		}
+	})
+
+	t.Run("adapts to responses without a body", func(t *testing.T) {
+		// arrange
+		calls := 0
+		rt := TransportWithRateLimit(RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			calls++
+
+			return &http.Response{StatusCode: http.StatusServiceUnavailable, Header: http.Header{"Retry-After": {"120"}}}, nil
+		}), RateLimitConfig{AdaptToHeaders: true})
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		defer cancel()
+
+		// act
+		_, err := rt.RoundTrip(httptest.NewRequest(http.MethodGet, "http://a.example/", nil))
+		_, blockedErr := rt.RoundTrip(httptest.NewRequest(http.MethodGet, "http://a.example/", nil).WithContext(ctx))
+
+		// assert
+		assert.Equal(t, nil, err)
+		assert.Equal(t, context.DeadlineExceeded, blockedErr)
+		assert.Equal(t, 1, calls)
+	})
+
+	t.Run("ignores rate limit headers unless asked to adapt", func(t *testing.T) {
+		// arrange
+		rt := TransportWithRateLimit(RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			return &http.Response{StatusCode: http.StatusTooManyRequests, Header: http.Header{"Retry-After": {"120"}}, Body: io.NopCloser(strings.NewReader(""))}, nil
+		}), RateLimitConfig{})
+
+		// act
+		first, err1 := rt.RoundTrip(httptest.NewRequest(http.MethodGet, "http://a.example/", nil))
+		second, err2 := rt.RoundTrip(httptest.NewRequest(http.MethodGet, "http://a.example/", nil))
+
+		// assert
+		assert.Equal(t, nil, err1)
+		assert.Equal(t, nil, err2)
+
+		_ = first.Body.Close()
+		_ = second.Body.Close()
+	})
+
+	t.Run("ignores Retry-After on other statuses", func(t *testing.T) {
+		// arrange
+		calls := 0
+		rt := TransportWithRateLimit(RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			calls++
+
+			return &http.Response{StatusCode: http.StatusOK, Header: http.Header{"Retry-After": {"120"}}, Body: io.NopCloser(strings.NewReader(""))}, nil
+		}), RateLimitConfig{AdaptToHeaders: true})
+
+		// act
+		for range 2 {
+			resp, err := rt.RoundTrip(httptest.NewRequest(http.MethodGet, "http://a.example/", nil))
+			assert.Equal(t, nil, err)
+
+			_ = resp.Body.Close()
+		}
+
+		// assert
+		assert.Equal(t, 2, calls)
+	})
+
+	t.Run("evicts idle limiters", func(t *testing.T) {
+		// arrange
+		rt := TransportWithRateLimit(okResponse, RateLimitConfig{MaxInFlight: 1})
+		transport := rt.(*rateLimitTransport)
+
+		busy, err := rt.RoundTrip(httptest.NewRequest(http.MethodGet, "http://busy.example/", nil))
+		assert.Equal(t, nil, err)
+
+		// act
+		for i := range rateLimitPruneEntries {
+			resp, err := rt.RoundTrip(httptest.NewRequest(http.MethodGet, "http://"+strconv.Itoa(i)+".example/", nil))
+			assert.Equal(t, nil, err)
+
+			_ = resp.Body.Close()
+		}
+
+		// assert
+		transport.mu.Lock()
+		_, kept := transport.limiters["busy.example"]
+		size := len(transport.limiters)
+		transport.mu.Unlock()
+
+		assert.Equal(t, true, kept)
+		assert.Less(t, size, rateLimitPruneEntries)
+
+		_ = busy.Body.Close()
+	})
+}
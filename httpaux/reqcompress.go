@@ -0,0 +1,123 @@
+package httpaux
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+)
+
+const (
+	// EncodingGzip is the gzip content coding (RFC 1952).
+	EncodingGzip = "gzip"
+	// EncodingDeflate is the deflate content coding, which is the zlib format (RFC 1950).
+	EncodingDeflate = "deflate"
+)
+
+const defaultMinCompressedSize = 1 << 10
+
+// ErrUnsupportedEncoding is reported for an unknown content coding.
+var ErrUnsupportedEncoding = errors.New("httpaux: unsupported content coding")
+
+// RequestCompressionConfig configures TransportWithRequestCompression.
+type RequestCompressionConfig struct {
+	// Encoding is the content coding, EncodingGzip or EncodingDeflate. Defaults to EncodingGzip.
+	Encoding string
+	// Level is the compression level, as defined by compress/flate. Zero means flate.DefaultCompression.
+	Level int
+	// MinSize is the size of the smallest body compressed. Defaults to 1KiB.
+	MinSize int64
+}
+
+type requestCompressionTransport struct {
+	next   http.RoundTripper
+	config RequestCompressionConfig
+}
+
+var _ http.RoundTripper = (*requestCompressionTransport)(nil)
+
+// TransportWithRequestCompression wraps next with an http.RoundTripper that compresses request bodies of at least
+// config.MinSize bytes and sets their Content-Encoding header. The compressed body is held in memory: the request
+// ContentLength is set to its size and GetBody replays it, so redirects and retries keep working.
+//
+// Bodies of unknown length are streamed and left alone, as are requests that already have a Content-Encoding, and
+// bodies that do not shrink are sent as they are. It panics on an unsupported encoding or an invalid level.
+// A nil next uses http.DefaultTransport.
+func TransportWithRequestCompression(next http.RoundTripper, config RequestCompressionConfig) http.RoundTripper {
+	if config.Encoding == "" {
+		config.Encoding = EncodingGzip
+	}
+
+	if config.Level == 0 {
+		config.Level = gzip.DefaultCompression
+	}
+
+	if config.MinSize <= 0 {
+		config.MinSize = defaultMinCompressedSize
+	}
+
+	if config.Encoding != EncodingGzip && config.Encoding != EncodingDeflate {
+		panic("unsupported request compression encoding " + config.Encoding)
+	}
+
+	if config.Level < gzip.HuffmanOnly || config.Level > gzip.BestCompression {
+		panic("invalid compression level: " + strconv.Itoa(config.Level))
+	}
+
+	return &requestCompressionTransport{next: transportOrDefault(next), config: config}
+}
+
+// RoundTrip compresses the body of req, if eligible, and sends it.
+func (t *requestCompressionTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Body == nil || req.Body == http.NoBody || req.ContentLength < t.config.MinSize ||
+		req.Header.Get("Content-Encoding") != "" {
+		return t.next.RoundTrip(req)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(req.Body, req.ContentLength))
+	_ = req.Body.Close()
+
+	if err == nil && int64(len(data)) < req.ContentLength {
+		err = io.ErrUnexpectedEOF
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("httpaux: reading request body: %w", err)
+	}
+
+	var compressed bytes.Buffer
+
+	compressor, _ := newCompressor(t.config.Encoding, &compressed, t.config.Level)
+	_, _ = compressor.Write(data)
+	_ = compressor.Close()
+
+	out := req.Clone(req.Context())
+
+	if compressed.Len() < len(data) {
+		data = compressed.Bytes()
+
+		out.Header.Set("Content-Encoding", t.config.Encoding)
+	}
+
+	out.Body = io.NopCloser(bytes.NewReader(data))
+	out.GetBody = func() (io.ReadCloser, error) { return io.NopCloser(bytes.NewReader(data)), nil }
+	out.ContentLength = int64(len(data))
+
+	return t.next.RoundTrip(out)
+}
+
+// newCompressor returns a writer compressing into w with the given content coding and level.
+func newCompressor(encoding string, w io.Writer, level int) (io.WriteCloser, error) {
+	switch encoding {
+	case EncodingGzip:
+		return gzip.NewWriterLevel(w, level)
+	case EncodingDeflate:
+		return zlib.NewWriterLevel(w, level)
+	default:
+		return nil, fmt.Errorf("%w: %q", ErrUnsupportedEncoding, encoding)
+	}
+}
@@ -0,0 +1,159 @@
+package httpaux
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"context"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/angrifel/unapologetic/internal/assert"
+)
+
+type capturedRequest struct {
+	encoding      string
+	contentLength int64
+	body          []byte
+	getBody       []byte
+}
+
+func captureRequests(captured *[]capturedRequest) http.RoundTripper {
+	return RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		c := capturedRequest{encoding: req.Header.Get("Content-Encoding"), contentLength: req.ContentLength}
+
+		if req.Body != nil {
+			c.body, _ = io.ReadAll(req.Body)
+		}
+
+		if req.GetBody != nil {
+			body, _ := req.GetBody()
+			c.getBody, _ = io.ReadAll(body)
+		}
+
+		*captured = append(*captured, c)
+
+		return &http.Response{StatusCode: http.StatusOK, Body: http.NoBody, Request: req}, nil
+	})
+}
+
+func TestTransportWithRequestCompression(t *testing.T) {
+	payload := strings.Repeat("compress me ", 200)
+
+	send := func(t *testing.T, transport http.RoundTripper, body io.Reader, header http.Header) *http.Request {
+		t.Helper()
+
+		req, _ := http.NewRequestWithContext(context.Background(), http.MethodPost, "http://example.com", body)
+		for name, values := range header {
+			req.Header[name] = values
+		}
+
+		resp, err := transport.RoundTrip(req)
+		assert.Equal(t, nil, err)
+
+		_ = resp.Body.Close()
+
+		return req
+	}
+
+	t.Run("compresses with gzip", func(t *testing.T) {
+		// arrange
+		var captured []capturedRequest
+
+		transport := TransportWithRequestCompression(captureRequests(&captured), RequestCompressionConfig{})
+
+		// act
+		req := send(t, transport, strings.NewReader(payload), nil)
+
+		// assert
+		assert.Equal(t, "", req.Header.Get("Content-Encoding"))
+		assert.Equal(t, int64(len(payload)), req.ContentLength)
+		assert.Equal(t, 1, len(captured))
+		assert.Equal(t, EncodingGzip, captured[0].encoding)
+		assert.Equal(t, int64(len(captured[0].body)), captured[0].contentLength)
+		assert.Equal(t, true, bytes.Equal(captured[0].body, captured[0].getBody))
+
+		reader, err := gzip.NewReader(bytes.NewReader(captured[0].body))
+		assert.Equal(t, nil, err)
+
+		data, _ := io.ReadAll(reader)
+		assert.Equal(t, payload, string(data))
+	})
+
+	t.Run("compresses with deflate", func(t *testing.T) {
+		// arrange
+		var captured []capturedRequest
+
+		transport := TransportWithRequestCompression(captureRequests(&captured), RequestCompressionConfig{
+			Encoding: EncodingDeflate,
+		})
+
+		// act
+		send(t, transport, strings.NewReader(payload), nil)
+
+		// assert
+		assert.Equal(t, EncodingDeflate, captured[0].encoding)
+
+		reader, err := zlib.NewReader(bytes.NewReader(captured[0].body))
+		assert.Equal(t, nil, err)
+
+		data, _ := io.ReadAll(reader)
+		assert.Equal(t, payload, string(data))
+	})
+
+	t.Run("skips small, encoded and streaming bodies", func(t *testing.T) {
+		// arrange
+		var captured []capturedRequest
+
+		transport := TransportWithRequestCompression(captureRequests(&captured), RequestCompressionConfig{})
+
+		// act
+		send(t, transport, strings.NewReader("small"), nil)
+		send(t, transport, strings.NewReader(payload), http.Header{"Content-Encoding": {"br"}})
+		send(t, transport, io.MultiReader(strings.NewReader(payload)), nil)
+
+		// assert
+		assert.Equal(t, "", captured[0].encoding)
+		assert.Equal(t, "br", captured[1].encoding)
+		assert.Equal(t, "", captured[2].encoding)
+		assert.Equal(t, int64(0), captured[2].contentLength)
+		assert.Equal(t, payload, string(captured[2].body))
+	})
+
+	t.Run("sends incompressible bodies as they are", func(t *testing.T) {
+		// arrange
+		var captured []capturedRequest
+
+		transport := TransportWithRequestCompression(captureRequests(&captured), RequestCompressionConfig{MinSize: 1})
+
+		// act
+		send(t, transport, strings.NewReader("xyz"), nil)
+
+		// assert
+		assert.Equal(t, "", captured[0].encoding)
+		assert.Equal(t, "xyz", string(captured[0].body))
+		assert.Equal(t, "xyz", string(captured[0].getBody))
+	})
+
+	t.Run("rejects unsupported encodings", func(t *testing.T) {
+		// act
+		defer func() {
+			// assert
+			assert.Equal(t, true, recover() != nil)
+		}()
+
+		TransportWithRequestCompression(nil, RequestCompressionConfig{Encoding: "br"})
+	})
+
+	t.Run("rejects invalid levels", func(t *testing.T) {
+		// act
+		defer func() {
+			// assert
+			assert.Equal(t, "invalid compression level: 42", recover())
+		}()
+
+		TransportWithRequestCompression(nil, RequestCompressionConfig{Level: 42})
+	})
+}
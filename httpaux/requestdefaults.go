@@ -0,0 +1,143 @@
+package httpaux
+
+import (
+	"cmp"
+	"net/http"
+	"net/url"
+	"runtime"
+	"slices"
+	"strings"
+	"unicode/utf8"
+)
+
+// UserAgent returns a User-Agent header value made of the app/version product followed by the Go version, e.g.
+// "myapp/1.2.3 go/1.24.10".
+func UserAgent(app, version string) string {
+	return app + "/" + version + " go/" + goVersionToken(runtime.Version())
+}
+
+// goVersionToken turns a runtime.Version value into a product version token: the release of "go1.24.10" or
+// "go1.24.10 X:nodwarf5", the base release of development versions such as "devel go1.25-4d3e2a1 Tue Jan 7 ...",
+// and otherwise its first word, without the characters a token cannot hold.
+func goVersionToken(version string) string {
+	fields := strings.Fields(version)
+	if len(fields) == 0 {
+		return "unknown"
+	}
+
+	token := fields[0]
+
+	for _, field := range fields {
+		if strings.HasPrefix(field, "go1") {
+			token = field
+
+			break
+		}
+	}
+
+	token = strings.Map(func(r rune) rune {
+		if r < utf8.RuneSelf && (r >= '0' && r <= '9' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' ||
+			strings.ContainsRune("!#$%&'*+-.^_`|~", r)) {
+			return r
+		}
+
+		return -1
+	}, strings.TrimPrefix(token, "go"))
+
+	return cmp.Or(token, "unknown")
+}
+
+// RequestDefaultsRule sets headers and query parameters on the requests it matches.
+type RequestDefaultsRule struct {
+	// Host, if set, restricts the rule to requests for that host, with or without port, compared case-insensitively.
+	Host string
+	// URLPrefix, if set, restricts the rule to requests whose URL starts with it, e.g. "https://api.example.com/v2/".
+	URLPrefix string
+	// Header holds headers set on requests that do not have them.
+	Header http.Header
+	// OverrideHeader holds headers set on requests, replacing any values they have.
+	OverrideHeader http.Header
+	// Query holds query parameters added to requests that do not have them.
+	Query url.Values
+}
+
+// matches reports whether the rule applies to req.
+func (r RequestDefaultsRule) matches(req *http.Request) bool {
+	if r.Host != "" && !strings.EqualFold(r.Host, req.URL.Host) && !strings.EqualFold(r.Host, req.URL.Hostname()) {
+		return false
+	}
+
+	return r.URLPrefix == "" || strings.HasPrefix(req.URL.String(), r.URLPrefix)
+}
+
+// RequestDefaultsConfig configures TransportWithRequestDefaults.
+type RequestDefaultsConfig struct {
+	// Rules are applied in order to every request they match, so later rules override earlier ones.
+	Rules []RequestDefaultsRule
+}
+
+// TransportWithRequestDefaults wraps next with an http.RoundTripper that applies the matching rules of config to a
+// clone of every request, leaving the request of the caller untouched. Query parameters are appended to the raw
+// query, so the existing ones keep their order and encoding. UserAgent composes a User-Agent header for the rules.
+// A nil next uses http.DefaultTransport.
+func TransportWithRequestDefaults(next http.RoundTripper, config RequestDefaultsConfig) http.RoundTripper {
+	next = transportOrDefault(next)
+
+	return RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		var out *http.Request
+
+		for _, rule := range config.Rules {
+			if !rule.matches(req) {
+				continue
+			}
+
+			if out == nil {
+				out = req.Clone(req.Context())
+			}
+
+			applyRequestDefaults(out, rule)
+		}
+
+		if out == nil {
+			return next.RoundTrip(req)
+		}
+
+		return next.RoundTrip(out)
+	})
+}
+
+// applyRequestDefaults applies rule to req, which must be a clone owned by the caller.
+func applyRequestDefaults(req *http.Request, rule RequestDefaultsRule) {
+	for name, values := range rule.Header {
+		if len(req.Header.Values(name)) == 0 {
+			req.Header[http.CanonicalHeaderKey(name)] = slices.Clone(values)
+		}
+	}
+
+	for name, values := range rule.OverrideHeader {
+		req.Header[http.CanonicalHeaderKey(name)] = slices.Clone(values)
+	}
+
+	if len(rule.Query) == 0 {
+		return
+	}
+
+	query := req.URL.Query()
+	missing := make(url.Values)
+
+	for name, values := range rule.Query {
+		if !query.Has(name) {
+			missing[name] = values
+		}
+	}
+
+	if len(missing) == 0 {
+		return
+	}
+
+	if req.URL.RawQuery == "" {
+		req.URL.RawQuery = missing.Encode()
+	} else {
+		req.URL.RawQuery += "&" + missing.Encode()
+	}
+}
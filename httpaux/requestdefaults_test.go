@@ -0,0 +1,145 @@
+package httpaux
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/angrifel/unapologetic/internal/assert"
+)
+
+func TestUserAgent(t *testing.T) {
+	// act
+	userAgent := UserAgent("myapp", "1.2.3")
+
+	// assert
+	assert.Equal(t, "myapp/1.2.3 go/"+goVersionToken(runtime.Version()), userAgent)
+	assert.Equal(t, 2, len(strings.Fields(userAgent)))
+}
+
+func TestGoVersionToken(t *testing.T) {
+	for version, expected := range map[string]string{
+		"go1.24.10":            "1.24.10",
+		"go1.24.10 X:nodwarf5": "1.24.10",
+		"devel go1.25-4d3e2a1 Tue Jan 7 10:00:00 2025 +0000": "1.25-4d3e2a1",
+		"devel +4d3e2a1 Tue Jan 7 10:00:00 2025 +0000":       "devel",
+		"go(weird)/1": "weird1",
+		"":            "unknown",
+	} {
+		t.Run(version, func(t *testing.T) {
+			assert.Equal(t, expected, goVersionToken(version))
+		})
+	}
+}
+
+func TestTransportWithRequestDefaults(t *testing.T) {
+	var sent *http.Request
+
+	next := RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		sent = req
+
+		return &http.Response{StatusCode: http.StatusOK, Body: http.NoBody, Request: req}, nil
+	})
+
+	send := func(t *testing.T, config RequestDefaultsConfig, rawURL string, header http.Header) *http.Request {
+		t.Helper()
+
+		sent = nil
+		req, _ := http.NewRequestWithContext(context.Background(), http.MethodGet, rawURL, nil)
+
+		for name, values := range header {
+			req.Header[name] = values
+		}
+
+		_, err := TransportWithRequestDefaults(next, config).RoundTrip(req)
+		assert.Equal(t, nil, err)
+
+		return req
+	}
+
+	t.Run("sets missing headers and overrides others", func(t *testing.T) {
+		// arrange
+		config := RequestDefaultsConfig{Rules: []RequestDefaultsRule{{
+			Header:         http.Header{"User-Agent": {"default"}, "Accept": {"application/json"}},
+			OverrideHeader: http.Header{"x-tenant": {"acme"}},
+		}}}
+
+		// act
+		req := send(t, config, "https://api.example.com/items", http.Header{
+			"User-Agent": {"mine"},
+			"X-Tenant":   {"other"},
+		})
+
+		// assert
+		assert.Equal(t, "mine", sent.Header.Get("User-Agent"))
+		assert.Equal(t, "application/json", sent.Header.Get("Accept"))
+		assert.Equal(t, "acme", sent.Header.Get("X-Tenant"))
+		assert.Equal(t, "other", req.Header.Get("X-Tenant"))
+		assert.Equal(t, "", req.Header.Get("Accept"))
+	})
+
+	t.Run("appends missing query parameters", func(t *testing.T) {
+		// arrange
+		config := RequestDefaultsConfig{Rules: []RequestDefaultsRule{{
+			Query: url.Values{"api-version": {"2024-01-01"}, "z": {"ignored"}},
+		}}}
+
+		// act
+		req := send(t, config, "https://api.example.com/items?z=1&a=%2F", nil)
+
+		// assert
+		assert.Equal(t, "z=1&a=%2F&api-version=2024-01-01", sent.URL.RawQuery)
+		assert.Equal(t, "z=1&a=%2F", req.URL.RawQuery)
+	})
+
+	t.Run("applies only matching rules", func(t *testing.T) {
+		// arrange
+		config := RequestDefaultsConfig{Rules: []RequestDefaultsRule{
+			{Host: "API.example.com", Header: http.Header{"X-Host": {"yes"}}},
+			{URLPrefix: "https://api.example.com/v2/", Header: http.Header{"X-V2": {"yes"}}},
+			{Host: "other.example.com", Header: http.Header{"X-Other": {"yes"}}},
+		}}
+
+		// act
+		send(t, config, "https://api.example.com:8443/v2/items", nil)
+
+		// assert
+		assert.Equal(t, "yes", sent.Header.Get("X-Host"))
+		assert.Equal(t, "", sent.Header.Get("X-V2"))
+		assert.Equal(t, "", sent.Header.Get("X-Other"))
+
+		// act
+		send(t, config, "https://api.example.com/v2/items", nil)
+
+		// assert
+		assert.Equal(t, "yes", sent.Header.Get("X-V2"))
+	})
+
+	t.Run("lets later rules override earlier ones", func(t *testing.T) {
+		// arrange
+		config := RequestDefaultsConfig{Rules: []RequestDefaultsRule{
+			{OverrideHeader: http.Header{"X-Rule": {"first"}}},
+			{OverrideHeader: http.Header{"X-Rule": {"second"}}},
+		}}
+
+		// act
+		send(t, config, "https://api.example.com/items", nil)
+
+		// assert
+		assert.Equal(t, "second", sent.Header.Get("X-Rule"))
+	})
+
+	t.Run("passes requests without matching rules through", func(t *testing.T) {
+		// arrange
+		config := RequestDefaultsConfig{Rules: []RequestDefaultsRule{{Host: "other.example.com"}}}
+
+		// act
+		req := send(t, config, "https://api.example.com/items", nil)
+
+		// assert
+		assert.Equal(t, req, sent)
+	})
+}
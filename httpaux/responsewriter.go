@@ -0,0 +1,56 @@
+package httpaux
+
+import (
+	"bufio"
+	"net"
+	"net/http"
+)
+
+// responseInterceptor is a ResponseWriter wrapper that observes the flushes and hijacks of the writer it wraps.
+type responseInterceptor interface {
+	http.ResponseWriter
+
+	// Unwrap returns the wrapped ResponseWriter, for http.ResponseController.
+	Unwrap() http.ResponseWriter
+	flush()
+	hijack() (net.Conn, *bufio.ReadWriter, error)
+}
+
+type flusherInterceptor struct{ responseInterceptor }
+
+type hijackerInterceptor struct{ responseInterceptor }
+
+type flusherHijackerInterceptor struct{ responseInterceptor }
+
+var (
+	_ http.Flusher  = flusherInterceptor{}
+	_ http.Hijacker = hijackerInterceptor{}
+	_ http.Flusher  = flusherHijackerInterceptor{}
+	_ http.Hijacker = flusherHijackerInterceptor{}
+)
+
+func (w flusherInterceptor) Flush() { w.flush() }
+
+func (w hijackerInterceptor) Hijack() (net.Conn, *bufio.ReadWriter, error) { return w.hijack() }
+
+func (w flusherHijackerInterceptor) Flush() { w.flush() }
+
+func (w flusherHijackerInterceptor) Hijack() (net.Conn, *bufio.ReadWriter, error) { return w.hijack() }
+
+// exposeCapabilities returns interceptor as a ResponseWriter that implements http.Flusher and http.Hijacker only when
+// w, the writer it wraps, does, so that handlers type-asserting them find what the connection supports.
+func exposeCapabilities(w http.ResponseWriter, interceptor responseInterceptor) http.ResponseWriter {
+	_, flusher := w.(http.Flusher)
+	_, hijacker := w.(http.Hijacker)
+
+	switch {
+	case flusher && hijacker:
+		return flusherHijackerInterceptor{interceptor}
+	case flusher:
+		return flusherInterceptor{interceptor}
+	case hijacker:
+		return hijackerInterceptor{interceptor}
+	default:
+		return interceptor
+	}
+}
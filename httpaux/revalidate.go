@@ -0,0 +1,311 @@
+package httpaux
+
+import (
+	"cmp"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+)
+
+const defaultMaxRevalidatedBodySize = 10 << 20
+
+// StoredResponse is a response remembered by a ValidatorStore, along with its validators.
+type StoredResponse struct {
+	// ETag is the entity tag of the response, if any.
+	ETag string `json:"etag,omitempty"`
+	// LastModified is the Last-Modified date of the response, if any.
+	LastModified string `json:"lastModified,omitempty"`
+	// Header is the header of the response.
+	Header http.Header `json:"header"`
+	// Body is the body of the response.
+	Body []byte `json:"body"`
+}
+
+// ValidatorStore remembers, by key, the last response with validators. Implementations must be safe for concurrent
+// use and must not retain or modify the responses passed to or returned from them.
+type ValidatorStore interface {
+	// Get returns the response stored under key, reporting false if there is none.
+	Get(ctx context.Context, key string) (StoredResponse, bool, error)
+	// Put stores response under key, replacing any previous one.
+	Put(ctx context.Context, key string, response StoredResponse) error
+	// Delete forgets the response stored under key, if any.
+	Delete(ctx context.Context, key string) error
+}
+
+// InMemoryValidatorStore is a ValidatorStore that keeps responses in memory. The zero value is ready to use.
+type InMemoryValidatorStore struct {
+	mu        sync.Mutex
+	responses map[string]StoredResponse
+}
+
+// FileValidatorStore is a ValidatorStore that keeps every response in its own JSON file in Dir, which must exist.
+type FileValidatorStore struct {
+	// Dir is the directory holding the files.
+	Dir string
+}
+
+var (
+	_ ValidatorStore    = (*InMemoryValidatorStore)(nil)
+	_ ValidatorStore    = FileValidatorStore{}
+	_ http.RoundTripper = (*revalidationTransport)(nil)
+)
+
+// Get returns the response stored under key.
+func (s *InMemoryValidatorStore) Get(_ context.Context, key string) (StoredResponse, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	response, ok := s.responses[key]
+
+	return cloneStoredResponse(response), ok, nil
+}
+
+// Put stores response under key.
+func (s *InMemoryValidatorStore) Put(_ context.Context, key string, response StoredResponse) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.responses == nil {
+		s.responses = make(map[string]StoredResponse)
+	}
+
+	s.responses[key] = cloneStoredResponse(response)
+
+	return nil
+}
+
+// Delete forgets the response stored under key.
+func (s *InMemoryValidatorStore) Delete(_ context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.responses, key)
+
+	return nil
+}
+
+func cloneStoredResponse(response StoredResponse) StoredResponse {
+	response.Header = response.Header.Clone()
+	response.Body = append([]byte(nil), response.Body...)
+
+	return response
+}
+
+// Get reads the response stored under key.
+func (s FileValidatorStore) Get(_ context.Context, key string) (StoredResponse, bool, error) {
+	var response StoredResponse
+
+	data, err := os.ReadFile(s.path(key))
+	if errors.Is(err, os.ErrNotExist) {
+		return response, false, nil
+	} else if err != nil {
+		return response, false, err
+	}
+
+	if err := json.Unmarshal(data, &response); err != nil {
+		return response, false, err
+	}
+
+	return response, true, nil
+}
+
+// Put writes response under key, replacing the previous file atomically.
+func (s FileValidatorStore) Put(_ context.Context, key string, response StoredResponse) error {
+	data, err := json.Marshal(response)
+	if err != nil {
+		return err
+	}
+
+	file, err := os.CreateTemp(s.Dir, ".validator-*")
+	if err != nil {
+		return err
+	}
+
+	_, err = file.Write(data)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+
+	if err == nil {
+		err = os.Rename(file.Name(), s.path(key))
+	}
+
+	if err != nil {
+		_ = os.Remove(file.Name())
+	}
+
+	return err
+}
+
+// Delete removes the file of the response stored under key.
+func (s FileValidatorStore) Delete(_ context.Context, key string) error {
+	if err := os.Remove(s.path(key)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	return nil
+}
+
+// path returns the name of the file of key, hashed so that any key makes a valid file name.
+func (s FileValidatorStore) path(key string) string {
+	sum := sha256.Sum256([]byte(key))
+
+	return filepath.Join(s.Dir, hex.EncodeToString(sum[:])+".json")
+}
+
+// RevalidationConfig configures TransportWithRevalidation.
+type RevalidationConfig struct {
+	// Store remembers the responses. Defaults to an InMemoryValidatorStore.
+	Store ValidatorStore
+	// Key returns the key responses to req are stored under. Defaults to the request URL.
+	Key func(req *http.Request) string
+	// MaxBodySize is the size of the largest body stored. Larger responses are passed through. Defaults to 10MiB.
+	MaxBodySize int64
+}
+
+type revalidationTransport struct {
+	next   http.RoundTripper
+	config RevalidationConfig
+}
+
+// TransportWithRevalidation wraps next with an http.RoundTripper that remembers the last 200 OK response to GET
+// requests carrying an ETag or Last-Modified validator and makes subsequent GET requests for the same key
+// conditional, with If-None-Match and If-Modified-Since. A 304 Not Modified answer is turned into a 200 OK response
+// built with CloneHTTPResponseWithBody from the stored body, its header updated with the 304 header.
+//
+// This is not an HTTP cache: every request still reaches the upstream, and freshness is not considered. Requests
+// that are already conditional or ask for a range are passed through. Store errors are returned.
+// A nil next uses http.DefaultTransport.
+func TransportWithRevalidation(next http.RoundTripper, config RevalidationConfig) http.RoundTripper {
+	if config.Store == nil {
+		config.Store = &InMemoryValidatorStore{mu: sync.Mutex{}, responses: nil}
+	}
+
+	if config.Key == nil {
+		config.Key = func(req *http.Request) string { return req.URL.String() }
+	}
+
+	if config.MaxBodySize <= 0 {
+		config.MaxBodySize = defaultMaxRevalidatedBodySize
+	}
+
+	return &revalidationTransport{next: transportOrDefault(next), config: config}
+}
+
+// RoundTrip sends req, conditionally if a response to it is stored.
+func (t *revalidationTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Method != http.MethodGet || req.Header.Get("Range") != "" ||
+		req.Header.Get("If-None-Match") != "" || req.Header.Get("If-Modified-Since") != "" {
+		return t.next.RoundTrip(req)
+	}
+
+	ctx := req.Context()
+	key := t.config.Key(req)
+
+	stored, found, err := t.config.Store.Get(ctx, key)
+	if err != nil {
+		closeRequestBody(req)
+
+		return nil, err
+	}
+
+	if found {
+		req = req.Clone(ctx)
+
+		if stored.ETag != "" {
+			req.Header.Set("If-None-Match", stored.ETag)
+		}
+
+		if stored.LastModified != "" {
+			req.Header.Set("If-Modified-Since", stored.LastModified)
+		}
+	}
+
+	resp, err := t.next.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case resp.StatusCode == http.StatusNotModified && found:
+		return t.notModified(ctx, key, stored, resp)
+	case resp.StatusCode == http.StatusOK && (resp.Header.Get("ETag") != "" || resp.Header.Get("Last-Modified") != ""):
+		return t.store(ctx, key, resp)
+	case found && resp.StatusCode != http.StatusNotModified:
+		if err := t.config.Store.Delete(ctx, key); err != nil {
+			discardBody(resp.Body)
+
+			return nil, err
+		}
+	}
+
+	return resp, nil
+}
+
+// notModified answers a 304 Not Modified response with the stored response, updated with its header.
+func (t *revalidationTransport) notModified(
+	ctx context.Context, key string, stored StoredResponse, resp *http.Response,
+) (*http.Response, error) {
+	discardBody(resp.Body)
+
+	header := stored.Header.Clone()
+	for name, values := range resp.Header {
+		header[name] = values
+	}
+
+	stored.Header = header
+	stored.ETag = cmp.Or(header.Get("ETag"), stored.ETag)
+	stored.LastModified = cmp.Or(header.Get("Last-Modified"), stored.LastModified)
+
+	if err := t.config.Store.Put(ctx, key, stored); err != nil {
+		return nil, err
+	}
+
+	result := CloneHTTPResponseWithBody(resp, memoryBody(stored.Body, nil, nil))
+	result.StatusCode = http.StatusOK
+	result.Status = strconv.Itoa(http.StatusOK) + " " + http.StatusText(http.StatusOK)
+	result.Header = header.Clone()
+	result.Header.Set("Content-Length", strconv.Itoa(len(stored.Body)))
+	result.ContentLength = int64(len(stored.Body))
+	result.TransferEncoding = nil
+
+	return result, nil
+}
+
+// store buffers and stores resp, unless its body is too large, in which case it is passed through.
+func (t *revalidationTransport) store(ctx context.Context, key string, resp *http.Response) (*http.Response, error) {
+	if resp.ContentLength > t.config.MaxBodySize {
+		return resp, nil
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, t.config.MaxBodySize+1))
+	if err != nil || int64(len(data)) > t.config.MaxBodySize {
+		return CloneHTTPResponseWithBody(resp, struct {
+			io.Reader
+			io.Closer
+		}{Reader: io.MultiReader(memoryBody(data, err, nil), resp.Body), Closer: resp.Body}), nil
+	}
+
+	_ = resp.Body.Close()
+
+	stored := StoredResponse{
+		ETag:         resp.Header.Get("ETag"),
+		LastModified: resp.Header.Get("Last-Modified"),
+		Header:       resp.Header.Clone(),
+		Body:         data,
+	}
+
+	if err := t.config.Store.Put(ctx, key, stored); err != nil {
+		return nil, err
+	}
+
+	return CloneHTTPResponseWithBody(resp, memoryBody(data, nil, nil)), nil
+}
@@ -0,0 +1,214 @@
+package httpaux
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/angrifel/unapologetic/internal/assert"
+)
+
+func newRevalidationServer(t *testing.T, conditional *atomic.Int32) *httptest.Server {
+	t.Helper()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/etag":
+			w.Header().Set("X-Served", "fresh")
+
+			if r.Header.Get("If-None-Match") == `"v1"` {
+				conditional.Add(1)
+				w.Header().Set("X-Served", "revalidated")
+				w.WriteHeader(http.StatusNotModified)
+
+				return
+			}
+
+			w.Header().Set("ETag", `"v1"`)
+			w.Header().Set("Content-Type", "text/plain")
+			_, _ = w.Write([]byte("hello"))
+		case "/modified":
+			if r.Header.Get("If-Modified-Since") == "Mon, 02 Jan 2006 15:04:05 GMT" {
+				conditional.Add(1)
+				w.WriteHeader(http.StatusNotModified)
+
+				return
+			}
+
+			w.Header().Set("Last-Modified", "Mon, 02 Jan 2006 15:04:05 GMT")
+			_, _ = w.Write([]byte("dated"))
+		case "/plain":
+			if r.Header.Get("If-None-Match") != "" {
+				conditional.Add(1)
+			}
+
+			_, _ = w.Write([]byte("no validators"))
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	return server
+}
+
+func getRevalidated(t *testing.T, client *http.Client, url string) (*http.Response, string) {
+	t.Helper()
+
+	req, _ := http.NewRequestWithContext(context.Background(), http.MethodGet, url, nil)
+
+	resp, err := client.Do(req)
+	assert.Equal(t, nil, err)
+
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	assert.Equal(t, nil, err)
+
+	return resp, string(body)
+}
+
+func TestTransportWithRevalidation(t *testing.T) {
+	t.Run("turns 304 answers into the stored response", func(t *testing.T) {
+		// arrange
+		var conditional atomic.Int32
+
+		server := newRevalidationServer(t, &conditional)
+		client := &http.Client{Transport: TransportWithRevalidation(nil, RevalidationConfig{})}
+
+		// act
+		first, firstBody := getRevalidated(t, client, server.URL+"/etag")
+		second, secondBody := getRevalidated(t, client, server.URL+"/etag")
+
+		// assert
+		assert.Equal(t, int32(1), conditional.Load())
+		assert.Equal(t, "fresh", first.Header.Get("X-Served"))
+		assert.Equal(t, "hello", firstBody)
+		assert.Equal(t, http.StatusOK, second.StatusCode)
+		assert.Equal(t, "hello", secondBody)
+		assert.Equal(t, int64(5), second.ContentLength)
+		assert.Equal(t, "text/plain", second.Header.Get("Content-Type"))
+		assert.Equal(t, "revalidated", second.Header.Get("X-Served"))
+	})
+
+	t.Run("revalidates with Last-Modified", func(t *testing.T) {
+		// arrange
+		var conditional atomic.Int32
+
+		server := newRevalidationServer(t, &conditional)
+		client := &http.Client{Transport: TransportWithRevalidation(nil, RevalidationConfig{})}
+
+		// act
+		getRevalidated(t, client, server.URL+"/modified")
+		resp, body := getRevalidated(t, client, server.URL+"/modified")
+
+		// assert
+		assert.Equal(t, int32(1), conditional.Load())
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, "dated", body)
+	})
+
+	t.Run("does not store responses without validators", func(t *testing.T) {
+		// arrange
+		var conditional atomic.Int32
+
+		server := newRevalidationServer(t, &conditional)
+		client := &http.Client{Transport: TransportWithRevalidation(nil, RevalidationConfig{})}
+
+		// act
+		getRevalidated(t, client, server.URL+"/plain")
+		_, body := getRevalidated(t, client, server.URL+"/plain")
+
+		// assert
+		assert.Equal(t, int32(0), conditional.Load())
+		assert.Equal(t, "no validators", body)
+	})
+
+	t.Run("passes large bodies through", func(t *testing.T) {
+		// arrange
+		var conditional atomic.Int32
+
+		server := newRevalidationServer(t, &conditional)
+		client := &http.Client{Transport: TransportWithRevalidation(nil, RevalidationConfig{MaxBodySize: 2})}
+
+		// act
+		_, first := getRevalidated(t, client, server.URL+"/etag")
+		_, second := getRevalidated(t, client, server.URL+"/etag")
+
+		// assert
+		assert.Equal(t, int32(0), conditional.Load())
+		assert.Equal(t, "hello", first)
+		assert.Equal(t, "hello", second)
+	})
+
+	t.Run("persists validators in files", func(t *testing.T) {
+		// arrange
+		var conditional atomic.Int32
+
+		server := newRevalidationServer(t, &conditional)
+		store := FileValidatorStore{Dir: t.TempDir()}
+
+		// act
+		getRevalidated(t, &http.Client{Transport: TransportWithRevalidation(nil, RevalidationConfig{Store: store})},
+			server.URL+"/etag")
+		resp, body := getRevalidated(t,
+			&http.Client{Transport: TransportWithRevalidation(nil, RevalidationConfig{Store: store})}, server.URL+"/etag")
+
+		// assert
+		assert.Equal(t, int32(1), conditional.Load())
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, "hello", body)
+	})
+
+	t.Run("leaves conditional requests alone", func(t *testing.T) {
+		// arrange
+		var conditional atomic.Int32
+
+		server := newRevalidationServer(t, &conditional)
+		client := &http.Client{Transport: TransportWithRevalidation(nil, RevalidationConfig{})}
+		getRevalidated(t, client, server.URL+"/etag")
+
+		req, _ := http.NewRequestWithContext(context.Background(), http.MethodGet, server.URL+"/etag", nil)
+		req.Header.Set("If-None-Match", `"v1"`)
+
+		// act
+		resp, err := client.Do(req)
+
+		// assert
+		assert.Equal(t, nil, err)
+		assert.Equal(t, http.StatusNotModified, resp.StatusCode)
+		_ = resp.Body.Close()
+	})
+}
+
+func TestFileValidatorStore(t *testing.T) {
+	t.Run("round trips and deletes responses", func(t *testing.T) {
+		// arrange
+		store := FileValidatorStore{Dir: t.TempDir()}
+		ctx := context.Background()
+		response := StoredResponse{
+			ETag:         `W/"1"`,
+			LastModified: "",
+			Header:       http.Header{"Content-Type": {"text/plain"}},
+			Body:         []byte(strings.Repeat("x", 3)),
+		}
+
+		// act
+		putErr := store.Put(ctx, "https://example.com/a?b=c", response)
+		got, found, getErr := store.Get(ctx, "https://example.com/a?b=c")
+		deleteErr := store.Delete(ctx, "https://example.com/a?b=c")
+		_, foundAfterDelete, _ := store.Get(ctx, "https://example.com/a?b=c")
+
+		// assert
+		assert.Equal(t, nil, putErr)
+		assert.Equal(t, nil, getErr)
+		assert.Equal(t, nil, deleteErr)
+		assert.Equal(t, true, found)
+		assert.Equal(t, `W/"1"`, got.ETag)
+		assert.Equal(t, "text/plain", got.Header.Get("Content-Type"))
+		assert.Equal(t, "xxx", string(got.Body))
+		assert.Equal(t, false, foundAfterDelete)
+	})
+}
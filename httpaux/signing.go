@@ -0,0 +1,192 @@
+package httpaux
+
+import (
+	"cmp"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+)
+
+// UnsignedPayload is the payload hash used by signing middlewares that do not sign the request body.
+const UnsignedPayload = "UNSIGNED-PAYLOAD"
+
+// emptyPayloadHash is the hex-encoded SHA-256 of an empty payload.
+const emptyPayloadHash = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+// canonicalRequest builds the canonical form of a request shared by the signing middlewares. Header must be
+// keyed by lower-case names, as returned by signingHeader:
+//
+//	METHOD
+//	canonical path
+//	canonical query
+//	canonical headers
+//	signed headers
+//	payload hash
+func canonicalRequest(method, path, rawQuery string, header http.Header, signedHeaders []string, payloadHash string) string {
+	var b strings.Builder
+
+	b.WriteString(method)
+	b.WriteString("\n")
+	b.WriteString(path)
+	b.WriteString("\n")
+	b.WriteString(canonicalQuery(rawQuery))
+	b.WriteString("\n")
+
+	for _, name := range signedHeaders {
+		b.WriteString(name)
+		b.WriteString(":")
+		b.WriteString(canonicalHeaderValue(header[name]))
+		b.WriteString("\n")
+	}
+
+	b.WriteString("\n")
+	b.WriteString(strings.Join(signedHeaders, ";"))
+	b.WriteString("\n")
+	b.WriteString(payloadHash)
+
+	return b.String()
+}
+
+// canonicalQuery URI-encodes every query parameter name and value and sorts them by name, then value.
+func canonicalQuery(rawQuery string) string {
+	if rawQuery == "" {
+		return ""
+	}
+
+	values, _ := url.ParseQuery(rawQuery)
+	pairs := make([][2]string, 0, len(values))
+
+	for name, vs := range values {
+		for _, v := range vs {
+			pairs = append(pairs, [2]string{uriEncode(name, true), uriEncode(v, true)})
+		}
+	}
+
+	slices.SortFunc(pairs, func(a, b [2]string) int {
+		return cmp.Or(strings.Compare(a[0], b[0]), strings.Compare(a[1], b[1]))
+	})
+
+	encoded := make([]string, len(pairs))
+	for i, pair := range pairs {
+		encoded[i] = pair[0] + "=" + pair[1]
+	}
+
+	return strings.Join(encoded, "&")
+}
+
+// canonicalHeaderValue trims the values, collapses their inner runs of spaces and joins them with commas.
+func canonicalHeaderValue(values []string) string {
+	trimmed := make([]string, len(values))
+	for i, v := range values {
+		trimmed[i] = strings.Join(strings.Fields(v), " ")
+	}
+
+	return strings.Join(trimmed, ",")
+}
+
+// signingHeader returns a copy of the request headers, keyed by lower-case names, including Host, which net/http
+// keeps out of Request.Header.
+func signingHeader(req *http.Request) http.Header {
+	header := make(http.Header, len(req.Header)+1)
+
+	for name, values := range req.Header {
+		header[strings.ToLower(name)] = slices.Clone(values)
+	}
+
+	host := req.Host
+	if host == "" {
+		host = req.URL.Host
+	}
+
+	header["host"] = []string{host}
+
+	return header
+}
+
+// sortedHeaderNames returns the lower-case header names, excluding those in skip, sorted.
+func sortedHeaderNames(header http.Header, skip ...string) []string {
+	names := make([]string, 0, len(header))
+
+	for name := range header {
+		if !slices.Contains(skip, name) {
+			names = append(names, name)
+		}
+	}
+
+	slices.Sort(names)
+
+	return names
+}
+
+// uriEncode percent-encodes every byte of s except the RFC 3986 unreserved characters and, unless encodeSlash is
+// set, '/'. Hex digits are upper case.
+func uriEncode(s string, encodeSlash bool) string {
+	const hexDigits = "0123456789ABCDEF"
+
+	var b strings.Builder
+
+	for i := range len(s) {
+		c := s[i]
+
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9', c == '-', c == '_', c == '.', c == '~':
+			b.WriteByte(c)
+		case c == '/' && !encodeSlash:
+			b.WriteByte(c)
+		default:
+			b.WriteByte('%')
+			b.WriteByte(hexDigits[c>>4])
+			b.WriteByte(hexDigits[c&0x0f])
+		}
+	}
+
+	return b.String()
+}
+
+// payloadSHA256 returns the hex-encoded SHA-256 of the request body along with a request that can still be sent.
+// Bodies that cannot be replayed are buffered with BufferRequestBody. Errors reading the body are returned.
+func payloadSHA256(req *http.Request) (string, *http.Request, error) {
+	if req.Body == nil || req.Body == http.NoBody {
+		return emptyPayloadHash, req, nil
+	}
+
+	if req.GetBody == nil {
+		req = BufferRequestBody(req)
+	}
+
+	body, err := req.GetBody()
+	if err != nil {
+		return "", req, err
+	}
+
+	defer body.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, body); err != nil {
+		return "", req, err
+	}
+
+	return hex.EncodeToString(hash.Sum(nil)), req, nil
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+
+	return mac.Sum(nil)
+}
+
+func hexHMAC(key []byte, data string) string {
+	return hex.EncodeToString(hmacSHA256(key, data))
+}
+
+func sha256Hex(data string) string {
+	sum := sha256.Sum256([]byte(data))
+
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,346 @@
+package httpaux
+
+import (
+	"bufio"
+	"bytes"
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"iter"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultSSERetry       = 3 * time.Second
+	defaultMaxSSELineSize = 1 << 20
+)
+
+var (
+	// ErrNotEventStream is returned by StreamSSE when a response is not a text/event-stream.
+	ErrNotEventStream = errors.New("httpaux: response is not an event stream")
+	// ErrEventLineTooLong is reported when a line of an event stream exceeds the maximum line size.
+	ErrEventLineTooLong = errors.New("httpaux: event stream line too long")
+)
+
+// SSEEvent is an event of a Server-Sent Events stream.
+type SSEEvent struct {
+	// ID is the last event ID of the stream when the event was dispatched.
+	ID string
+	// Event is the event type. Defaults to "message".
+	Event string
+	// Data is the event data, its lines joined with "\n".
+	Data string
+	// Retry is the reconnection time set by the event, or 0 if it did not set one.
+	Retry time.Duration
+}
+
+// ReadSSE parses r as a Server-Sent Events stream, as specified by the WHATWG HTML Living Standard, and returns an
+// iterator over its events. Comments and events without data are skipped and an incomplete event at the end of
+// the stream is discarded. The iterator yields the read error, if any, last, which is ErrEventLineTooLong for lines
+// longer than 1MiB.
+func ReadSSE(r io.Reader) iter.Seq2[SSEEvent, error] {
+	return func(yield func(SSEEvent, error) bool) {
+		parser := newSSEParser(r, defaultMaxSSELineSize)
+
+		for {
+			event, err := parser.next()
+			if errors.Is(err, io.EOF) {
+				return
+			}
+
+			if !yield(event, err) || err != nil {
+				return
+			}
+		}
+	}
+}
+
+// sseParser parses Server-Sent Events, keeping the state that outlives events.
+type sseParser struct {
+	r           *bufio.Reader
+	maxLineSize int
+	started     bool
+	// pendingCR reports whether the last line ended with a CR, so that a LF starting the next read completes a CRLF.
+	pendingCR   bool
+	lastEventID string
+	retry       time.Duration
+}
+
+func newSSEParser(r io.Reader, maxLineSize int) *sseParser {
+	return &sseParser{
+		r:           bufio.NewReader(r),
+		maxLineSize: maxLineSize,
+		started:     false,
+		pendingCR:   false,
+		lastEventID: "",
+		retry:       0,
+	}
+}
+
+// next returns the next event, or io.EOF at the end of the stream.
+func (p *sseParser) next() (SSEEvent, error) {
+	var (
+		data      strings.Builder
+		hasData   bool
+		eventType string
+		retry     time.Duration
+	)
+
+	for {
+		line, err := p.readLine()
+		if err != nil {
+			return SSEEvent{ID: "", Event: "", Data: "", Retry: 0}, err
+		}
+
+		if line == "" {
+			if !hasData {
+				eventType, retry = "", 0
+
+				continue
+			}
+
+			return SSEEvent{
+				ID:    p.lastEventID,
+				Event: cmp.Or(eventType, "message"),
+				Data:  strings.TrimSuffix(data.String(), "\n"),
+				Retry: retry,
+			}, nil
+		}
+
+		if strings.HasPrefix(line, ":") {
+			continue
+		}
+
+		field, value, _ := strings.Cut(line, ":")
+		value = strings.TrimPrefix(value, " ")
+
+		switch field {
+		case "event":
+			eventType = value
+		case "data":
+			data.WriteString(value)
+			data.WriteString("\n")
+
+			hasData = true
+		case "id":
+			if !strings.ContainsRune(value, 0) {
+				p.lastEventID = value
+			}
+		case "retry":
+			if ms, err := strconv.ParseUint(value, 10, 63); err == nil {
+				retry = time.Duration(ms) * time.Millisecond
+				p.retry = retry
+			}
+		}
+	}
+}
+
+// readLine reads a line terminated by CRLF, LF or CR, without its terminator. A line cut by the end of the stream
+// is incomplete and reported as io.EOF. A line ending with a CR is returned without waiting for the next byte, which
+// on a live stream may only come with the next event: a LF right after it is skipped by the next read instead.
+func (p *sseParser) readLine() (string, error) {
+	var line []byte
+
+	for {
+		b, err := p.r.ReadByte()
+		if err != nil {
+			return "", err
+		}
+
+		if p.pendingCR {
+			p.pendingCR = false
+
+			if b == '\n' {
+				continue
+			}
+		}
+
+		switch b {
+		case '\n':
+			return p.line(line), nil
+		case '\r':
+			p.pendingCR = true
+
+			return p.line(line), nil
+		default:
+			if len(line) >= p.maxLineSize {
+				return "", ErrEventLineTooLong
+			}
+
+			line = append(line, b)
+		}
+	}
+}
+
+// line strips the byte order mark from the first line of the stream.
+func (p *sseParser) line(line []byte) string {
+	if !p.started {
+		p.started = true
+		line = bytes.TrimPrefix(line, []byte("\uFEFF"))
+	}
+
+	return string(line)
+}
+
+// SSEConfig configures StreamSSE.
+type SSEConfig struct {
+	// Header is sent with every request.
+	Header http.Header
+	// LastEventID, if set, is sent in the Last-Event-ID header of the first request.
+	LastEventID string
+	// Retry is the reconnection time until the server sets one. Defaults to 3s.
+	Retry time.Duration
+	// MaxReconnects, if positive, is how many consecutive reconnections may fail before giving up.
+	MaxReconnects int
+	// MaxLineSize is the size of the longest line accepted, longer lines ending the iteration with
+	// ErrEventLineTooLong. Defaults to 1MiB.
+	MaxLineSize int
+}
+
+// StreamSSE connects to the Server-Sent Events stream at url through rt, a nil rt using http.DefaultTransport, and
+// returns an iterator over its events.
+//
+// When the connection fails or the stream ends, StreamSSE waits for the reconnection time advertised by the server
+// and reconnects, sending the ID of the last event received in the Last-Event-ID header. A response that is not a
+// 200 OK text/event-stream ends the iteration with an error, while 204 No Content ends it without one, as the
+// server asks clients to stop reconnecting that way. The body is closed when the iteration stops.
+func StreamSSE(ctx context.Context, rt http.RoundTripper, url string, config SSEConfig) iter.Seq2[SSEEvent, error] {
+	rt = transportOrDefault(rt)
+
+	if config.Retry == 0 {
+		config.Retry = defaultSSERetry
+	}
+
+	if config.MaxLineSize <= 0 {
+		config.MaxLineSize = defaultMaxSSELineSize
+	}
+
+	return func(yield func(SSEEvent, error) bool) {
+		lastEventID, retry, failures := config.LastEventID, config.Retry, 0
+		none := SSEEvent{ID: "", Event: "", Data: "", Retry: 0}
+
+		for {
+			resp, err := connectSSE(ctx, rt, url, config.Header, lastEventID)
+
+			switch {
+			case ctx.Err() != nil:
+				if resp != nil {
+					discardBody(resp.Body)
+				}
+
+				yield(none, ctx.Err())
+
+				return
+			case errors.Is(err, ErrNotEventStream) || errors.Is(err, ErrUnexpectedStatus):
+				yield(none, err)
+
+				return
+			case err == nil && resp.StatusCode == http.StatusNoContent:
+				discardBody(resp.Body)
+
+				return
+			case err == nil:
+				parser := newSSEParser(resp.Body, config.MaxLineSize)
+				parser.lastEventID = lastEventID
+
+				delivered, stopped, streamErr := streamSSEEvents(parser, yield)
+				_ = resp.Body.Close()
+
+				if stopped {
+					return
+				}
+
+				if errors.Is(streamErr, ErrEventLineTooLong) {
+					yield(none, streamErr)
+
+					return
+				}
+
+				if delivered {
+					failures = 0
+				}
+
+				lastEventID = parser.lastEventID
+				retry = cmp.Or(parser.retry, retry)
+			}
+
+			failures++
+			if config.MaxReconnects > 0 && failures > config.MaxReconnects {
+				yield(none, fmt.Errorf("httpaux: event stream lost: %w", cmp.Or(err, io.ErrUnexpectedEOF)))
+
+				return
+			}
+
+			if err := sleepContext(ctx, retry); err != nil {
+				yield(none, err)
+
+				return
+			}
+		}
+	}
+}
+
+// streamSSEEvents yields the events of parser until the stream ends, reporting whether any event was delivered,
+// whether the consumer stopped the iteration and the error that ended the stream.
+func streamSSEEvents(parser *sseParser, yield func(SSEEvent, error) bool) (delivered, stopped bool, err error) {
+	for {
+		event, err := parser.next()
+		if err != nil {
+			return delivered, false, err
+		}
+
+		if !yield(event, nil) {
+			return true, true, nil
+		}
+
+		delivered = true
+	}
+}
+
+func connectSSE(
+	ctx context.Context, rt http.RoundTripper, url string, header http.Header, lastEventID string,
+) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	for name, values := range header {
+		req.Header[name] = append([]string(nil), values...)
+	}
+
+	req.Header.Set("Accept", "text/event-stream")
+	req.Header.Set("Cache-Control", "no-cache")
+
+	if lastEventID != "" {
+		req.Header.Set("Last-Event-Id", lastEventID)
+	}
+
+	resp, err := rt.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case resp.StatusCode == http.StatusNoContent:
+		return resp, nil
+	case resp.StatusCode != http.StatusOK:
+		discardBody(resp.Body)
+
+		return nil, &UnexpectedStatusError{StatusCode: resp.StatusCode, Status: resp.Status}
+	}
+
+	if mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type")); mediaType != "text/event-stream" {
+		discardBody(resp.Body)
+
+		return nil, fmt.Errorf("%w: %q", ErrNotEventStream, resp.Header.Get("Content-Type"))
+	}
+
+	return resp, nil
+}
@@ -0,0 +1,271 @@
+package httpaux
+
+import (
+	"context"
+	"errors"
+	"io"
+	"iter"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/angrifel/unapologetic/internal/assert"
+	"github.com/angrifel/unapologetic/iospy"
+)
+
+func collectSSE(events iter.Seq2[SSEEvent, error]) ([]SSEEvent, error) {
+	var result []SSEEvent
+
+	for event, err := range events {
+		if err != nil {
+			return result, err
+		}
+
+		result = append(result, event)
+	}
+
+	return result, nil
+}
+
+func TestReadSSE(t *testing.T) {
+	t.Run("parses events", func(t *testing.T) {
+		// arrange
+		stream := "\uFEFF: a comment\n" +
+			"data: first\n" +
+			"data:second line\n" +
+			"\n" +
+			"event: update\r\n" +
+			"id: 42\r\n" +
+			"retry: 1500\r\n" +
+			"data: {\"n\":1}\r\n" +
+			"\r\n" +
+			"id\r" +
+			"data\r" +
+			"\r" +
+			"event: ignored without data\n" +
+			"\n" +
+			"data: incomplete"
+
+		// act
+		events, err := collectSSE(ReadSSE(strings.NewReader(stream)))
+
+		// assert
+		assert.Equal(t, nil, err)
+		assert.Equal(t, 3, len(events))
+		assert.Equal(t, SSEEvent{ID: "", Event: "message", Data: "first\nsecond line", Retry: 0}, events[0])
+		assert.Equal(t, SSEEvent{ID: "42", Event: "update", Data: `{"n":1}`, Retry: 1500 * time.Millisecond}, events[1])
+		assert.Equal(t, SSEEvent{ID: "", Event: "message", Data: "", Retry: 0}, events[2])
+	})
+
+	t.Run("ignores invalid retry values", func(t *testing.T) {
+		// act
+		events, _ := collectSSE(ReadSSE(strings.NewReader("retry: 1s\ndata: x\n\n")))
+
+		// assert
+		assert.Equal(t, time.Duration(0), events[0].Retry)
+	})
+
+	t.Run("yields read errors", func(t *testing.T) {
+		// arrange
+		errBroken := errors.New("broken")
+		r := iospy.LimitReaderWithError(strings.NewReader("data: a\n\ndata: b\n\n"), 10, errBroken)
+
+		// act
+		events, err := collectSSE(ReadSSE(r))
+
+		// assert
+		assert.Equal(t, errBroken, err)
+		assert.Equal(t, 1, len(events))
+	})
+
+	t.Run("dispatches CR-terminated events without waiting for the next byte", func(t *testing.T) {
+		// arrange
+		r, w := io.Pipe()
+		defer w.Close()
+
+		received := make(chan SSEEvent)
+
+		go func() {
+			for event, err := range ReadSSE(r) {
+				if err != nil {
+					return
+				}
+
+				received <- event
+			}
+		}()
+
+		// act
+		_, _ = w.Write([]byte("data: a\r\r"))
+
+		// assert
+		select {
+		case event := <-received:
+			assert.Equal(t, "a", event.Data)
+		case <-time.After(time.Second):
+			t.Fatal("event held back until the next byte")
+		}
+	})
+
+	t.Run("rejects lines that are too long", func(t *testing.T) {
+		// act
+		events, err := collectSSE(ReadSSE(strings.NewReader("data: " + strings.Repeat("x", defaultMaxSSELineSize))))
+
+		// assert
+		assert.Equal(t, ErrEventLineTooLong, err)
+		assert.Equal(t, 0, len(events))
+	})
+}
+
+func TestStreamSSE(t *testing.T) {
+	eventStream := func(status int, body io.ReadCloser) *http.Response {
+		return &http.Response{
+			StatusCode: status,
+			Header:     http.Header{"Content-Type": []string{"text/event-stream; charset=utf-8"}},
+			Body:       body,
+		}
+	}
+
+	t.Run("reconnects with the last event ID", func(t *testing.T) {
+		// arrange
+		var sent []*http.Request
+
+		streams := []string{
+			"retry: 1\nid: 1\ndata: one\n\n",
+			"id: 2\ndata: two\n\n",
+		}
+		rt := RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			sent = append(sent, req)
+
+			if len(sent) > len(streams) {
+				return eventStream(http.StatusNoContent, http.NoBody), nil
+			}
+
+			return eventStream(http.StatusOK, io.NopCloser(strings.NewReader(streams[len(sent)-1]))), nil
+		})
+
+		// act
+		events, err := collectSSE(StreamSSE(context.Background(), rt, "http://a.example/events", SSEConfig{
+			LastEventID: "0",
+			Retry:       time.Hour,
+		}))
+
+		// assert
+		assert.Equal(t, nil, err)
+		assert.Equal(t, 2, len(events))
+		assert.Equal(t, "one", events[0].Data)
+		assert.Equal(t, "two", events[1].Data)
+		assert.Equal(t, 3, len(sent))
+		assert.Equal(t, "0", sent[0].Header.Get("Last-Event-Id"))
+		assert.Equal(t, "1", sent[1].Header.Get("Last-Event-Id"))
+		assert.Equal(t, "2", sent[2].Header.Get("Last-Event-Id"))
+		assert.Equal(t, "text/event-stream", sent[0].Header.Get("Accept"))
+	})
+
+	t.Run("closes the body when stopped early", func(t *testing.T) {
+		// arrange
+		body := iospy.WitnessCloser(io.NopCloser(strings.NewReader("data: a\n\ndata: b\n\n")))
+		rt := RoundTripperFunc(func(*http.Request) (*http.Response, error) {
+			return eventStream(http.StatusOK, struct {
+				io.Reader
+				io.Closer
+			}{Reader: strings.NewReader("data: a\n\ndata: b\n\n"), Closer: body}), nil
+		})
+
+		// act
+		for range StreamSSE(context.Background(), rt, "http://a.example/events", SSEConfig{}) {
+			break
+		}
+
+		// assert
+		assert.Equal(t, 1, len(body.(iospy.CloserWitness).ObservedCloseCalls()))
+	})
+
+	t.Run("fails on responses that are not event streams", func(t *testing.T) {
+		// arrange
+		rt := RoundTripperFunc(func(*http.Request) (*http.Response, error) {
+			resp := eventStream(http.StatusOK, http.NoBody)
+			resp.Header.Set("Content-Type", "application/json")
+
+			return resp, nil
+		})
+
+		// act
+		_, err := collectSSE(StreamSSE(context.Background(), rt, "http://a.example/events", SSEConfig{}))
+
+		// assert
+		assert.Equal(t, true, errors.Is(err, ErrNotEventStream))
+	})
+
+	t.Run("fails on unexpected statuses", func(t *testing.T) {
+		// arrange
+		rt := RoundTripperFunc(func(*http.Request) (*http.Response, error) {
+			return eventStream(http.StatusForbidden, http.NoBody), nil
+		})
+
+		// act
+		_, err := collectSSE(StreamSSE(context.Background(), rt, "http://a.example/events", SSEConfig{}))
+
+		// assert
+		assert.Equal(t, true, errors.Is(err, ErrUnexpectedStatus))
+	})
+
+	t.Run("fails on lines longer than the limit without reconnecting", func(t *testing.T) {
+		// arrange
+		calls := 0
+		rt := RoundTripperFunc(func(*http.Request) (*http.Response, error) {
+			calls++
+
+			return eventStream(http.StatusOK, io.NopCloser(strings.NewReader("data: ok\n\ndata: too long\n\n"))), nil
+		})
+
+		// act
+		events, err := collectSSE(StreamSSE(context.Background(), rt, "http://a.example/events", SSEConfig{
+			Retry:       time.Millisecond,
+			MaxLineSize: 8,
+		}))
+
+		// assert
+		assert.Equal(t, true, errors.Is(err, ErrEventLineTooLong))
+		assert.Equal(t, 1, len(events))
+		assert.Equal(t, 1, calls)
+	})
+
+	t.Run("gives up after too many failed reconnections", func(t *testing.T) {
+		// arrange
+		errRefused := errors.New("connection refused")
+		calls := 0
+		rt := RoundTripperFunc(func(*http.Request) (*http.Response, error) {
+			calls++
+
+			return nil, errRefused
+		})
+
+		// act
+		_, err := collectSSE(StreamSSE(context.Background(), rt, "http://a.example/events", SSEConfig{
+			Retry:         time.Millisecond,
+			MaxReconnects: 2,
+		}))
+
+		// assert
+		assert.Equal(t, true, errors.Is(err, errRefused))
+		assert.Equal(t, 3, calls)
+	})
+
+	t.Run("stops when the context is done", func(t *testing.T) {
+		// arrange
+		ctx, cancel := context.WithCancel(context.Background())
+		rt := RoundTripperFunc(func(*http.Request) (*http.Response, error) {
+			cancel()
+
+			return eventStream(http.StatusOK, io.NopCloser(strings.NewReader(""))), nil
+		})
+
+		// act
+		_, err := collectSSE(StreamSSE(ctx, rt, "http://a.example/events", SSEConfig{}))
+
+		// assert
+		assert.Equal(t, context.Canceled, err)
+	})
+}
@@ -0,0 +1,32 @@
+package httpaux
+
+import (
+	"errors"
+	"strconv"
+)
+
+// ErrUnexpectedStatus is matched by UnexpectedStatusError when inspected with errors.Is.
+var ErrUnexpectedStatus = errors.New("httpaux: unexpected response status")
+
+// UnexpectedStatusError is returned by the helpers of this package when a response has a status code they cannot
+// handle. It matches ErrUnexpectedStatus when inspected with errors.Is.
+type UnexpectedStatusError struct {
+	// StatusCode is the status code of the response.
+	StatusCode int
+	// Status is the status line of the response, e.g. "404 Not Found".
+	Status string
+}
+
+func (e *UnexpectedStatusError) Error() string {
+	status := e.Status
+	if status == "" {
+		status = strconv.Itoa(e.StatusCode)
+	}
+
+	return "httpaux: unexpected response status " + status
+}
+
+// Is reports whether target is ErrUnexpectedStatus.
+func (e *UnexpectedStatusError) Is(target error) bool {
+	return target == ErrUnexpectedStatus //nolint:errorlint // sentinel identity is the intention
+}
@@ -0,0 +1,44 @@
+package httpaux
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/angrifel/unapologetic/internal/assert"
+)
+
+func TestUnexpectedStatusError(t *testing.T) {
+	t.Run("uses the status line", func(t *testing.T) {
+		// arrange
+		err := &UnexpectedStatusError{StatusCode: 404, Status: "404 Not Found"}
+
+		// act
+		message := err.Error()
+
+		// assert
+		assert.Equal(t, "httpaux: unexpected response status 404 Not Found", message)
+	})
+
+	t.Run("falls back to the status code", func(t *testing.T) {
+		// arrange
+		err := &UnexpectedStatusError{StatusCode: 599, Status: ""}
+
+		// act
+		message := err.Error()
+
+		// assert
+		assert.Equal(t, "httpaux: unexpected response status 599", message)
+	})
+
+	t.Run("matches ErrUnexpectedStatus", func(t *testing.T) {
+		// arrange
+		err := fmt.Errorf("wrapped: %w", &UnexpectedStatusError{StatusCode: 500, Status: ""})
+
+		// act
+		matches := errors.Is(err, ErrUnexpectedStatus)
+
+		// assert
+		assert.Equal(t, true, matches)
+	})
+}
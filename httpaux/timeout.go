@@ -0,0 +1,164 @@
+package httpaux
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+var (
+	// ErrHeaderTimeout is reported when the response headers of an attempt are not received in time.
+	ErrHeaderTimeout = errors.New("httpaux: timeout awaiting response headers")
+	// ErrBodyIdleTimeout is reported when a response body makes no progress in time.
+	ErrBodyIdleTimeout = errors.New("httpaux: response body idle timeout")
+)
+
+// AttemptTimeoutError is returned by TransportWithAttemptTimeout, and by the bodies of its responses, when a timeout
+// expires. It matches ErrHeaderTimeout or ErrBodyIdleTimeout with errors.Is, and reports true from Timeout, like the
+// timeout errors of net/http.
+type AttemptTimeoutError struct {
+	// Body reports whether the body idle timeout expired, rather than the header timeout.
+	Body bool
+	// Duration is the timeout that expired.
+	Duration time.Duration
+}
+
+func (e *AttemptTimeoutError) Error() string {
+	if e.Body {
+		return fmt.Sprintf("%v after %v", ErrBodyIdleTimeout, e.Duration)
+	}
+
+	return fmt.Sprintf("%v after %v", ErrHeaderTimeout, e.Duration)
+}
+
+// Is matches ErrHeaderTimeout or ErrBodyIdleTimeout, depending on Body.
+func (e *AttemptTimeoutError) Is(target error) bool {
+	//nolint:errorlint // sentinel identity is the intention
+	return (e.Body && target == ErrBodyIdleTimeout) || (!e.Body && target == ErrHeaderTimeout)
+}
+
+// Timeout reports true.
+func (e *AttemptTimeoutError) Timeout() bool {
+	return true
+}
+
+// AttemptTimeoutConfig configures TransportWithAttemptTimeout.
+type AttemptTimeoutConfig struct {
+	// HeaderTimeout, if positive, limits the time from sending a request to receiving its response headers.
+	HeaderTimeout time.Duration
+	// IdleTimeout, if positive, limits the time a read of the response body waits for data.
+	IdleTimeout time.Duration
+}
+
+type attemptTimeoutTransport struct {
+	next   http.RoundTripper
+	config AttemptTimeoutConfig
+}
+
+var _ http.RoundTripper = (*attemptTimeoutTransport)(nil)
+
+// TransportWithAttemptTimeout wraps next with an http.RoundTripper that bounds each round trip, rather than the whole
+// call as http.Client.Timeout does: the response headers must arrive within config.HeaderTimeout, and every read of
+// the response body must get data within config.IdleTimeout. Time spent by the caller between reads does not count,
+// so slow consumers are not penalized while slow-drip upstreams are cut off.
+//
+// An expired timeout cancels the round trip and is reported as an *AttemptTimeoutError. Retry middleware wrapped
+// around this transport gets a fresh budget for every attempt. A nil next uses http.DefaultTransport.
+func TransportWithAttemptTimeout(next http.RoundTripper, config AttemptTimeoutConfig) http.RoundTripper {
+	return &attemptTimeoutTransport{next: transportOrDefault(next), config: config}
+}
+
+// RoundTrip sends req, canceling it when a timeout expires.
+func (t *attemptTimeoutTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if t.config.HeaderTimeout <= 0 && t.config.IdleTimeout <= 0 {
+		return t.next.RoundTrip(req)
+	}
+
+	ctx, cancel := context.WithCancelCause(req.Context())
+
+	var headerTimer *time.Timer
+	if t.config.HeaderTimeout > 0 {
+		headerTimer = time.AfterFunc(t.config.HeaderTimeout, func() {
+			cancel(&AttemptTimeoutError{Body: false, Duration: t.config.HeaderTimeout})
+		})
+	}
+
+	resp, err := t.next.RoundTrip(req.WithContext(ctx))
+
+	if headerTimer != nil && !headerTimer.Stop() {
+		// the header timeout expired, even if the response made it in the meantime.
+		if resp != nil {
+			discardBody(resp.Body)
+		}
+
+		cancel(nil)
+
+		return nil, context.Cause(ctx)
+	}
+
+	if err != nil {
+		cancel(nil)
+
+		return nil, err
+	}
+
+	if resp.Body == nil || resp.Body == http.NoBody {
+		cancel(nil)
+
+		return resp, nil
+	}
+
+	if t.config.IdleTimeout <= 0 {
+		return CloneHTTPResponseWithBody(resp, bodyWithCloseHook(resp.Body, func() { cancel(nil) })), nil
+	}
+
+	body := &idleTimeoutBody{
+		ReadCloser: resp.Body,
+		ctx:        ctx,
+		cancel:     cancel,
+		idle:       t.config.IdleTimeout,
+		timer:      nil,
+	}
+
+	return CloneHTTPResponseWithBody(resp, body), nil
+}
+
+// idleTimeoutBody cancels its round trip when a read waits for data longer than idle.
+type idleTimeoutBody struct {
+	io.ReadCloser
+
+	ctx    context.Context //nolint:containedctx // the context of the round trip the body belongs to
+	cancel context.CancelCauseFunc
+	idle   time.Duration
+	timer  *time.Timer
+}
+
+func (b *idleTimeoutBody) Read(p []byte) (int, error) {
+	if b.timer == nil {
+		b.timer = time.AfterFunc(b.idle, func() { b.cancel(&AttemptTimeoutError{Body: true, Duration: b.idle}) })
+	} else {
+		b.timer.Reset(b.idle)
+	}
+
+	n, err := b.ReadCloser.Read(p)
+	b.timer.Stop()
+
+	if err != nil && !errors.Is(err, io.EOF) {
+		var timeoutErr *AttemptTimeoutError
+		if cause := context.Cause(b.ctx); errors.As(cause, &timeoutErr) {
+			err = cause
+		}
+	}
+
+	return n, err
+}
+
+func (b *idleTimeoutBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel(nil)
+
+	return err
+}
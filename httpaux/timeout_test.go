@@ -0,0 +1,132 @@
+package httpaux
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/angrifel/unapologetic/internal/assert"
+)
+
+func TestTransportWithAttemptTimeout(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		flusher := w.(http.Flusher)
+
+		switch r.URL.Path {
+		case "/slow-headers":
+			select {
+			case <-release:
+			case <-r.Context().Done():
+			}
+		case "/slow-body":
+			_, _ = w.Write([]byte("first"))
+			flusher.Flush()
+
+			select {
+			case <-release:
+			case <-r.Context().Done():
+			}
+		case "/drip":
+			for range 3 {
+				_, _ = w.Write([]byte("."))
+				flusher.Flush()
+				time.Sleep(20 * time.Millisecond)
+			}
+		}
+	}))
+
+	defer server.Close()
+	defer close(release)
+
+	get := func(t *testing.T, config AttemptTimeoutConfig, path string) (*http.Response, error) {
+		t.Helper()
+
+		client := &http.Client{Transport: TransportWithAttemptTimeout(nil, config)}
+		req, _ := http.NewRequestWithContext(context.Background(), http.MethodGet, server.URL+path, nil)
+
+		return client.Do(req)
+	}
+
+	t.Run("fails attempts without headers in time", func(t *testing.T) {
+		// act
+		_, err := get(t, AttemptTimeoutConfig{HeaderTimeout: 50 * time.Millisecond}, "/slow-headers")
+
+		// assert
+		var timeoutErr *AttemptTimeoutError
+
+		assert.Equal(t, true, errors.Is(err, ErrHeaderTimeout))
+		assert.Equal(t, true, errors.As(err, &timeoutErr))
+		assert.Equal(t, true, timeoutErr.Timeout())
+		assert.Equal(t, false, timeoutErr.Body)
+	})
+
+	t.Run("fails idle bodies", func(t *testing.T) {
+		// arrange
+		resp, err := get(t, AttemptTimeoutConfig{HeaderTimeout: time.Second, IdleTimeout: 50 * time.Millisecond},
+			"/slow-body")
+		assert.Equal(t, nil, err)
+
+		defer resp.Body.Close()
+
+		// act
+		data, err := io.ReadAll(resp.Body)
+
+		// assert
+		assert.Equal(t, "first", string(data))
+		assert.Equal(t, true, errors.Is(err, ErrBodyIdleTimeout))
+	})
+
+	t.Run("accepts bodies that keep making progress", func(t *testing.T) {
+		// arrange
+		resp, err := get(t, AttemptTimeoutConfig{IdleTimeout: 200 * time.Millisecond}, "/drip")
+		assert.Equal(t, nil, err)
+
+		defer resp.Body.Close()
+
+		// act
+		data, err := io.ReadAll(resp.Body)
+
+		// assert
+		assert.Equal(t, nil, err)
+		assert.Equal(t, "...", string(data))
+	})
+
+	t.Run("does not count the time between reads", func(t *testing.T) {
+		// arrange
+		resp, err := get(t, AttemptTimeoutConfig{HeaderTimeout: 30 * time.Millisecond, IdleTimeout: 30 * time.Millisecond},
+			"/drip")
+		assert.Equal(t, nil, err)
+
+		defer resp.Body.Close()
+
+		time.Sleep(100 * time.Millisecond)
+
+		// act
+		data, err := io.ReadAll(resp.Body)
+
+		// assert
+		assert.Equal(t, nil, err)
+		assert.Equal(t, "...", string(data))
+	})
+
+	t.Run("keeps errors of the caller context", func(t *testing.T) {
+		// arrange
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		defer cancel()
+
+		client := &http.Client{Transport: TransportWithAttemptTimeout(nil, AttemptTimeoutConfig{HeaderTimeout: time.Second})}
+		req, _ := http.NewRequestWithContext(ctx, http.MethodGet, server.URL+"/slow-headers", nil)
+
+		// act
+		_, err := client.Do(req)
+
+		// assert
+		assert.Equal(t, true, errors.Is(err, context.DeadlineExceeded))
+		assert.Equal(t, false, errors.Is(err, ErrHeaderTimeout))
+	})
+}
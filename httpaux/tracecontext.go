@@ -0,0 +1,439 @@
+package httpaux
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	// HeaderTraceParent is the W3C Trace Context header carrying the trace ID, parent span ID and trace flags.
+	HeaderTraceParent = "Traceparent"
+	// HeaderTraceState is the W3C Trace Context header carrying vendor-specific trace state.
+	HeaderTraceState = "Tracestate"
+)
+
+const (
+	traceParentLength   = 55
+	traceFlagSampled    = 0x01
+	maxTraceStateLength = 512
+	maxTraceStateItems  = 32
+	maxTraceStateKey    = 256
+	maxTraceStateValue  = 256
+)
+
+// ErrInvalidTraceParent is reported when a traceparent header is malformed.
+var ErrInvalidTraceParent = errors.New("httpaux: invalid traceparent")
+
+// SpanContext identifies a span of a trace, as propagated by the W3C Trace Context headers.
+type SpanContext struct {
+	// TraceID identifies the trace.
+	TraceID [16]byte
+	// SpanID identifies the span within the trace.
+	SpanID [8]byte
+	// Flags are the trace flags, e.g. whether the trace is sampled.
+	Flags byte
+	// TraceState is the vendor-specific trace state, as carried by the tracestate header.
+	TraceState string
+	// Remote reports whether the span context was received from another process.
+	Remote bool
+}
+
+// IsValid reports whether both the trace ID and the span ID are set.
+func (s SpanContext) IsValid() bool {
+	return s.TraceID != [16]byte{} && s.SpanID != [8]byte{}
+}
+
+// IsSampled reports whether the sampled trace flag is set.
+func (s SpanContext) IsSampled() bool {
+	return s.Flags&traceFlagSampled != 0
+}
+
+// TraceParent returns the traceparent header value of the span context.
+func (s SpanContext) TraceParent() string {
+	return fmt.Sprintf("00-%x-%x-%02x", s.TraceID, s.SpanID, s.Flags)
+}
+
+// ParseTraceParent parses a traceparent header value. Values of future versions are parsed by their version 00
+// prefix, as the specification requires. The returned span context is Remote.
+func ParseTraceParent(value string) (SpanContext, error) {
+	var span SpanContext
+
+	value = strings.TrimSpace(value)
+
+	if len(value) < traceParentLength || (len(value) > traceParentLength && value[traceParentLength] != '-') ||
+		value[2] != '-' || value[35] != '-' || value[52] != '-' {
+		return span, fmt.Errorf("%w: %q", ErrInvalidTraceParent, value)
+	}
+
+	version, ok := decodeLowerHex(value[0:2])
+	if !ok || version[0] == 0xff || (version[0] == 0 && len(value) != traceParentLength) {
+		return span, fmt.Errorf("%w: %q", ErrInvalidTraceParent, value)
+	}
+
+	traceID, traceOK := decodeLowerHex(value[3:35])
+	spanID, spanOK := decodeLowerHex(value[36:52])
+	flags, flagsOK := decodeLowerHex(value[53:55])
+
+	if !traceOK || !spanOK || !flagsOK {
+		return span, fmt.Errorf("%w: %q", ErrInvalidTraceParent, value)
+	}
+
+	copy(span.TraceID[:], traceID)
+	copy(span.SpanID[:], spanID)
+	span.Flags = flags[0]
+	span.Remote = true
+
+	if !span.IsValid() {
+		var invalid SpanContext
+
+		return invalid, fmt.Errorf("%w: %q", ErrInvalidTraceParent, value)
+	}
+
+	return span, nil
+}
+
+// decodeLowerHex decodes s, reporting false if it is not lower-case hexadecimal.
+func decodeLowerHex(s string) ([]byte, bool) {
+	if strings.ToLower(s) != s {
+		return nil, false
+	}
+
+	data, err := hex.DecodeString(s)
+
+	return data, err == nil
+}
+
+// ValidTraceState returns value, a tracestate header value, with its empty members removed, or "" if it is
+// malformed or has too many members.
+func ValidTraceState(value string) string {
+	members := make([]string, 0, maxTraceStateItems)
+	seen := make(map[string]bool, maxTraceStateItems)
+
+	for member := range strings.SplitSeq(value, ",") {
+		member = strings.Trim(member, " \t")
+		if member == "" {
+			continue
+		}
+
+		key, val, ok := strings.Cut(member, "=")
+		if !ok || !validTraceStateKey(key) || !validTraceStateValue(val) || seen[key] {
+			return ""
+		}
+
+		seen[key] = true
+		members = append(members, member)
+	}
+
+	if len(members) > maxTraceStateItems {
+		return ""
+	}
+
+	result := strings.Join(members, ",")
+	if len(result) > maxTraceStateLength {
+		return ""
+	}
+
+	return result
+}
+
+func validTraceStateKey(key string) bool {
+	tenant, system, multiTenant := strings.Cut(key, "@")
+	if !multiTenant {
+		return len(key) <= maxTraceStateKey && isTraceStateKeyPart(key, true)
+	}
+
+	return len(key) <= maxTraceStateKey && isTraceStateKeyPart(tenant, false) && isTraceStateKeyPart(system, true)
+}
+
+// isTraceStateKeyPart reports whether s is made of lower-case letters, digits and '_', '-', '*', '/', starting with
+// a lower-case letter, or also a digit when alphaFirst is false.
+func isTraceStateKeyPart(s string, alphaFirst bool) bool {
+	if s == "" || (alphaFirst && (s[0] < 'a' || s[0] > 'z')) {
+		return false
+	}
+
+	for _, c := range []byte(s) {
+		if (c < 'a' || c > 'z') && (c < '0' || c > '9') && c != '_' && c != '-' && c != '*' && c != '/' {
+			return false
+		}
+	}
+
+	return true
+}
+
+func validTraceStateValue(value string) bool {
+	if value == "" || len(value) > maxTraceStateValue || value[len(value)-1] == ' ' {
+		return false
+	}
+
+	for _, c := range []byte(value) {
+		if c < ' ' || c > '~' || c == ',' || c == '=' {
+			return false
+		}
+	}
+
+	return true
+}
+
+type spanContextKey struct{}
+
+// ContextWithSpan returns a copy of ctx carrying span, which becomes the parent of the spans started from it.
+func ContextWithSpan(ctx context.Context, span SpanContext) context.Context {
+	return context.WithValue(ctx, spanContextKey{}, span)
+}
+
+// SpanFromContext returns the span carried by ctx, reporting false if there is none.
+func SpanFromContext(ctx context.Context) (SpanContext, bool) {
+	span, ok := ctx.Value(spanContextKey{}).(SpanContext)
+
+	return span, ok
+}
+
+// SpanKind tells whether a span is the client or the server side of a request.
+type SpanKind int
+
+const (
+	// SpanKindClient is the span of an outgoing request.
+	SpanKindClient SpanKind = iota + 1
+	// SpanKindServer is the span of an incoming request.
+	SpanKindServer
+)
+
+// Span describes a span started by TransportWithTraceContext or HandlerWithTraceContext.
+type Span struct {
+	// Context identifies the span.
+	Context SpanContext
+	// Parent identifies the parent span. It is the zero value for root spans.
+	Parent SpanContext
+	// Kind is the side of the request the span covers.
+	Kind SpanKind
+	// Request is the request of the span. It must not be modified.
+	Request *http.Request
+	// Start is the time the span started.
+	Start time.Time
+}
+
+// SpanOutcome is how a span ended.
+type SpanOutcome struct {
+	// End is the time the span ended.
+	End time.Time
+	// StatusCode is the status code of the response, or zero if there is none.
+	StatusCode int
+	// Err is the error of the round trip, for client spans.
+	Err error
+}
+
+// SpanHook is notified of the spans started and ended, e.g. to export them. Implementations must be safe for
+// concurrent use and should return quickly.
+type SpanHook interface {
+	// OnStart is called when span starts.
+	OnStart(ctx context.Context, span Span)
+	// OnEnd is called when span ends.
+	OnEnd(ctx context.Context, span Span, outcome SpanOutcome)
+}
+
+// TraceContextConfig configures TransportWithTraceContext and HandlerWithTraceContext.
+type TraceContextConfig struct {
+	// Hook, if set, is notified of the spans.
+	Hook SpanHook
+	// Sample decides whether a new trace, started by a request without a parent span, is sampled. Defaults to
+	// sampling every trace.
+	Sample func(req *http.Request) bool
+	// Now returns the current time. Defaults to time.Now.
+	Now func() time.Time
+}
+
+func (c TraceContextConfig) withDefaults() TraceContextConfig {
+	if c.Sample == nil {
+		c.Sample = func(*http.Request) bool { return true }
+	}
+
+	if c.Now == nil {
+		c.Now = time.Now
+	}
+
+	return c
+}
+
+// startSpan returns a new span, child of parent if it is valid, or else the root of a new trace.
+func (c TraceContextConfig) startSpan(parent SpanContext, kind SpanKind, req *http.Request) Span {
+	span := Span{Context: parent, Parent: parent, Kind: kind, Request: req, Start: c.Now()}
+
+	if !parent.IsValid() {
+		var root SpanContext
+
+		span.Parent = root
+		span.Context = root
+		_, _ = rand.Read(span.Context.TraceID[:])
+
+		if c.Sample(req) {
+			span.Context.Flags = traceFlagSampled
+		}
+	}
+
+	span.Context.Remote = false
+	_, _ = rand.Read(span.Context.SpanID[:])
+
+	return span
+}
+
+type traceContextTransport struct {
+	next   http.RoundTripper
+	config TraceContextConfig
+}
+
+var (
+	_ http.RoundTripper   = (*traceContextTransport)(nil)
+	_ http.Handler        = (*traceContextHandler)(nil)
+	_ responseInterceptor = (*statusRecorder)(nil)
+)
+
+// TransportWithTraceContext wraps next with an http.RoundTripper that starts a client span for every request, child
+// of the span carried by the request context, if any, and propagates it with the traceparent and tracestate headers.
+// The span ends when the response headers are received. Requests that already carry a traceparent header are passed
+// through. A nil next uses http.DefaultTransport.
+func TransportWithTraceContext(next http.RoundTripper, config TraceContextConfig) http.RoundTripper {
+	return &traceContextTransport{next: transportOrDefault(next), config: config.withDefaults()}
+}
+
+// RoundTrip sends req with the trace context headers of a new client span.
+func (t *traceContextTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Header.Get(HeaderTraceParent) != "" {
+		return t.next.RoundTrip(req)
+	}
+
+	ctx := req.Context()
+	parent, _ := SpanFromContext(ctx)
+	span := t.config.startSpan(parent, SpanKindClient, req)
+
+	out := req.Clone(ContextWithSpan(ctx, span.Context))
+	out.Header.Set(HeaderTraceParent, span.Context.TraceParent())
+	out.Header.Del(HeaderTraceState)
+
+	if span.Context.TraceState != "" {
+		out.Header.Set(HeaderTraceState, span.Context.TraceState)
+	}
+
+	if t.config.Hook != nil {
+		t.config.Hook.OnStart(ctx, span)
+	}
+
+	resp, err := t.next.RoundTrip(out)
+
+	if t.config.Hook != nil {
+		outcome := SpanOutcome{End: t.config.Now(), StatusCode: 0, Err: err}
+		if resp != nil {
+			outcome.StatusCode = resp.StatusCode
+		}
+
+		t.config.Hook.OnEnd(ctx, span, outcome)
+	}
+
+	return resp, err
+}
+
+type traceContextHandler struct {
+	next   http.Handler
+	config TraceContextConfig
+}
+
+// HandlerWithTraceContext wraps next with an http.Handler that starts a server span for every request, child of the
+// span received in valid traceparent and tracestate headers, or else the root of a new trace, and stores it in the
+// request context, where SpanFromContext finds it and TransportWithTraceContext continues it.
+// The span ends when next returns.
+func HandlerWithTraceContext(next http.Handler, config TraceContextConfig) http.Handler {
+	if next == nil {
+		panic("next must not be nil")
+	}
+
+	return &traceContextHandler{next: next, config: config.withDefaults()}
+}
+
+func (h *traceContextHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	parent, err := ParseTraceParent(r.Header.Get(HeaderTraceParent))
+	if err == nil {
+		parent.TraceState = ValidTraceState(strings.Join(r.Header.Values(HeaderTraceState), ","))
+	}
+
+	span := h.config.startSpan(parent, SpanKindServer, r)
+	ctx := ContextWithSpan(r.Context(), span.Context)
+	recorder := &statusRecorder{ResponseWriter: w, statusCode: 0}
+
+	if h.config.Hook != nil {
+		h.config.Hook.OnStart(ctx, span)
+
+		defer func() {
+			h.config.Hook.OnEnd(ctx, span, SpanOutcome{End: h.config.Now(), StatusCode: recorder.status(), Err: nil})
+		}()
+	}
+
+	h.next.ServeHTTP(exposeCapabilities(w, recorder), r.WithContext(ctx))
+}
+
+// statusRecorder remembers the status code written to a ResponseWriter.
+type statusRecorder struct {
+	http.ResponseWriter
+
+	statusCode int
+}
+
+func (w *statusRecorder) WriteHeader(statusCode int) {
+	if w.statusCode == 0 && statusCode >= http.StatusOK {
+		w.statusCode = statusCode
+	}
+
+	w.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (w *statusRecorder) Write(p []byte) (int, error) {
+	if w.statusCode == 0 {
+		w.statusCode = http.StatusOK
+	}
+
+	return w.ResponseWriter.Write(p)
+}
+
+// Unwrap returns the wrapped ResponseWriter, for http.ResponseController.
+func (w *statusRecorder) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// flush flushes the wrapped ResponseWriter.
+func (w *statusRecorder) flush() {
+	if w.statusCode == 0 {
+		w.statusCode = http.StatusOK
+	}
+
+	_ = http.NewResponseController(w.ResponseWriter).Flush()
+}
+
+// hijack hijacks the connection of the wrapped ResponseWriter.
+func (w *statusRecorder) hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, rw, err := http.NewResponseController(w.ResponseWriter).Hijack()
+	if err != nil {
+		return nil, nil, fmt.Errorf("httpaux: hijacking connection: %w", err)
+	}
+
+	if w.statusCode == 0 {
+		w.statusCode = http.StatusSwitchingProtocols
+	}
+
+	return conn, rw, nil
+}
+
+// status returns the status code written, which is 200 OK if next wrote nothing.
+func (w *statusRecorder) status() int {
+	if w.statusCode == 0 {
+		return http.StatusOK
+	}
+
+	return w.statusCode
+}
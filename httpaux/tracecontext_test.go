@@ -0,0 +1,286 @@
+package httpaux
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/angrifel/unapologetic/internal/assert"
+)
+
+type recordedSpans struct {
+	mu       sync.Mutex
+	started  []Span
+	outcomes []SpanOutcome
+}
+
+func (r *recordedSpans) OnStart(_ context.Context, span Span) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.started = append(r.started, span)
+}
+
+func (r *recordedSpans) OnEnd(_ context.Context, _ Span, outcome SpanOutcome) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.outcomes = append(r.outcomes, outcome)
+}
+
+func TestParseTraceParent(t *testing.T) {
+	t.Run("parses valid values", func(t *testing.T) {
+		// act
+		span, err := ParseTraceParent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+
+		// assert
+		assert.Equal(t, nil, err)
+		assert.Equal(t, true, span.IsValid())
+		assert.Equal(t, true, span.IsSampled())
+		assert.Equal(t, true, span.Remote)
+		assert.Equal(t, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", span.TraceParent())
+	})
+
+	t.Run("parses future versions by their prefix", func(t *testing.T) {
+		// act
+		span, err := ParseTraceParent("cc-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00-extra")
+
+		// assert
+		assert.Equal(t, nil, err)
+		assert.Equal(t, false, span.IsSampled())
+	})
+
+	t.Run("rejects invalid values", func(t *testing.T) {
+		for _, value := range []string{
+			"",
+			"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra",
+			"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+			"00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01",
+			"00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+			"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01",
+			"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-0x",
+			"00_4bf92f3577b34da6a3ce929d0e0e4736_00f067aa0ba902b7_01",
+		} {
+			// act
+			_, err := ParseTraceParent(value)
+
+			// assert
+			assert.Equal(t, true, errors.Is(err, ErrInvalidTraceParent))
+		}
+	})
+}
+
+func TestValidTraceState(t *testing.T) {
+	t.Run("keeps valid members", func(t *testing.T) {
+		// act
+		state := ValidTraceState("rojo=00f067aa0ba902b7, ,tenant@vendor=t61rcWkgMzE")
+
+		// assert
+		assert.Equal(t, "rojo=00f067aa0ba902b7,tenant@vendor=t61rcWkgMzE", state)
+	})
+
+	t.Run("drops invalid values", func(t *testing.T) {
+		for _, value := range []string{
+			"Upper=1",
+			"key=",
+			"key=a=b",
+			"dup=1,dup=2",
+			strings.Repeat("k=v,", 33),
+		} {
+			// act
+			state := ValidTraceState(value)
+
+			// assert
+			assert.Equal(t, "", state)
+		}
+	})
+}
+
+func TestTraceContextPropagation(t *testing.T) {
+	t.Run("continues the incoming trace in outgoing requests", func(t *testing.T) {
+		// arrange
+		var (
+			downstreamParent string
+			downstreamState  string
+		)
+
+		downstream := httptest.NewServer(http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+			downstreamParent = r.Header.Get(HeaderTraceParent)
+			downstreamState = r.Header.Get(HeaderTraceState)
+		}))
+		defer downstream.Close()
+
+		clientSpans := &recordedSpans{}
+		client := &http.Client{Transport: TransportWithTraceContext(nil, TraceContextConfig{Hook: clientSpans})}
+		serverSpans := &recordedSpans{}
+		handler := HandlerWithTraceContext(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			req, _ := http.NewRequestWithContext(r.Context(), http.MethodGet, downstream.URL, nil)
+
+			resp, err := client.Do(req)
+			assert.Equal(t, nil, err)
+
+			_ = resp.Body.Close()
+
+			w.WriteHeader(http.StatusAccepted)
+		}), TraceContextConfig{Hook: serverSpans})
+
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set(HeaderTraceParent, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+		req.Header.Set(HeaderTraceState, "rojo=00f067aa0ba902b7")
+
+		// act
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+
+		// assert
+		parent, err := ParseTraceParent(downstreamParent)
+		assert.Equal(t, nil, err)
+
+		server := serverSpans.started[0]
+		clientSpan := clientSpans.started[0]
+
+		assert.Equal(t, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", server.Parent.TraceParent())
+		assert.Equal(t, server.Parent.TraceID, server.Context.TraceID)
+		assert.Equal(t, server.Context.SpanID, clientSpan.Parent.SpanID)
+		assert.Equal(t, clientSpan.Context.SpanID, parent.SpanID)
+		assert.Equal(t, server.Context.TraceID, parent.TraceID)
+		assert.Equal(t, true, parent.IsSampled())
+		assert.Equal(t, "rojo=00f067aa0ba902b7", downstreamState)
+		assert.Equal(t, SpanKindServer, server.Kind)
+		assert.Equal(t, SpanKindClient, clientSpan.Kind)
+		assert.Equal(t, http.StatusAccepted, serverSpans.outcomes[0].StatusCode)
+		assert.Equal(t, http.StatusOK, clientSpans.outcomes[0].StatusCode)
+	})
+
+	t.Run("starts new traces for invalid parents", func(t *testing.T) {
+		// arrange
+		var span SpanContext
+
+		handler := HandlerWithTraceContext(http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+			span, _ = SpanFromContext(r.Context())
+		}), TraceContextConfig{Sample: func(*http.Request) bool { return false }})
+
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set(HeaderTraceParent, "garbage")
+		req.Header.Set(HeaderTraceState, "rojo=1")
+
+		// act
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+
+		// assert
+		assert.Equal(t, true, span.IsValid())
+		assert.Equal(t, false, span.IsSampled())
+		assert.Equal(t, "", span.TraceState)
+	})
+
+	t.Run("leaves requests with their own traceparent alone", func(t *testing.T) {
+		// arrange
+		var sent string
+
+		transport := TransportWithTraceContext(RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			sent = req.Header.Get(HeaderTraceParent)
+
+			return &http.Response{StatusCode: http.StatusOK, Body: http.NoBody, Request: req}, nil
+		}), TraceContextConfig{})
+
+		req, _ := http.NewRequestWithContext(context.Background(), http.MethodGet, "http://example.com", nil)
+		req.Header.Set(HeaderTraceParent, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+
+		// act
+		_, err := transport.RoundTrip(req)
+
+		// assert
+		assert.Equal(t, nil, err)
+		assert.Equal(t, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", sent)
+	})
+
+	t.Run("reports round trip errors to the hook", func(t *testing.T) {
+		// arrange
+		errBroken := errors.New("broken")
+		spans := &recordedSpans{}
+		transport := TransportWithTraceContext(RoundTripperFunc(func(*http.Request) (*http.Response, error) {
+			return nil, errBroken
+		}), TraceContextConfig{Hook: spans})
+
+		req, _ := http.NewRequestWithContext(context.Background(), http.MethodGet, "http://example.com", nil)
+
+		// act
+		_, err := transport.RoundTrip(req)
+
+		// assert
+		assert.Equal(t, true, errors.Is(err, errBroken))
+		assert.Equal(t, 1, len(spans.started))
+		assert.Equal(t, true, spans.started[0].Context.IsValid())
+		assert.Equal(t, errBroken, spans.outcomes[0].Err)
+	})
+
+	t.Run("keeps flushing and hijacking available to handlers", func(t *testing.T) {
+		// arrange
+		spans := &recordedSpans{}
+		flushed := make(chan bool, 1)
+		served := make(chan struct{}, 2)
+		handler := HandlerWithTraceContext(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path == "/flush" {
+				_, ok := w.(http.Flusher)
+				flushed <- ok
+
+				return
+			}
+
+			conn, rw, err := w.(http.Hijacker).Hijack()
+			if err != nil {
+				return
+			}
+
+			defer conn.Close()
+
+			_, _ = rw.WriteString("HTTP/1.1 204 No Content\r\nConnection: close\r\n\r\n")
+			_ = rw.Flush()
+		}), TraceContextConfig{Hook: spans})
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			handler.ServeHTTP(w, r)
+			served <- struct{}{}
+		}))
+
+		defer server.Close()
+
+		// act
+		flushResp, flushErr := server.Client().Get(server.URL + "/flush")
+		hijackResp, hijackErr := server.Client().Get(server.URL + "/hijack")
+
+		// assert
+		assert.Equal(t, nil, flushErr)
+		assert.Equal(t, nil, hijackErr)
+		assert.Equal(t, true, <-flushed)
+		assert.Equal(t, http.StatusNoContent, hijackResp.StatusCode)
+
+		_ = flushResp.Body.Close()
+		_ = hijackResp.Body.Close()
+
+		<-served
+		<-served
+
+		assert.Equal(t, http.StatusOK, spans.outcomes[0].StatusCode)
+		assert.Equal(t, http.StatusSwitchingProtocols, spans.outcomes[1].StatusCode)
+	})
+	t.Run("does not claim capabilities the writer lacks", func(t *testing.T) {
+		// arrange
+		var flusher, hijacker bool
+
+		handler := HandlerWithTraceContext(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			_, flusher = w.(http.Flusher)
+			_, hijacker = w.(http.Hijacker)
+		}), TraceContextConfig{})
+		w := struct{ http.ResponseWriter }{httptest.NewRecorder()}
+
+		// act
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+
+		// assert
+		assert.Equal(t, false, flusher)
+		assert.Equal(t, false, hijacker)
+	})
+}
@@ -0,0 +1,96 @@
+package httpaux
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// drainLimit is the maximum number of bytes read from a discarded response body so its connection can be reused.
+const drainLimit = 64 << 10
+
+// transportOrDefault returns next, or http.DefaultTransport when next is nil.
+func transportOrDefault(next http.RoundTripper) http.RoundTripper {
+	if next == nil {
+		return http.DefaultTransport
+	}
+
+	return next
+}
+
+// closeHookBody calls hook exactly once, after the first call to Close.
+type closeHookBody struct {
+	io.ReadCloser
+	once sync.Once
+	hook func()
+}
+
+// bodyWithCloseHook wraps body so that hook runs exactly once, after the first call to Close.
+func bodyWithCloseHook(body io.ReadCloser, hook func()) io.ReadCloser {
+	return &closeHookBody{ReadCloser: body, once: sync.Once{}, hook: hook}
+}
+
+func (b *closeHookBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.once.Do(b.hook)
+
+	return err
+}
+
+// sleepContext waits for d or until ctx is done, whichever happens first.
+func sleepContext(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// closeRequestBody closes the request body, as RoundTrip must, when the request is not forwarded.
+func closeRequestBody(req *http.Request) {
+	if req.Body != nil {
+		_ = req.Body.Close()
+	}
+}
+
+// discardBody drains a bounded amount of body, so the underlying connection can be reused, and closes it.
+func discardBody(body io.ReadCloser) {
+	if body == nil {
+		return
+	}
+
+	_, _ = io.CopyN(io.Discard, body, drainLimit)
+	_ = body.Close()
+}
+
+// rewindRequest returns a clone of req, which has already been sent, with a fresh body obtained from GetBody.
+// It reports false if req has a body that cannot be replayed.
+func rewindRequest(req *http.Request) (*http.Request, bool) {
+	if req.Body == nil || req.Body == http.NoBody {
+		return req.Clone(req.Context()), true
+	}
+
+	if req.GetBody == nil {
+		return nil, false
+	}
+
+	body, err := req.GetBody()
+	if err != nil {
+		return nil, false
+	}
+
+	rewound := req.Clone(req.Context())
+	rewound.Body = body
+
+	return rewound, true
+}
@@ -0,0 +1,185 @@
+package httpaux
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/angrifel/unapologetic/httpaux/httpauxtest"
+)
+
+func TestTransportsDoNotMutateRequests(t *testing.T) {
+	newRequest := func(method, body string) *http.Request {
+		var req *http.Request
+		if body == "" {
+			req, _ = http.NewRequestWithContext(context.Background(), method, "https://api.example.com/v1/items?a=1", nil)
+		} else {
+			req, _ = http.NewRequestWithContext(context.Background(), method, "https://api.example.com/v1/items?a=1",
+				strings.NewReader(body))
+		}
+
+		req.Header.Set("X-Original", "yes")
+
+		return req
+	}
+
+	payload := strings.Repeat("payload ", 256)
+
+	replayed := &HAR{Log: HARLog{Version: HARVersion, Entries: []HAREntry{{
+		StartedDateTime: time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC),
+		Request:         HARRequest{Method: http.MethodGet, URL: "https://api.example.com/v1/items?a=1", HTTPVersion: "HTTP/1.1"},
+		Response:        HARResponse{Status: http.StatusOK, StatusText: "OK", HTTPVersion: "HTTP/1.1"},
+	}}}}
+
+	for name, tc := range map[string]struct {
+		wrap func(next http.RoundTripper) http.RoundTripper
+		req  *http.Request
+	}{
+		"metrics": {
+			wrap: func(next http.RoundTripper) http.RoundTripper { return TransportWithMetrics(next, &InMemoryMetrics{}) },
+			req:  newRequest(http.MethodPost, payload),
+		},
+		"circuit breaker": {
+			wrap: func(next http.RoundTripper) http.RoundTripper {
+				return TransportWithCircuitBreaker(next, CircuitBreakerConfig{})
+			},
+			req: newRequest(http.MethodGet, ""),
+		},
+		"rate limit": {
+			wrap: func(next http.RoundTripper) http.RoundTripper {
+				return TransportWithRateLimit(next, RateLimitConfig{})
+			},
+			req: newRequest(http.MethodGet, ""),
+		},
+		"coalescing": {
+			wrap: func(next http.RoundTripper) http.RoundTripper {
+				return TransportWithCoalescing(next, CoalesceConfig{})
+			},
+			req: newRequest(http.MethodGet, ""),
+		},
+		"hedging": {
+			wrap: func(next http.RoundTripper) http.RoundTripper {
+				return TransportWithHedging(next, HedgeConfig{Delay: time.Second})
+			},
+			req: newRequest(http.MethodGet, ""),
+		},
+		"chaos": {
+			wrap: func(next http.RoundTripper) http.RoundTripper {
+				return TransportWithFaults(next, ChaosConfig{Rules: []FaultRule{{
+					Probability: 1,
+					Fault:       Fault{Latency: time.Millisecond},
+				}}})
+			},
+			req: newRequest(http.MethodGet, ""),
+		},
+		"HAR capture": {
+			wrap: func(next http.RoundTripper) http.RoundTripper { return TransportWithHARCapture(next, &HARRecorder{}) },
+			req:  newRequest(http.MethodPost, payload),
+		},
+		"HAR replay": {
+			wrap: func(http.RoundTripper) http.RoundTripper { return TransportFromHAR(replayed) },
+			req:  newRequest(http.MethodGet, ""),
+		},
+		"bearer token": {
+			wrap: func(next http.RoundTripper) http.RoundTripper {
+				return TransportWithBearerToken(next, BearerConfig{Source: StaticToken("token")})
+			},
+			req: newRequest(http.MethodPost, payload),
+		},
+		"revalidation": {
+			wrap: func(next http.RoundTripper) http.RoundTripper {
+				return TransportWithRevalidation(next, RevalidationConfig{})
+			},
+			req: newRequest(http.MethodGet, ""),
+		},
+		"digest verification": {
+			wrap: func(next http.RoundTripper) http.RoundTripper {
+				return TransportWithDigestVerification(next, DigestVerificationConfig{})
+			},
+			req: newRequest(http.MethodGet, ""),
+		},
+		"content length check": {
+			wrap: TransportWithContentLengthCheck,
+			req:  newRequest(http.MethodGet, ""),
+		},
+		"basic auth": {
+			wrap: func(next http.RoundTripper) http.RoundTripper { return TransportWithBasicAuth(next, "user", "pass") },
+			req:  newRequest(http.MethodGet, ""),
+		},
+		"API key": {
+			wrap: func(next http.RoundTripper) http.RoundTripper {
+				return TransportWithAPIKey(next, APIKeyConfig{Header: "", QueryParam: "key", Value: "secret"})
+			},
+			req: newRequest(http.MethodGet, ""),
+		},
+		"request defaults": {
+			wrap: func(next http.RoundTripper) http.RoundTripper {
+				return TransportWithRequestDefaults(next, RequestDefaultsConfig{Rules: []RequestDefaultsRule{{
+					Host:           "api.example.com",
+					URLPrefix:      "",
+					Header:         http.Header{"User-Agent": {UserAgent("app", "1.0.0")}},
+					OverrideHeader: http.Header{"X-Original": {"no"}},
+					Query:          url.Values{"b": {"2"}},
+				}}})
+			},
+			req: newRequest(http.MethodGet, ""),
+		},
+		"trace context": {
+			wrap: func(next http.RoundTripper) http.RoundTripper {
+				return TransportWithTraceContext(next, TraceContextConfig{})
+			},
+			req: newRequest(http.MethodGet, ""),
+		},
+		"idempotency key": {
+			wrap: func(next http.RoundTripper) http.RoundTripper {
+				return TransportWithIdempotencyKey(next, IdempotencyKeyConfig{})
+			},
+			req: newRequest(http.MethodPost, payload),
+		},
+		"request compression": {
+			wrap: func(next http.RoundTripper) http.RoundTripper {
+				return TransportWithRequestCompression(next, RequestCompressionConfig{})
+			},
+			req: newRequest(http.MethodPost, payload),
+		},
+		"content digest": {
+			wrap: func(next http.RoundTripper) http.RoundTripper {
+				return TransportWithContentDigest(next, ContentDigestConfig{})
+			},
+			req: newRequest(http.MethodPut, payload),
+		},
+		"decompression": {
+			wrap: func(next http.RoundTripper) http.RoundTripper {
+				return TransportWithDecompression(next, DecompressionConfig{})
+			},
+			req: newRequest(http.MethodGet, ""),
+		},
+		"attempt timeout": {
+			wrap: func(next http.RoundTripper) http.RoundTripper {
+				return TransportWithAttemptTimeout(next, AttemptTimeoutConfig{HeaderTimeout: time.Second})
+			},
+			req: newRequest(http.MethodGet, ""),
+		},
+		"HMAC signature": {
+			wrap: func(next http.RoundTripper) http.RoundTripper {
+				return TransportWithHMACSignature(next, HMACConfig{KeyID: "key", Secret: []byte("secret")})
+			},
+			req: newRequest(http.MethodPost, payload),
+		},
+		"SigV4": {
+			wrap: func(next http.RoundTripper) http.RoundTripper {
+				return TransportWithSigV4(next, SigV4Config{
+					AccessKeyID: "AKID", SecretAccessKey: "secret", Region: "us-east-1", Service: "execute-api",
+				})
+			},
+			req: newRequest(http.MethodPost, payload),
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			httpauxtest.AssertRequestNotMutated(t, tc.wrap, tc.req)
+		})
+	}
+}